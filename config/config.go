@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Struct tags read by the loaders. yaml names a field in YAML files, env names the environment variable
+// holding it (appended to the prefix given to LoadEnv) and default holds the value used when neither sets it
+const (
+	YAML_TAG    = "yaml"
+	ENV_TAG     = "env"
+	DEFAULT_TAG = "default"
+)
+
+// Validator is implemented by configuration structs that can check their own fields
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes a single missing or invalid configuration field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError holds every problem found while validating a configuration, so they can all be
+// reported at once
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		problems[i] = fmt.Sprintf("%s %s", f.Field, f.Message)
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+// Add records a problem with field
+func (e *ValidationError) Add(field string, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Require records a problem with field if value is empty
+func (e *ValidationError) Require(field string, value string) {
+	if value == "" {
+		e.Add(field, "is required")
+	}
+}
+
+// AbsoluteURL records a problem with field if value is set but isn't an absolute URL
+func (e *ValidationError) AbsoluteURL(field string, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		e.Add(field, "must be an absolute URL, got '%s'", value)
+	}
+}
+
+// Err returns e if any problem was recorded and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate returns cfg.Validate() when cfg implements Validator, and nil otherwise
+func Validate(cfg any) error {
+	if v, ok := cfg.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// SetIfNotEmpty sets key to value in m unless value is empty, for building the configuration maps read by
+// the adapters from configuration structs
+func SetIfNotEmpty(m map[string]string, key string, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+// ApplyDefaults sets every empty field of the struct pointed to by cfg to the value of its default tag
+func ApplyDefaults(cfg any) error {
+	return eachField(cfg, func(field reflect.StructField, value reflect.Value) error {
+		def, ok := field.Tag.Lookup(DEFAULT_TAG)
+		if !ok || !value.IsZero() {
+			return nil
+		}
+		if err := setField(value, def); err != nil {
+			return fmt.Errorf("invalid default for %s: %v", field.Name, err)
+		}
+		return nil
+	})
+}
+
+// LoadEnv applies the defaults of the struct pointed to by cfg and then sets each field with an env tag
+// from the environment variable named prefix + tag, e.g. with prefix "DB_" the field tagged env:"HOST" is
+// read from DB_HOST. Maps are read from comma separated key=value pairs
+func LoadEnv(prefix string, cfg any) error {
+	if err := ApplyDefaults(cfg); err != nil {
+		return err
+	}
+	return eachField(cfg, func(field reflect.StructField, value reflect.Value) error {
+		name, ok := field.Tag.Lookup(ENV_TAG)
+		if !ok || name == "" || name == "-" {
+			return nil
+		}
+		raw, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			return nil
+		}
+		if err := setField(value, raw); err != nil {
+			return fmt.Errorf("invalid value for %s%s: %v", prefix, name, err)
+		}
+		return nil
+	})
+}
+
+// LoadYAML applies the defaults of the struct pointed to by cfg and then sets its fields from the YAML
+// file at path. Environment variables referenced in the file as $VAR or ${VAR} are expanded first
+func LoadYAML(path string, cfg any) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file %s: %v", path, err)
+	}
+	if err := ApplyDefaults(cfg); err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(contents))), cfg); err != nil {
+		return fmt.Errorf("failed to parse configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+// eachField calls fn for every settable field of the struct pointed to by cfg, descending into nested
+// structs other than time.Time
+func eachField(cfg any, fn func(field reflect.StructField, value reflect.Value) error) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a pointer to a configuration struct, got %T", cfg)
+	}
+	return eachStructField(v.Elem(), fn)
+}
+
+func eachStructField(v reflect.Value, fn func(field reflect.StructField, value reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(time.Time{}) {
+			if err := eachStructField(value, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setField parses raw into value according to its kind
+func setField(value reflect.Value, raw string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		parts := []string{}
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		s := reflect.MakeSlice(value.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			s.Index(i).SetString(part)
+		}
+		value.Set(s)
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String || value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		m := reflect.MakeMap(value.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value pairs, got %s", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)).Convert(value.Type().Key()), reflect.ValueOf(strings.TrimSpace(v)).Convert(value.Type().Elem()))
+		}
+		value.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
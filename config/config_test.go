@@ -0,0 +1,315 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nestedConfig struct {
+	Attempts int           `yaml:"attempts" env:"ATTEMPTS" default:"3"`
+	Backoff  time.Duration `yaml:"backoff" env:"BACKOFF" default:"100ms"`
+}
+
+type testConfig struct {
+	Host    string            `yaml:"host" env:"HOST" default:"localhost"`
+	Port    int               `yaml:"port" env:"PORT" default:"5432"`
+	Debug   bool              `yaml:"debug" env:"DEBUG"`
+	Ratio   float64           `yaml:"ratio" env:"RATIO" default:"0.5"`
+	Timeout time.Duration     `yaml:"timeout" env:"TIMEOUT"`
+	Hosts   []string          `yaml:"hosts" env:"HOSTS"`
+	Options map[string]string `yaml:"options" env:"OPTIONS"`
+	Ignored string            `yaml:"ignored" env:"-"`
+	Retry   nestedConfig      `yaml:"retry"`
+}
+
+func (c testConfig) Validate() error {
+	errs := &ValidationError{}
+	errs.Require("host", c.Host)
+	if c.Port <= 0 {
+		errs.Add("port", "must be positive, got %d", c.Port)
+	}
+	return errs.Err()
+}
+
+var defaultTestConfig = testConfig{
+	Host:  "localhost",
+	Port:  5432,
+	Ratio: 0.5,
+	Retry: nestedConfig{Attempts: 3, Backoff: 100 * time.Millisecond},
+}
+
+func TestApplyDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  testConfig
+		want testConfig
+	}{
+		{name: "empty fields", cfg: testConfig{}, want: defaultTestConfig},
+		{
+			name: "set fields are kept",
+			cfg:  testConfig{Host: "db", Retry: nestedConfig{Attempts: 5}},
+			want: testConfig{Host: "db", Port: 5432, Ratio: 0.5, Retry: nestedConfig{Attempts: 5, Backoff: 100 * time.Millisecond}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ApplyDefaults(&tt.cfg); err != nil {
+				t.Fatalf("ApplyDefaults() error: %v", err)
+			}
+			if !reflect.DeepEqual(tt.cfg, tt.want) {
+				t.Errorf("ApplyDefaults() = %+v, want %+v", tt.cfg, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid default", func(t *testing.T) {
+		cfg := struct {
+			Port int `default:"many"`
+		}{}
+		if err := ApplyDefaults(&cfg); err == nil {
+			t.Errorf("ApplyDefaults() error = nil, want an invalid default error")
+		}
+	})
+	t.Run("not a struct pointer", func(t *testing.T) {
+		if err := ApplyDefaults(testConfig{}); err == nil {
+			t.Errorf("ApplyDefaults() error = nil, want an error for a struct value")
+		}
+	})
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(c *testConfig)
+		wantErr string
+	}{
+		{name: "defaults only", want: func(c *testConfig) {}},
+		{
+			name: "scalars",
+			env:  map[string]string{"APP_HOST": "db", "APP_PORT": "3306", "APP_DEBUG": "true", "APP_RATIO": "0.25"},
+			want: func(c *testConfig) { c.Host, c.Port, c.Debug, c.Ratio = "db", 3306, true, 0.25 },
+		},
+		{
+			name: "durations",
+			env:  map[string]string{"APP_TIMEOUT": "1m30s", "APP_BACKOFF": "2s"},
+			want: func(c *testConfig) { c.Timeout, c.Retry.Backoff = 90*time.Second, 2*time.Second },
+		},
+		{
+			name: "slices skip empty entries",
+			env:  map[string]string{"APP_HOSTS": "a, b,,c "},
+			want: func(c *testConfig) { c.Hosts = []string{"a", "b", "c"} },
+		},
+		{
+			name: "maps",
+			env:  map[string]string{"APP_OPTIONS": "sslmode=disable, connect_timeout = 5,"},
+			want: func(c *testConfig) { c.Options = map[string]string{"sslmode": "disable", "connect_timeout": "5"} },
+		},
+		{
+			name: "nested structs",
+			env:  map[string]string{"APP_ATTEMPTS": "7"},
+			want: func(c *testConfig) { c.Retry.Attempts = 7 },
+		},
+		{
+			name: "fields tagged - are ignored",
+			env:  map[string]string{"APP_IGNORED": "x"},
+			want: func(c *testConfig) {},
+		},
+		{name: "bad int", env: map[string]string{"APP_PORT": "http"}, wantErr: "APP_PORT"},
+		{name: "bad bool", env: map[string]string{"APP_DEBUG": "maybe"}, wantErr: "APP_DEBUG"},
+		{name: "bad float", env: map[string]string{"APP_RATIO": "half"}, wantErr: "APP_RATIO"},
+		{name: "bad duration", env: map[string]string{"APP_TIMEOUT": "10"}, wantErr: "APP_TIMEOUT"},
+		{name: "bad map pair", env: map[string]string{"APP_OPTIONS": "sslmode"}, wantErr: "APP_OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var cfg testConfig
+			err := LoadEnv("APP_", &cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadEnv() error = %v, want an error naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadEnv() error: %v", err)
+			}
+			want := defaultTestConfig
+			tt.want(&want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("LoadEnv() = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		env     map[string]string
+		want    func(c *testConfig)
+		wantErr bool
+	}{
+		{name: "empty file keeps the defaults", yaml: "", want: func(c *testConfig) {}},
+		{
+			name: "fields override the defaults",
+			yaml: "host: db\nport: 3306\ntimeout: 5s\nhosts: [a, b]\noptions:\n  sslmode: disable\nretry:\n  attempts: 4\n",
+			want: func(c *testConfig) {
+				c.Host, c.Port, c.Timeout, c.Retry.Attempts = "db", 3306, 5*time.Second, 4
+				c.Hosts = []string{"a", "b"}
+				c.Options = map[string]string{"sslmode": "disable"}
+			},
+		},
+		{
+			name: "environment variables are expanded",
+			yaml: "host: ${DB_HOST}\nport: $DB_PORT\n",
+			env:  map[string]string{"DB_HOST": "db.internal", "DB_PORT": "6543"},
+			want: func(c *testConfig) { c.Host, c.Port = "db.internal", 6543 },
+		},
+		{name: "invalid YAML", yaml: "port: [1\n", wantErr: true},
+		{name: "wrong type", yaml: "port: http\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatalf("WriteFile() error: %v", err)
+			}
+			var cfg testConfig
+			err := LoadYAML(path, &cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadYAML() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadYAML() error: %v", err)
+			}
+			want := defaultTestConfig
+			tt.want(&want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("LoadYAML() = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		var cfg testConfig
+		if err := LoadYAML(filepath.Join(t.TempDir(), "missing.yaml"), &cfg); err == nil {
+			t.Errorf("LoadYAML() error = nil, want an error for a missing file")
+		}
+	})
+}
+
+func TestSetField(t *testing.T) {
+	var target struct {
+		Int8     int8
+		Uint     uint
+		Float32  float32
+		Ints     []int
+		IntMap   map[string]int
+		Struct   struct{}
+		Duration time.Duration
+	}
+	v := reflect.ValueOf(&target).Elem()
+
+	tests := []struct {
+		name    string
+		field   string
+		raw     string
+		want    any
+		wantErr bool
+	}{
+		{name: "int8", field: "Int8", raw: "-12", want: int8(-12)},
+		{name: "int8 overflow", field: "Int8", raw: "300", wantErr: true},
+		{name: "uint", field: "Uint", raw: "42", want: uint(42)},
+		{name: "negative uint", field: "Uint", raw: "-1", wantErr: true},
+		{name: "float32", field: "Float32", raw: "1.5", want: float32(1.5)},
+		{name: "duration", field: "Duration", raw: "250ms", want: 250 * time.Millisecond},
+		{name: "unsupported slice", field: "Ints", raw: "1,2", wantErr: true},
+		{name: "unsupported map", field: "IntMap", raw: "a=1", wantErr: true},
+		{name: "unsupported kind", field: "Struct", raw: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := v.FieldByName(tt.field)
+			err := setField(field, tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("setField(%s, %q) error = nil, want an error", tt.field, tt.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setField(%s, %q) error: %v", tt.field, tt.raw, err)
+			}
+			if got := field.Interface(); got != tt.want {
+				t.Errorf("setField(%s, %q) = %v, want %v", tt.field, tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     any
+		wantErr string
+	}{
+		{name: "valid", cfg: defaultTestConfig},
+		{name: "one problem", cfg: testConfig{Port: 1}, wantErr: "invalid configuration: host is required"},
+		{name: "every problem is reported", cfg: testConfig{}, wantErr: "invalid configuration: host is required; port must be positive, got 0"},
+		{name: "not a validator", cfg: struct{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || err.Error() != tt.wantErr {
+				t.Errorf("Validate() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "http://localhost:8000"},
+		{value: "https://account.documents.azure.com:443/"},
+		{value: "localhost:8000", wantErr: true},
+		{value: "/path/only", wantErr: true},
+		{value: "http://%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			errs := &ValidationError{}
+			errs.AbsoluteURL("endpoint", tt.value)
+			if (errs.Err() != nil) != tt.wantErr {
+				t.Errorf("AbsoluteURL(%q) error = %v, want error %v", tt.value, errs.Err(), tt.wantErr)
+			}
+		})
+	}
+}
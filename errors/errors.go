@@ -39,3 +39,27 @@ type Unauthorized struct {
 func (e *Unauthorized) Error() string {
 	return e.Message
 }
+
+type Conflict struct {
+	Message string
+}
+
+func (e *Conflict) Error() string {
+	return e.Message
+}
+
+type PreconditionFailed struct {
+	Message string
+}
+
+func (e *PreconditionFailed) Error() string {
+	return e.Message
+}
+
+type TooManyRequests struct {
+	Message string
+}
+
+func (e *TooManyRequests) Error() string {
+	return e.Message
+}
@@ -0,0 +1,452 @@
+// Package jsonpatch applies RFC 6902 JSON Patch documents to resources kept in a storage.StorageAdapter.
+//
+// Example:
+//
+//	patch, err := jsonpatch.Parse(body)
+//	if err != nil {
+//	    return err // *errors.BadRequest
+//	}
+//	var task Task
+//	err = jsonpatch.Update(ctx, adapter, &task, map[string]any{"id": id}, patch)
+package jsonpatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+	"github.com/tink3rlabs/magic/middlewares"
+	"github.com/tink3rlabs/magic/storage"
+	"github.com/tink3rlabs/magic/types"
+)
+
+// JSON Patch operations
+const (
+	OP_ADD     = "add"
+	OP_REMOVE  = "remove"
+	OP_REPLACE = "replace"
+	OP_MOVE    = "move"
+	OP_COPY    = "copy"
+	OP_TEST    = "test"
+)
+
+// Operation is a single operation of a JSON Patch document. Value holds the raw JSON value, it is nil when the
+// operation has no value
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+	From  string          `json:"from,omitempty"`
+}
+
+// Patch is a JSON Patch document, its operations are applied in order
+type Patch []Operation
+
+var patchSchema string
+var patchSchemaErr error
+var patchSchemaOnce sync.Once
+
+// schema returns the JSON schema of a patch document, an array of the PatchBody schema of the shared OpenAPI definitions
+func schema() (string, error) {
+	patchSchemaOnce.Do(func() {
+		var definitions struct {
+			Components struct {
+				Schemas map[string]json.RawMessage `json:"schemas"`
+			} `json:"components"`
+		}
+		data, err := types.GetOpenAPIDefinitions()
+		if err == nil {
+			err = json.Unmarshal(data, &definitions)
+		}
+		if err != nil {
+			patchSchemaErr = fmt.Errorf("failed to load the PatchBody schema: %v", err)
+			return
+		}
+		patchSchema = fmt.Sprintf(`{"type": "array", "items": %s}`, definitions.Components.Schemas["PatchBody"])
+	})
+	return patchSchema, patchSchemaErr
+}
+
+// Parse decodes a JSON Patch document, validating it against the PatchBody schema. Invalid documents are reported
+// as an *errors.BadRequest
+func Parse(data []byte) (Patch, error) {
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid JSON patch: %v", err)}
+	}
+	s, err := schema()
+	if err != nil {
+		return nil, err
+	}
+	result, err := middlewares.JSONSchemaValidator(s, document)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Result {
+		return nil, &serviceErrors.BadRequest{Message: "invalid JSON patch: " + strings.Join(result.Error, "; ")}
+	}
+
+	var patch Patch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid JSON patch: %v", err)}
+	}
+	for i, op := range patch {
+		switch op.Op {
+		case OP_ADD, OP_REPLACE, OP_TEST:
+			if op.Value == nil {
+				return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid JSON patch: operation %d (%s) requires a value", i, op.Op)}
+			}
+		case OP_MOVE, OP_COPY:
+			if _, ok := document.([]any)[i].(map[string]any)["from"]; !ok {
+				return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid JSON patch: operation %d (%s) requires from", i, op.Op)}
+			}
+		}
+	}
+	return patch, nil
+}
+
+// Apply applies the patch to the JSON document doc and returns the patched document. The patch is applied as a
+// whole: invalid paths are reported as an *errors.BadRequest and failed test operations as an *errors.Conflict,
+// in both cases doc is left unchanged
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid JSON document: %v", err)}
+	}
+	for i, op := range p {
+		if root, err = op.apply(root); err != nil {
+			return nil, operationError(i, op, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// Update loads the item matching filter from s into item, applies the patch to it and stores the result with
+// Update, leaving item holding the patched resource. Models with a version or etag field are updated
+// conditionally, so the write fails with storage.ErrConflict if the item changed after it was loaded, and a test
+// operation on the version field makes the patch only apply to that version. Fields used in filter can't be
+// changed by the patch
+func Update(ctx context.Context, s storage.StorageAdapter, item any, filter map[string]any, patch Patch, params ...map[string]any) error {
+	c, hasContext := s.(storage.ContextStorageAdapter)
+	var err error
+	if hasContext {
+		err = c.GetContext(ctx, item, filter, params...)
+	} else {
+		err = s.Get(item, filter, params...)
+	}
+	if err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item: %v", err)
+	}
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		return err
+	}
+	if err := checkFilterFields(doc, patched, filter); err != nil {
+		return err
+	}
+
+	// Decoding into a new value makes removed fields zero values instead of keeping their current value
+	result := reflect.New(reflect.TypeOf(item).Elem())
+	if err := json.Unmarshal(patched, result.Interface()); err != nil {
+		return &serviceErrors.BadRequest{Message: fmt.Sprintf("the patched resource is invalid: %v", err)}
+	}
+	reflect.ValueOf(item).Elem().Set(result.Elem())
+
+	if hasContext {
+		return c.UpdateContext(ctx, item, filter, params...)
+	}
+	return s.Update(item, filter, params...)
+}
+
+// checkFilterFields verifies that the fields of filter have the same value before and after the patch
+func checkFilterFields(before []byte, after []byte, filter map[string]any) error {
+	var b, a map[string]json.RawMessage
+	if json.Unmarshal(before, &b) != nil || json.Unmarshal(after, &a) != nil {
+		return nil
+	}
+	for key := range filter {
+		if value, ok := b[key]; ok && !bytes.Equal(value, a[key]) {
+			return &serviceErrors.BadRequest{Message: fmt.Sprintf("%s can't be changed by a patch", key)}
+		}
+	}
+	return nil
+}
+
+// errTestFailed is returned by test operations whose value doesn't match
+var errTestFailed = fmt.Errorf("the value doesn't match")
+
+func operationError(i int, op Operation, err error) error {
+	message := fmt.Sprintf("operation %d (%s %s) failed: %v", i, op.Op, op.Path, err)
+	if err == errTestFailed {
+		return &serviceErrors.Conflict{Message: message}
+	}
+	return &serviceErrors.BadRequest{Message: message}
+}
+
+// apply applies the operation to the document root and returns the new root
+func (op Operation) apply(root any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case OP_ADD:
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case OP_REMOVE:
+		root, _, err := remove(root, path)
+		return root, err
+	case OP_REPLACE:
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if root, _, err = remove(root, path); err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case OP_MOVE, OP_COPY:
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if op.Op == OP_MOVE {
+			if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, fmt.Errorf("%s can't be moved into one of its children", op.From)
+			}
+			root, value, err = remove(root, from)
+		} else {
+			value, err = get(root, from)
+			if err == nil {
+				value, err = clone(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case OP_TEST:
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		current, err := get(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, errTestFailed
+		}
+		return root, nil
+	default:
+		return nil, fmt.Errorf("unsupported operation '%s'", op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path '%s', it must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// get returns the value at path
+func get(root any, path []string) (any, error) {
+	current := root
+	for i, token := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path /%s doesn't exist", strings.Join(path[:i+1], "/"))
+			}
+			current = value
+		case []any:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path /%s doesn't exist", strings.Join(path[:i+1], "/"))
+		}
+	}
+	return current, nil
+}
+
+// add adds value at path, replacing the value of an existing object member and inserting into arrays
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[token] = value
+		return root, nil
+	case []any:
+		index := len(node)
+		if token != "-" {
+			if index, err = arrayIndex(token, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node, nil)
+		copy(node[index+1:], node[index:])
+		node[index] = value
+		return replaceChild(root, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("path /%s doesn't refer to an object or array", strings.Join(path[:len(path)-1], "/"))
+	}
+}
+
+// remove removes the value at path and returns the new root along with the removed value
+func remove(root any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, root, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	token := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path /%s doesn't exist", strings.Join(path, "/"))
+		}
+		delete(node, token)
+		return root, value, nil
+	case []any:
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		root, err = replaceChild(root, path[:len(path)-1], node)
+		return root, value, err
+	default:
+		return nil, nil, fmt.Errorf("path /%s doesn't exist", strings.Join(path, "/"))
+	}
+}
+
+// replaceChild stores the array node at path, arrays are values so growing or shrinking one has to be written back
+func replaceChild(root any, path []string, node []any) (any, error) {
+	if len(path) == 0 {
+		return node, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[token] = node
+	case []any:
+		index, _ := strconv.Atoi(token)
+		p[index] = node
+	}
+	return root, nil
+}
+
+// arrayIndex parses an array index token, which must be between 0 and max
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d is out of bounds", index)
+	}
+	return index, nil
+}
+
+// decode decodes a JSON value keeping numbers as json.Number so they don't lose precision
+func decode(data []byte) (any, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// clone returns a deep copy of a decoded JSON value
+func clone(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// equal compares two decoded JSON values, numbers are equal if they have the same value whatever their notation
+func equal(a any, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _, errX := big.ParseFloat(x.String(), 10, 256, big.ToNearestEven)
+		fy, _, errY := big.ParseFloat(y.String(), 10, 256, big.ToNearestEven)
+		return errX == nil && errY == nil && fx.Cmp(fy) == 0
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,266 @@
+package jsonpatch
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+	"github.com/tink3rlabs/magic/storage"
+)
+
+// TestParse tests validation of patch documents against the PatchBody schema
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr bool
+	}{
+		{name: "valid patch", patch: `[{"op": "replace", "path": "/name", "value": "x"}, {"op": "remove", "path": "/tags/0"}]`},
+		{name: "null value", patch: `[{"op": "add", "path": "/name", "value": null}]`},
+		{name: "empty patch", patch: `[]`},
+		{name: "not an array", patch: `{"op": "remove", "path": "/name"}`, wantErr: true},
+		{name: "invalid JSON", patch: `[{"op": }]`, wantErr: true},
+		{name: "unknown op", patch: `[{"op": "merge", "path": "/name"}]`, wantErr: true},
+		{name: "missing path", patch: `[{"op": "remove"}]`, wantErr: true},
+		{name: "unknown member", patch: `[{"op": "remove", "path": "/name", "extra": 1}]`, wantErr: true},
+		{name: "add without value", patch: `[{"op": "add", "path": "/name"}]`, wantErr: true},
+		{name: "move without from", patch: `[{"op": "move", "path": "/name"}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.patch))
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Parse() unexpected error: %v", err)
+				}
+				return
+			}
+			var badRequest *serviceErrors.BadRequest
+			if !errors.As(err, &badRequest) {
+				t.Errorf("Parse() error = %v, want a BadRequest", err)
+			}
+		})
+	}
+}
+
+// TestApply tests the examples of RFC 6902 appendix A
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "add an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "add an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "remove an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "remove an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "replace a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "move a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "move an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:  "copy a value",
+			doc:   `{"foo": {"bar": 1}}`,
+			patch: `[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "replace", "path": "/baz/bar", "value": 2}]`,
+			want:  `{"foo": {"bar": 1}, "baz": {"bar": 2}}`,
+		},
+		{
+			name:  "test a value",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2.0}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:  "add a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "escaped paths",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}, {"op": "remove", "path": "/~1"}]`,
+			want:  `{"~1": 10}`,
+		},
+		{
+			name:  "append to an array",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name:  "replace the whole document",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "", "value": {"baz": "qux"}}]`,
+			want:  `{"baz": "qux"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := Parse([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Parse() error: %v", err)
+			}
+			got, err := patch.Apply([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("Apply() error: %v", err)
+			}
+			if !equal(mustDecode(t, got), mustDecode(t, []byte(tt.want))) {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyErrors tests that invalid paths are reported as BadRequest and failed tests as Conflict
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		doc          string
+		patch        string
+		wantConflict bool
+	}{
+		{name: "missing member", doc: `{"foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`},
+		{name: "missing parent", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`},
+		{name: "index out of bounds", doc: `{"foo": [1]}`, patch: `[{"op": "add", "path": "/foo/2", "value": 2}]`},
+		{name: "invalid index", doc: `{"foo": [1]}`, patch: `[{"op": "replace", "path": "/foo/01", "value": 2}]`},
+		{name: "relative path", doc: `{"foo": "bar"}`, patch: `[{"op": "remove", "path": "foo"}]`},
+		{name: "move into a child", doc: `{"foo": {"bar": 1}}`, patch: `[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`},
+		{name: "failed test", doc: `{"baz": "qux"}`, patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`, wantConflict: true},
+		{name: "number and string", doc: `{"baz": 1}`, patch: `[{"op": "test", "path": "/baz", "value": "1"}]`, wantConflict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := Parse([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Parse() error: %v", err)
+			}
+			_, err = patch.Apply([]byte(tt.doc))
+			var badRequest *serviceErrors.BadRequest
+			var conflict *serviceErrors.Conflict
+			if tt.wantConflict && !errors.As(err, &conflict) {
+				t.Errorf("Apply() error = %v, want a Conflict", err)
+			}
+			if !tt.wantConflict && !errors.As(err, &badRequest) {
+				t.Errorf("Apply() error = %v, want a BadRequest", err)
+			}
+		})
+	}
+}
+
+type task struct {
+	ID      string   `json:"id" gorm:"primaryKey"`
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags" gorm:"serializer:json"`
+	Version int64    `json:"version" magic:"version"`
+}
+
+// TestUpdate tests patching a stored resource
+func TestUpdate(t *testing.T) {
+	adapter, err := storage.NewMemoryAdapter()
+	if err != nil {
+		t.Fatalf("NewMemoryAdapter() error: %v", err)
+	}
+	defer adapter.Close()
+	if err := adapter.DB.DB.AutoMigrate(&task{}); err != nil {
+		t.Fatalf("AutoMigrate() error: %v", err)
+	}
+	ctx := context.Background()
+	filter := map[string]any{"id": "1"}
+	if err := adapter.CreateContext(ctx, &task{ID: "1", Name: "write docs", Tags: []string{"docs"}}); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	patch, _ := Parse([]byte(`[
+		{"op": "test", "path": "/version", "value": 1},
+		{"op": "replace", "path": "/name", "value": "write more docs"},
+		{"op": "add", "path": "/tags/-", "value": "urgent"}
+	]`))
+	var item task
+	if err := Update(ctx, adapter, &item, filter, patch); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	var stored task
+	if err := adapter.GetContext(ctx, &stored, filter); err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if stored.Name != "write more docs" || len(stored.Tags) != 2 || stored.Tags[1] != "urgent" || stored.Version != 2 {
+		t.Errorf("stored item = %+v, want the patched item", stored)
+	}
+	if item.Version != 2 {
+		t.Errorf("item version = %d, want 2", item.Version)
+	}
+
+	// The version was bumped by the first update so the same patch fails its test
+	err = Update(ctx, adapter, &task{}, filter, patch)
+	var conflict *serviceErrors.Conflict
+	if !errors.As(err, &conflict) {
+		t.Errorf("Update() error = %v, want a Conflict", err)
+	}
+
+	patch, _ = Parse([]byte(`[{"op": "replace", "path": "/id", "value": "2"}]`))
+	err = Update(ctx, adapter, &task{}, filter, patch)
+	var badRequest *serviceErrors.BadRequest
+	if !errors.As(err, &badRequest) {
+		t.Errorf("Update() error = %v, want a BadRequest", err)
+	}
+
+	patch, _ = Parse([]byte(`[{"op": "replace", "path": "/name", "value": 5}]`))
+	err = Update(ctx, adapter, &task{}, filter, patch)
+	if !errors.As(err, &badRequest) {
+		t.Errorf("Update() error = %v, want a BadRequest", err)
+	}
+
+	err = Update(ctx, adapter, &task{}, map[string]any{"id": "missing"}, patch)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update() error = %v, want ErrNotFound", err)
+	}
+}
+
+func mustDecode(t *testing.T, data []byte) any {
+	t.Helper()
+	value, err := decode(data)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return value
+}
@@ -332,34 +332,43 @@ func (l *LeaderElection) Members() ([]Member, error) {
 	return members, err
 }
 
-// Start triggers a new leader election
+// Start triggers a new leader election, exiting the process if this node can't take part in it
 func (l *LeaderElection) Start() {
+	if err := l.Join(); err != nil {
+		logger.Fatal("failed to start leader election", slog.Any("error", err))
+	}
+}
+
+// Join triggers a new leader election, returning an error if this node can't take part in it
+func (l *LeaderElection) Join() error {
 	if l.storageType == string(storage.MEMORY) {
 		slog.Info("using memory storage adapter, leader election is only supported with persistent storage")
+		return nil
+	}
+	slog.Info("using a persistent storage adapter, starting leader election")
+	slog.Info("creating membership table")
+	err := l.createLeadershipTable()
+	if err != nil {
+		return fmt.Errorf("failed to create membership table: %v", err)
+	}
+	slog.Info("registering node:", slog.String("node_id", l.Id))
+	err = l.updateMembershipTable()
+	if err != nil {
+		return fmt.Errorf("failed to register node: %v", err)
+	}
+	err = l.electLeader(false)
+	if err != nil {
+		return fmt.Errorf("failed to elect leader: %v", err)
+	}
+	// Only start the heartbeat once the election succeeded so a failed Join doesn't leave it running
+	go l.heartbeat()
+	if l.Id == l.Leader.Id {
+		slog.Info("I was elected leader")
+		// Publish election results
+		go func() { l.Results <- RESULT_ELECTED }()
 	} else {
-		slog.Info("using a persistent storage adapter, starting leader election")
-		slog.Info("creating membership table")
-		err := l.createLeadershipTable()
-		if err != nil {
-			logger.Fatal("failed to create membership table", slog.Any("error", err))
-		}
-		slog.Info("registering node:", slog.String("node_id", l.Id))
-		err = l.updateMembershipTable()
-		if err != nil {
-			logger.Fatal("failed to register node", slog.Any("error", err))
-		}
-		go l.heartbeat()
-		err = l.electLeader(false)
-		if err != nil {
-			logger.Fatal("failed to elect leader", slog.Any("error", err))
-		}
-		if l.Id == l.Leader.Id {
-			slog.Info("I was elected leader")
-			// Publish election results
-			go func() { l.Results <- RESULT_ELECTED }()
-		} else {
-			slog.Info("monitoring the leader", slog.String("leader_id", l.Leader.Id))
-			go l.monitorLeader()
-		}
+		slog.Info("monitoring the leader", slog.String("leader_id", l.Leader.Id))
+		go l.monitorLeader()
 	}
+	return nil
 }
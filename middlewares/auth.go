@@ -3,6 +3,7 @@ package middlewares
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -144,19 +145,29 @@ type EnsureValidTokenConfig struct {
 	AllowedClockSkew time.Duration
 }
 
-// EnsureValidToken is a middleware that validates JWT tokens and injects claims into the request context
+// EnsureValidToken is a middleware that validates JWT tokens and injects claims into the request context,
+// it exits the process if the middleware can't be set up
 func EnsureValidToken(cfg EnsureValidTokenConfig) func(http.Handler) http.Handler {
+	middleware, err := NewEnsureValidToken(cfg)
+	if err != nil {
+		logger.Fatal("failed to set up the EnsureValidToken middleware", slog.Any("error", err.Error()))
+	}
+	return middleware
+}
+
+// NewEnsureValidToken returns the EnsureValidToken middleware, or an error if it can't be set up
+func NewEnsureValidToken(cfg EnsureValidTokenConfig) (func(http.Handler) http.Handler, error) {
 	if !cfg.Enabled {
 		return func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				next.ServeHTTP(w, r)
 			})
-		}
+		}, nil
 	}
 
 	issuerURL, err := url.Parse(cfg.IssuerURL)
 	if err != nil {
-		logger.Fatal("failed to parse issuer URL", slog.Any("error", err.Error()))
+		return nil, fmt.Errorf("failed to parse issuer URL: %v", err)
 	}
 
 	provider := jwks.NewCachingProvider(issuerURL, 5*time.Minute)
@@ -170,7 +181,7 @@ func EnsureValidToken(cfg EnsureValidTokenConfig) func(http.Handler) http.Handle
 		validator.WithAllowedClockSkew(cfg.AllowedClockSkew),
 	)
 	if err != nil {
-		logger.Fatal("failed to set up JWT validator", slog.Any("error", err.Error()))
+		return nil, fmt.Errorf("failed to set up JWT validator: %v", err)
 	}
 
 	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
@@ -197,7 +208,7 @@ func EnsureValidToken(cfg EnsureValidTokenConfig) func(http.Handler) http.Handle
 			}
 			next.ServeHTTP(w, r)
 		}))
-	}
+	}, nil
 }
 
 // Middlewares to inject claims into context
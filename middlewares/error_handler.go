@@ -20,6 +20,9 @@ func (e *ErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request)
 		var serviceUnavailable *serviceErrors.ServiceUnavailable
 		var forbiddenError *serviceErrors.Forbidden
 		var unauthorizedError *serviceErrors.Unauthorized
+		var conflictError *serviceErrors.Conflict
+		var preconditionFailedError *serviceErrors.PreconditionFailed
+		var tooManyRequestsError *serviceErrors.TooManyRequests
 
 		err := handler(w, r)
 
@@ -43,7 +46,7 @@ func (e *ErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if errors.As(err, &serviceUnavailable) {
+		if (errors.As(err, &serviceUnavailable)) || (errors.Is(err, storage.ErrUnavailable)) {
 			render.Status(r, http.StatusServiceUnavailable)
 			response := types.ErrorResponse{
 				Status: http.StatusText(http.StatusServiceUnavailable),
@@ -73,6 +76,36 @@ func (e *ErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		if (errors.As(err, &conflictError)) || (errors.Is(err, storage.ErrConflict)) || (errors.Is(err, storage.ErrAlreadyExists)) {
+			render.Status(r, http.StatusConflict)
+			response := types.ErrorResponse{
+				Status: http.StatusText(http.StatusConflict),
+				Error:  err.Error(),
+			}
+			render.JSON(w, r, response)
+			return
+		}
+
+		if (errors.As(err, &preconditionFailedError)) || (errors.Is(err, storage.ErrPreconditionFailed)) {
+			render.Status(r, http.StatusPreconditionFailed)
+			response := types.ErrorResponse{
+				Status: http.StatusText(http.StatusPreconditionFailed),
+				Error:  err.Error(),
+			}
+			render.JSON(w, r, response)
+			return
+		}
+
+		if (errors.As(err, &tooManyRequestsError)) || (errors.Is(err, storage.ErrThrottled)) {
+			render.Status(r, http.StatusTooManyRequests)
+			response := types.ErrorResponse{
+				Status: http.StatusText(http.StatusTooManyRequests),
+				Error:  err.Error(),
+			}
+			render.JSON(w, r, response)
+			return
+		}
+
 		if err != nil {
 			render.Status(r, http.StatusInternalServerError)
 			response := types.ErrorResponse{
@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/tink3rlabs/magic/config"
+)
+
+// SNSConfig configures the SNS publisher. When the region or credentials aren't set the default AWS
+// configuration chain (environment, shared config files, instance roles) is used
+type SNSConfig struct {
+	Region    string `yaml:"region" env:"REGION"`
+	Endpoint  string `yaml:"endpoint" env:"ENDPOINT"` // overrides the service endpoint, e.g. for local testing
+	AccessKey string `yaml:"access_key" env:"ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"SECRET_KEY"`
+}
+
+// Validate reports every missing or invalid field of c
+func (c SNSConfig) Validate() error {
+	errs := &config.ValidationError{}
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		errs.Add("access_key", "and secret_key must be set together")
+	}
+	errs.AbsoluteURL("endpoint", c.Endpoint)
+	return errs.Err()
+}
+
+func (c SNSConfig) toMap() map[string]string {
+	m := map[string]string{}
+	config.SetIfNotEmpty(m, "region", c.Region)
+	config.SetIfNotEmpty(m, "endpoint", c.Endpoint)
+	config.SetIfNotEmpty(m, "access_key", c.AccessKey)
+	config.SetIfNotEmpty(m, "secret_key", c.SecretKey)
+	return m
+}
+
+// publisherConfig returns the configuration map used by the SNS publisher. cfg may be nil, a
+// map[string]string or an SNSConfig (or a pointer to one), which is validated first
+func publisherConfig(cfg any) (map[string]string, error) {
+	var c SNSConfig
+	switch v := cfg.(type) {
+	case nil:
+		return map[string]string{}, nil
+	case map[string]string:
+		return v, nil
+	case SNSConfig:
+		c = v
+	case *SNSConfig:
+		if v == nil {
+			return map[string]string{}, nil
+		}
+		c = *v
+	default:
+		return nil, fmt.Errorf("unsupported configuration type %T for the SNS publisher", cfg)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c.toMap(), nil
+}
@@ -15,13 +15,17 @@ const (
 type PublisherType string
 type PublisherFactory struct{}
 
+// GetInstance returns a new publisher of publisherType. config may be nil, a map[string]string or the
+// publisher's configuration struct (SNSConfig), in which case every missing or invalid field is reported
+// in a *config.ValidationError
 func (s PublisherFactory) GetInstance(publisherType PublisherType, config any) (Publisher, error) {
-	if config == nil {
-		config = make(map[string]string)
-	}
 	switch publisherType {
 	case SNS:
-		return GetSNSPublisher(config.(map[string]string)), nil
+		c, err := publisherConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return NewSNSPublisher(c)
 	default:
 		return nil, errors.New("this publisher type isn't supported")
 	}
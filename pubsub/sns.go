@@ -18,7 +18,17 @@ type SNSPublisher struct {
 	config map[string]string
 }
 
+// GetSNSPublisher returns a new SNSPublisher, exiting the process if it can't be created
 func GetSNSPublisher(config map[string]string) *SNSPublisher {
+	s, err := NewSNSPublisher(config)
+	if err != nil {
+		logger.Fatal("failed to create SNS publisher", slog.Any("error", err.Error()))
+	}
+	return s
+}
+
+// NewSNSPublisher returns a new SNSPublisher
+func NewSNSPublisher(config map[string]string) (*SNSPublisher, error) {
 	s := SNSPublisher{config: config}
 	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
 
@@ -36,7 +46,7 @@ func GetSNSPublisher(config map[string]string) *SNSPublisher {
 	}
 
 	if err != nil {
-		logger.Fatal("failed to create SNS publisher", slog.Any("error", err.Error()))
+		return nil, fmt.Errorf("failed to create SNS publisher: %v", err)
 	}
 
 	s.Client = sns.NewFromConfig(cfg, func(o *sns.Options) {
@@ -46,7 +56,7 @@ func GetSNSPublisher(config map[string]string) *SNSPublisher {
 		}
 	})
 
-	return &s
+	return &s, nil
 }
 
 func (s *SNSPublisher) Publish(topic string, message string, params map[string]any) error {
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tink3rlabs/magic/config"
+)
+
+// SQLConfig configures the SQL storage adapter. It can be loaded with config.LoadEnv or config.LoadYAML
+type SQLConfig struct {
+	Provider StorageProviders `yaml:"provider" env:"PROVIDER"` // postgresql, mysql or sqlite
+	Host     string           `yaml:"host" env:"HOST"`
+	Port     int              `yaml:"port" env:"PORT"` // defaults to 5432 for postgresql and 3306 for mysql
+	User     string           `yaml:"user" env:"USER"`
+	Password string           `yaml:"password" env:"PASSWORD"`
+	DBName   string           `yaml:"dbname" env:"DBNAME"`
+	Schema   string           `yaml:"schema" env:"SCHEMA"`
+	SSLMode  string           `yaml:"sslmode" env:"SSLMODE"`  // postgresql only
+	Path     string           `yaml:"path" env:"SQLITE_PATH"` // sqlite only, an in memory database is used if empty
+	// Additional connection parameters, passed as is in the postgresql connection string
+	Options map[string]string `yaml:"options" env:"OPTIONS"`
+
+	// Connection pool settings, the driver defaults are used for those left empty
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"CONN_MAX_IDLE_TIME"`
+	// Maximum duration of a single statement, contexts with an earlier deadline keep it
+	QueryTimeout time.Duration `yaml:"query_timeout" env:"QUERY_TIMEOUT"`
+
+	// mysql only: true, false, skip-verify or preferred. Setting TLSCA or TLSCert and TLSKey enables TLS
+	// with these PEM files
+	TLS     string `yaml:"tls" env:"TLS"`
+	TLSCA   string `yaml:"tls_ca" env:"TLS_CA"`
+	TLSCert string `yaml:"tls_cert" env:"TLS_CERT"`
+	TLSKey  string `yaml:"tls_key" env:"TLS_KEY"`
+
+	// postgresql and mysql only: connection strings of read replicas. Get, List, Search, Count and Query
+	// read from a healthy replica chosen by ReplicaPolicy (round_robin or least_latency)
+	Replicas             []string      `yaml:"replicas" env:"REPLICAS"`
+	ReplicaPolicy        string        `yaml:"replica_policy" env:"REPLICA_POLICY"`
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" env:"REPLICA_CHECK_INTERVAL"`
+
+	// How often rows past their expires_at time are deleted, defaults to a minute
+	TTLSweepInterval time.Duration `yaml:"ttl_sweep_interval" env:"TTL_SWEEP_INTERVAL"`
+
+	Retry RetryPolicy `yaml:"retry"`
+}
+
+var postgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+var mysqlTLSModes = []string{"true", "false", "skip-verify", "preferred"}
+
+// Validate reports every missing or invalid field of c
+func (c SQLConfig) Validate() error {
+	errs := &config.ValidationError{}
+	switch c.Provider {
+	case POSTGRESQL, MYSQL:
+		errs.Require("host", c.Host)
+		errs.Require("user", c.User)
+		errs.Require("dbname", c.DBName)
+		if c.Port < 0 || c.Port > 65535 {
+			errs.Add("port", "must be between 0 and 65535, got %d", c.Port)
+		}
+		if c.SSLMode != "" {
+			if c.Provider != POSTGRESQL {
+				errs.Add("sslmode", "is only supported by postgresql")
+			} else if !slices.Contains(postgresSSLModes, c.SSLMode) {
+				errs.Add("sslmode", "must be one of %v, got '%s'", postgresSSLModes, c.SSLMode)
+			}
+		}
+		if c.Provider == MYSQL {
+			if c.TLS != "" && !slices.Contains(mysqlTLSModes, c.TLS) {
+				errs.Add("tls", "must be one of %v, got '%s'", mysqlTLSModes, c.TLS)
+			}
+			if (c.TLSCert == "") != (c.TLSKey == "") {
+				errs.Add("tls_cert", "and tls_key must be set together")
+			}
+		} else if c.TLS != "" || c.TLSCA != "" || c.TLSCert != "" || c.TLSKey != "" {
+			errs.Add("tls", "is only supported by mysql, use sslmode and options for postgresql")
+		}
+		if c.ReplicaPolicy != "" && c.ReplicaPolicy != SQL_REPLICA_ROUND_ROBIN && c.ReplicaPolicy != SQL_REPLICA_LEAST_LATENCY {
+			errs.Add("replica_policy", "must be one of %s or %s, got '%s'", SQL_REPLICA_ROUND_ROBIN, SQL_REPLICA_LEAST_LATENCY, c.ReplicaPolicy)
+		}
+	case SQLITE:
+		if len(c.Replicas) > 0 {
+			errs.Add("replicas", "are only supported by postgresql and mysql")
+		}
+	case "":
+		errs.Add("provider", "is required")
+	default:
+		errs.Add("provider", "must be one of postgresql, mysql or sqlite, got '%s'", c.Provider)
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"max_open_conns", int64(c.MaxOpenConns)},
+		{"max_idle_conns", int64(c.MaxIdleConns)},
+		{"conn_max_lifetime", int64(c.ConnMaxLifetime)},
+		{"conn_max_idle_time", int64(c.ConnMaxIdleTime)},
+		{"query_timeout", int64(c.QueryTimeout)},
+		{"replica_check_interval", int64(c.ReplicaCheckInterval)},
+		{"ttl_sweep_interval", int64(c.TTLSweepInterval)},
+	} {
+		if f.value < 0 {
+			errs.Add(f.name, "can't be negative")
+		}
+	}
+	c.Retry.validate(errs)
+	return errs.Err()
+}
+
+func (c SQLConfig) adapterType() StorageAdapterType {
+	return SQL
+}
+
+func (c SQLConfig) toMap() map[string]string {
+	m := map[string]string{}
+	for k, v := range c.Options {
+		m[k] = v
+	}
+	m["provider"] = string(c.Provider)
+	if c.MaxOpenConns > 0 {
+		m["max_open_conns"] = strconv.Itoa(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		m["max_idle_conns"] = strconv.Itoa(c.MaxIdleConns)
+	}
+	for key, d := range map[string]time.Duration{"conn_max_lifetime": c.ConnMaxLifetime, "conn_max_idle_time": c.ConnMaxIdleTime, "query_timeout": c.QueryTimeout, "replica_check_interval": c.ReplicaCheckInterval, "ttl_sweep_interval": c.TTLSweepInterval} {
+		if d > 0 {
+			m[key] = d.String()
+		}
+	}
+	if c.Provider == SQLITE {
+		config.SetIfNotEmpty(m, "path", c.Path)
+		c.Retry.toMap(m)
+		return m
+	}
+
+	port := c.Port
+	if port == 0 {
+		port = 5432
+		if c.Provider == MYSQL {
+			port = 3306
+		}
+	}
+	m["host"] = c.Host
+	m["port"] = strconv.Itoa(port)
+	m["user"] = c.User
+	config.SetIfNotEmpty(m, "password", c.Password)
+	m["dbname"] = c.DBName
+	config.SetIfNotEmpty(m, "schema", c.Schema)
+	config.SetIfNotEmpty(m, "sslmode", c.SSLMode)
+	config.SetIfNotEmpty(m, "tls", c.TLS)
+	config.SetIfNotEmpty(m, "tls_ca", c.TLSCA)
+	config.SetIfNotEmpty(m, "tls_cert", c.TLSCert)
+	config.SetIfNotEmpty(m, "tls_key", c.TLSKey)
+	config.SetIfNotEmpty(m, "replicas", strings.Join(c.Replicas, ","))
+	config.SetIfNotEmpty(m, "replica_policy", c.ReplicaPolicy)
+	c.Retry.toMap(m)
+	return m
+}
+
+// DynamoDBConfig configures the DynamoDB storage adapter. When the region or credentials aren't set the
+// default AWS configuration chain (environment, shared config files, instance roles) is used
+type DynamoDBConfig struct {
+	Region    string `yaml:"region" env:"REGION"`
+	Endpoint  string `yaml:"endpoint" env:"ENDPOINT"` // overrides the service endpoint, e.g. for DynamoDB local
+	AccessKey string `yaml:"access_key" env:"ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"SECRET_KEY"`
+	AllowScan bool   `yaml:"allow_scan" env:"ALLOW_SCAN"` // lets List, Search and Count scan the whole table
+
+	// TablePrefix and TableSuffix are added to every table name, e.g. "prod_"
+	TablePrefix string `yaml:"table_prefix" env:"TABLE_PREFIX"`
+	TableSuffix string `yaml:"table_suffix" env:"TABLE_SUFFIX"`
+
+	Retry RetryPolicy `yaml:"retry"`
+}
+
+// Validate reports every missing or invalid field of c
+func (c DynamoDBConfig) Validate() error {
+	errs := &config.ValidationError{}
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		errs.Add("access_key", "and secret_key must be set together")
+	}
+	errs.AbsoluteURL("endpoint", c.Endpoint)
+	c.Retry.validate(errs)
+	return errs.Err()
+}
+
+func (c DynamoDBConfig) adapterType() StorageAdapterType {
+	return DYNAMODB
+}
+
+func (c DynamoDBConfig) toMap() map[string]string {
+	m := map[string]string{}
+	config.SetIfNotEmpty(m, "region", c.Region)
+	config.SetIfNotEmpty(m, "endpoint", c.Endpoint)
+	config.SetIfNotEmpty(m, "access_key", c.AccessKey)
+	config.SetIfNotEmpty(m, "secret_key", c.SecretKey)
+	config.SetIfNotEmpty(m, "table_prefix", c.TablePrefix)
+	config.SetIfNotEmpty(m, "table_suffix", c.TableSuffix)
+	if c.AllowScan {
+		m["allow_scan"] = "true"
+	}
+	c.Retry.toMap(m)
+	return m
+}
+
+// CosmosDBConfig configures the CosmosDB storage adapter, either with an endpoint and key or with a
+// connection string
+type CosmosDBConfig struct {
+	Endpoint         string `yaml:"endpoint" env:"ENDPOINT"`
+	Key              string `yaml:"key" env:"KEY"`
+	ConnectionString string `yaml:"connection_string" env:"CONNECTION_STRING"`
+	Database         string `yaml:"database" env:"DATABASE" default:"magic"`
+	SkipTLSVerify    bool   `yaml:"skip_tls_verify" env:"SKIP_TLS_VERIFY"` // only for local testing
+	AutoProvision    bool   `yaml:"auto_provision" env:"AUTO_PROVISION"`   // creates missing containers on first write
+
+	// ContainerPrefix and ContainerSuffix are added to every container name, e.g. "prod_"
+	ContainerPrefix string `yaml:"container_prefix" env:"CONTAINER_PREFIX"`
+	ContainerSuffix string `yaml:"container_suffix" env:"CONTAINER_SUFFIX"`
+
+	Retry RetryPolicy `yaml:"retry"`
+}
+
+// Validate reports every missing or invalid field of c
+func (c CosmosDBConfig) Validate() error {
+	errs := &config.ValidationError{}
+	if c.ConnectionString == "" {
+		errs.Require("endpoint", c.Endpoint)
+		errs.Require("key", c.Key)
+	}
+	errs.AbsoluteURL("endpoint", c.Endpoint)
+	c.Retry.validate(errs)
+	return errs.Err()
+}
+
+func (c CosmosDBConfig) adapterType() StorageAdapterType {
+	return COSMOSDB
+}
+
+func (c CosmosDBConfig) toMap() map[string]string {
+	m := map[string]string{}
+	config.SetIfNotEmpty(m, "endpoint", c.Endpoint)
+	config.SetIfNotEmpty(m, "key", c.Key)
+	config.SetIfNotEmpty(m, "connection_string", c.ConnectionString)
+	config.SetIfNotEmpty(m, "database", c.Database)
+	config.SetIfNotEmpty(m, "container_prefix", c.ContainerPrefix)
+	config.SetIfNotEmpty(m, "container_suffix", c.ContainerSuffix)
+	if c.SkipTLSVerify {
+		m["skip_tls_verify"] = "true"
+	}
+	if c.AutoProvision {
+		m["auto_provision"] = "true"
+	}
+	c.Retry.toMap(m)
+	return m
+}
+
+// typedConfig is implemented by the configuration structs of the storage adapters
+type typedConfig interface {
+	config.Validator
+	adapterType() StorageAdapterType
+	toMap() map[string]string
+}
+
+// adapterConfig returns the configuration map used by the adapters of adapterType. cfg may be nil, a
+// map[string]string or the adapter's configuration struct (or a pointer to it), which is validated first
+func adapterConfig(adapterType StorageAdapterType, cfg any) (map[string]string, error) {
+	if adapterType == MEMORY {
+		return map[string]string{}, nil
+	}
+	if isNilConfig(cfg) {
+		cfg = nil
+	}
+
+	switch c := cfg.(type) {
+	case nil:
+		return map[string]string{}, nil
+	case map[string]string:
+		return c, nil
+	case typedConfig:
+		if c.adapterType() != adapterType {
+			return nil, fmt.Errorf("a %T can't be used to configure the %s storage adapter", cfg, adapterType)
+		}
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		return c.toMap(), nil
+	default:
+		return nil, fmt.Errorf("unsupported configuration type %T for the %s storage adapter", cfg, adapterType)
+	}
+}
+
+// isNilConfig reports whether cfg is a nil pointer to a configuration struct
+func isNilConfig(cfg any) bool {
+	switch c := cfg.(type) {
+	case *SQLConfig:
+		return c == nil
+	case *DynamoDBConfig:
+		return c == nil
+	case *CosmosDBConfig:
+		return c == nil
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tink3rlabs/magic/config"
+)
+
+func TestConfigValidate(t *testing.T) {
+	postgres := SQLConfig{Provider: POSTGRESQL, Host: "db", User: "magic", DBName: "magic"}
+	mysql := SQLConfig{Provider: MYSQL, Host: "db", User: "magic", DBName: "magic"}
+	with := func(c SQLConfig, fn func(c *SQLConfig)) SQLConfig {
+		fn(&c)
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		cfg        config.Validator
+		wantFields []string
+	}{
+		{name: "valid postgresql", cfg: postgres},
+		{name: "valid mysql with TLS", cfg: with(mysql, func(c *SQLConfig) { c.TLS, c.TLSCert, c.TLSKey = "true", "cert.pem", "key.pem" })},
+		{name: "valid sqlite", cfg: SQLConfig{Provider: SQLITE}},
+		{name: "missing provider", cfg: SQLConfig{}, wantFields: []string{"provider"}},
+		{name: "unknown provider", cfg: SQLConfig{Provider: "oracle"}, wantFields: []string{"provider"}},
+		{name: "missing connection fields", cfg: SQLConfig{Provider: POSTGRESQL}, wantFields: []string{"host", "user", "dbname"}},
+		{name: "port out of range", cfg: with(postgres, func(c *SQLConfig) { c.Port = 70000 }), wantFields: []string{"port"}},
+		{name: "unknown sslmode", cfg: with(postgres, func(c *SQLConfig) { c.SSLMode = "always" }), wantFields: []string{"sslmode"}},
+		{name: "sslmode on mysql", cfg: with(mysql, func(c *SQLConfig) { c.SSLMode = "require" }), wantFields: []string{"sslmode"}},
+		{name: "unknown mysql tls mode", cfg: with(mysql, func(c *SQLConfig) { c.TLS = "always" }), wantFields: []string{"tls"}},
+		{name: "tls cert without key", cfg: with(mysql, func(c *SQLConfig) { c.TLSCert = "cert.pem" }), wantFields: []string{"tls_cert"}},
+		{name: "tls on postgresql", cfg: with(postgres, func(c *SQLConfig) { c.TLSCA = "ca.pem" }), wantFields: []string{"tls"}},
+		{name: "unknown replica policy", cfg: with(postgres, func(c *SQLConfig) { c.ReplicaPolicy = "random" }), wantFields: []string{"replica_policy"}},
+		{name: "replicas on sqlite", cfg: SQLConfig{Provider: SQLITE, Replicas: []string{"replica"}}, wantFields: []string{"replicas"}},
+		{
+			name: "negative durations and retry settings",
+			cfg: with(postgres, func(c *SQLConfig) {
+				c.QueryTimeout, c.TTLSweepInterval = -time.Second, -time.Minute
+				c.Retry = RetryPolicy{MaxAttempts: -1, Jitter: 2}
+			}),
+			wantFields: []string{"query_timeout", "ttl_sweep_interval", "retry.max_attempts", "retry.jitter"},
+		},
+		{name: "valid dynamodb", cfg: DynamoDBConfig{Region: "us-east-1", Endpoint: "http://localhost:8000"}},
+		{name: "dynamodb access key without secret", cfg: DynamoDBConfig{AccessKey: "key"}, wantFields: []string{"access_key"}},
+		{name: "relative dynamodb endpoint", cfg: DynamoDBConfig{Endpoint: "localhost:8000"}, wantFields: []string{"endpoint"}},
+		{name: "valid cosmosdb", cfg: CosmosDBConfig{Endpoint: "https://account.documents.azure.com:443/", Key: "key"}},
+		{name: "cosmosdb connection string", cfg: CosmosDBConfig{ConnectionString: "AccountEndpoint=https://account.documents.azure.com:443/;AccountKey=key;"}},
+		{name: "missing cosmosdb credentials", cfg: CosmosDBConfig{}, wantFields: []string{"endpoint", "key"}},
+		{name: "relative cosmosdb endpoint", cfg: CosmosDBConfig{Endpoint: "account", Key: "key"}, wantFields: []string{"endpoint"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+			var validationErr *config.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want a config.ValidationError", err)
+			}
+			got := []string{}
+			for _, f := range validationErr.Errors {
+				got = append(got, f.Field)
+			}
+			if !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("Validate() fields = %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestAdapterConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		adapterType StorageAdapterType
+		cfg         any
+		want        map[string]string
+		wantErr     string
+	}{
+		{name: "nil", adapterType: SQL, cfg: nil, want: map[string]string{}},
+		{name: "nil struct pointer", adapterType: DYNAMODB, cfg: (*DynamoDBConfig)(nil), want: map[string]string{}},
+		{name: "map passed as is", adapterType: SQL, cfg: map[string]string{"provider": "sqlite"}, want: map[string]string{"provider": "sqlite"}},
+		{name: "memory ignores its config", adapterType: MEMORY, cfg: SQLConfig{}, want: map[string]string{}},
+		{
+			name:        "sql defaults the port",
+			adapterType: SQL,
+			cfg:         &SQLConfig{Provider: MYSQL, Host: "db", User: "magic", DBName: "magic", QueryTimeout: time.Second, Options: map[string]string{"charset": "utf8mb4"}},
+			want:        map[string]string{"provider": "mysql", "host": "db", "port": "3306", "user": "magic", "dbname": "magic", "query_timeout": "1s", "charset": "utf8mb4"},
+		},
+		{
+			name:        "sqlite only keeps its settings",
+			adapterType: SQL,
+			cfg:         SQLConfig{Provider: SQLITE, Path: "magic.db", Host: "ignored"},
+			want:        map[string]string{"provider": "sqlite", "path": "magic.db"},
+		},
+		{
+			name:        "dynamodb with retries",
+			adapterType: DYNAMODB,
+			cfg:         DynamoDBConfig{Region: "us-east-1", AllowScan: true, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond}},
+			want:        map[string]string{"region": "us-east-1", "allow_scan": "true", "retry_max_attempts": "3", "retry_initial_backoff": "50ms"},
+		},
+		{
+			name:        "cosmosdb",
+			adapterType: COSMOSDB,
+			cfg:         CosmosDBConfig{Endpoint: "https://account.documents.azure.com:443/", Key: "key", Database: "magic", AutoProvision: true},
+			want:        map[string]string{"endpoint": "https://account.documents.azure.com:443/", "key": "key", "database": "magic", "auto_provision": "true"},
+		},
+		{name: "invalid config", adapterType: SQL, cfg: SQLConfig{Provider: POSTGRESQL}, wantErr: "host is required"},
+		{name: "wrong config type", adapterType: SQL, cfg: DynamoDBConfig{}, wantErr: "can't be used to configure the sql storage adapter"},
+		{name: "unsupported type", adapterType: COSMOSDB, cfg: "endpoint", wantErr: "unsupported configuration type string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adapterConfig(tt.adapterType, tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("adapterConfig() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("adapterConfig() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("adapterConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
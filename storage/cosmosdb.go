@@ -4,11 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,7 +17,10 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
 	"github.com/tink3rlabs/magic/logger"
+	"github.com/tink3rlabs/magic/storage/search/lucene"
 )
 
 type CosmosDBAdapter struct {
@@ -25,28 +28,80 @@ type CosmosDBAdapter struct {
 	databaseClient *azcosmos.DatabaseClient
 	config         map[string]string
 	databaseName   string
+	retrier        *retrier
+	provisioned    sync.Map // names of the containers created by auto_provision
+}
+
+// cosmosWrite holds everything needed to perform a single document write
+type cosmosWrite struct {
+	container     *azcosmos.ContainerClient
+	containerName string
+	partitionKey  string
+	id            string
+	item          []byte
+	etag          *azcore.ETag
 }
 
 var cosmosDBAdapterLock = &sync.Mutex{}
 var cosmosDBAdapterInstance *CosmosDBAdapter
 
+// GetCosmosDBAdapterInstance returns the process-wide CosmosDBAdapter, exiting the process if it can't connect
 func GetCosmosDBAdapterInstance(config map[string]string) *CosmosDBAdapter {
+	s, err := getCosmosDBAdapterInstance(config)
+	if err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+	return s
+}
+
+func getCosmosDBAdapterInstance(config map[string]string) (*CosmosDBAdapter, error) {
+	cosmosDBAdapterLock.Lock()
+	defer cosmosDBAdapterLock.Unlock()
 	if cosmosDBAdapterInstance == nil {
-		cosmosDBAdapterLock.Lock()
-		defer cosmosDBAdapterLock.Unlock()
-		if cosmosDBAdapterInstance == nil {
-			cosmosDBAdapterInstance = &CosmosDBAdapter{config: config}
-			cosmosDBAdapterInstance.OpenConnection()
+		s, err := NewCosmosDBAdapter(config)
+		if err != nil {
+			return nil, err
 		}
+		cosmosDBAdapterInstance = s
 	}
-	return cosmosDBAdapterInstance
+	return cosmosDBAdapterInstance, nil
 }
 
+// NewCosmosDBAdapter returns a new CosmosDBAdapter with its own client, unlike GetCosmosDBAdapterInstance
+// which returns a process-wide instance
+func NewCosmosDBAdapter(config map[string]string) (*CosmosDBAdapter, error) {
+	s := &CosmosDBAdapter{config: copyConfig(config)}
+	if err := s.Connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the adapter's resources. The CosmosDB client holds no persistent connections so there
+// is nothing to release, it exists so all adapters can be closed the same way
+func (s *CosmosDBAdapter) Close() error {
+	return nil
+}
+
+// OpenConnection creates the CosmosDB client, exiting the process if it can't
 func (s *CosmosDBAdapter) OpenConnection() {
+	if err := s.Connect(); err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+}
+
+// Connect creates the CosmosDB client
+func (s *CosmosDBAdapter) Connect() error {
 	var endpoint string
 	var key string
 	var databaseName string
 
+	retrier, err := newRetrier(s.config, classifyCosmosDBError)
+	if err != nil {
+		return err
+	}
+	s.retrier = retrier
+
 	if connStr, exists := s.config["connection_string"]; exists {
 		// Use connection string directly
 		endpoint = connStr
@@ -60,7 +115,7 @@ func (s *CosmosDBAdapter) OpenConnection() {
 		}
 
 		if endpoint == "" || key == "" {
-			logger.Fatal("CosmosDB endpoint and key are required")
+			return errors.New("CosmosDB endpoint and key are required")
 		}
 	}
 
@@ -70,7 +125,6 @@ func (s *CosmosDBAdapter) OpenConnection() {
 	skipTLS, _ := strconv.ParseBool(s.config["skip_tls_verify"])
 
 	// Create Azure Cosmos DB client
-	var err error
 	var clientOptions *azcosmos.ClientOptions
 
 	if skipTLS {
@@ -93,40 +147,49 @@ func (s *CosmosDBAdapter) OpenConnection() {
 		// Use account key authentication
 		keyCredential, keyErr := azcosmos.NewKeyCredential(key)
 		if keyErr != nil {
-			logger.Fatal("Failed to create key credential", slog.Any("error", keyErr.Error()))
+			return fmt.Errorf("failed to create key credential: %v", keyErr)
 		}
 		s.client, err = azcosmos.NewClientWithKey(endpoint, keyCredential, clientOptions)
 	} else {
 		// Use Azure AD authentication
 		credential, credErr := azidentity.NewDefaultAzureCredential(nil)
 		if credErr != nil {
-			logger.Fatal("Failed to obtain Azure credential", slog.Any("error", credErr.Error()))
+			return fmt.Errorf("failed to obtain Azure credential: %v", credErr)
 		}
 		s.client, err = azcosmos.NewClient(endpoint, credential, clientOptions)
 	}
 
 	if err != nil {
-		logger.Fatal("Failed to create CosmosDB client", slog.Any("error", err.Error()))
+		return fmt.Errorf("failed to create CosmosDB client: %v", err)
 	}
 
 	// Get database client
 	s.databaseClient, err = s.client.NewDatabase(databaseName)
 	if err != nil {
-		logger.Fatal("Failed to create database client", slog.Any("error", err.Error()))
+		return fmt.Errorf("failed to create database client: %v", err)
 	}
 
 	slog.Debug("Connected to CosmosDB using Azure SDK")
+	return nil
 }
 
 func (s *CosmosDBAdapter) Execute(statement string) error {
+	return s.ExecuteContext(context.Background(), statement)
+}
+
+func (s *CosmosDBAdapter) ExecuteContext(ctx context.Context, statement string) error {
 	// Azure SDK doesn't support arbitrary SQL execution like gocosmos
 	// This method is kept for compatibility but will return an error
 	return fmt.Errorf("Execute method not supported with Azure SDK - use specific CRUD methods instead")
 }
 
 func (s *CosmosDBAdapter) Ping() error {
+	return s.PingContext(context.Background())
+}
+
+func (s *CosmosDBAdapter) PingContext(ctx context.Context) error {
 	// Test connection by trying to read database properties
-	_, err := s.databaseClient.Read(context.Background(), nil)
+	_, err := s.databaseClient.Read(ctx, nil)
 	return err
 }
 
@@ -163,79 +226,117 @@ func (s *CosmosDBAdapter) GetLatestMigration() (int, error) {
 }
 
 func (s *CosmosDBAdapter) Create(item any, params ...map[string]any) error {
-	// Extract provider-specific parameters
-	paramMap := s.extractParams(params...)
+	return s.CreateContext(context.Background(), item, params...)
+}
 
-	containerName := s.getContainerName(item)
-	containerClient, err := s.databaseClient.NewContainer(containerName)
+func (s *CosmosDBAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return s.retrier.do(ctx, false, func() error {
+		w, err := s.prepareCreate(ctx, item, s.extractParams(params...))
+		if err != nil {
+			return err
+		}
+
+		// Create item
+		response, err := w.container.CreateItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.item, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create item: %w", err)
+		}
+		setETag(item, response.ETag)
+
+		return nil
+	})
+}
+
+// prepareCreate resolves the container, partition key and document body used to create item
+func (s *CosmosDBAdapter) prepareCreate(ctx context.Context, item any, paramMap map[string]any) (cosmosWrite, error) {
+	w := cosmosWrite{containerName: s.getContainerName(item)}
+	if err := s.autoProvision(ctx, item); err != nil {
+		return w, err
+	}
+	containerClient, err := s.databaseClient.NewContainer(w.containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return w, fmt.Errorf("failed to create container client: %v", err)
+	}
+	w.container = containerClient
+
+	if err := initVersion(item); err != nil {
+		return w, err
+	}
+	if err := applyTTL(item, paramMap); err != nil {
+		return w, err
 	}
 
 	// Convert item to map to work with individual fields
 	itemMap := s.itemToMap(item)
+	setCosmosTTL(item, itemMap)
 
 	// Ensure id field exists
 	if _, exists := itemMap["id"]; !exists {
-		return fmt.Errorf("item must have an id field")
+		return w, fmt.Errorf("item must have an id field")
 	}
+	w.id = fmt.Sprintf("%v", itemMap["id"])
 
 	// Build partition key from params if provided
-	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+	pkFieldName := s.getPartitionKeyFieldName(item, paramMap)
+	if pk, err := s.buildPartitionKey(item, paramMap); err != nil {
+		return w, fmt.Errorf("failed to build partition key: %v", err)
 	} else if pk != "" {
 		// Set the partition key value in the item
-		pkFieldName := s.getPartitionKeyFieldName(paramMap)
 		itemMap[pkFieldName] = pk
-	} else if _, exists := itemMap["pk"]; !exists {
-		// If no partition key is provided and item doesn't have pk, use id as partition key
-		if id, exists := itemMap["id"]; exists {
-			itemMap["pk"] = id
-		}
+	} else if _, exists := itemMap[pkFieldName]; !exists {
+		// If no partition key is provided and item doesn't have one, use id as partition key
+		itemMap[pkFieldName] = w.id
 	}
 
 	// Marshal item to JSON
-	itemBytes, err := json.Marshal(itemMap)
+	w.item, err = json.Marshal(itemMap)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item: %v", err)
+		return w, fmt.Errorf("failed to marshal item: %v", err)
 	}
 
 	// Get the partition key value from the item
-	pkFieldName := s.getPartitionKeyFieldName(paramMap)
-	var pkValue string
-	if pk, exists := itemMap[pkFieldName]; exists {
-		pkValue = pk.(string)
-	} else if pk, exists := itemMap["pk"]; exists {
-		// Fallback to "pk" field if custom field doesn't exist
-		pkValue = pk.(string)
-	} else {
-		return fmt.Errorf("partition key field '%s' not found in item", pkFieldName)
-	}
+	w.partitionKey = fmt.Sprint(itemMap[pkFieldName])
 
-	// Create partition key
-	partitionKey := azcosmos.NewPartitionKeyString(pkValue)
+	return w, nil
+}
 
-	// Create item
-	_, err = containerClient.CreateItem(context.Background(), partitionKey, itemBytes, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create item: %v", err)
-	}
+func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return s.GetContext(context.Background(), dest, filter, params...)
+}
 
-	return nil
+func (s *CosmosDBAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		paramMap := s.extractParams(params...)
+		live, err := liveItemsField(dest, paramMap)
+		if err != nil {
+			return err
+		}
+		document, err := s.getDocument(ctx, dest, filter, paramMap, live)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal first result
+		err = json.Unmarshal(document, dest)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal result: %v", err)
+		}
+
+		return nil
+	})
 }
 
-func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+// getDocument returns the raw JSON of the first document in model's container matching filter, leaving out soft
+// deleted documents when live is set
+func (s *CosmosDBAdapter) getDocument(ctx context.Context, model any, filter map[string]any, paramMap map[string]any, live *modelField) ([]byte, error) {
 	if len(filter) == 0 {
-		return fmt.Errorf("filtering is required when getting a resource")
+		return nil, fmt.Errorf("filtering is required when getting a resource")
 	}
 
-	// Extract provider-specific parameters
-	paramMap := s.extractParams(params...)
-
-	containerName := s.getContainerName(dest)
+	containerName := s.getContainerName(model)
 	containerClient, err := s.databaseClient.NewContainer(containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return nil, fmt.Errorf("failed to create container client: %v", err)
 	}
 
 	// Build query
@@ -255,10 +356,10 @@ func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[str
 	}
 
 	// Add partition key condition if provided in params
-	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+	if pk, err := s.buildPartitionKey(model, paramMap); err != nil {
+		return nil, fmt.Errorf("failed to build partition key: %v", err)
 	} else if pk != "" {
-		pkFieldName := s.getPartitionKeyFieldName(paramMap)
+		pkFieldName := s.getPartitionKeyFieldName(model, paramMap)
 		paramName := fmt.Sprintf("@param%d", paramIndex)
 		conditions = append(conditions, fmt.Sprintf("c.%s = %s", pkFieldName, paramName))
 		queryParams = append(queryParams, azcosmos.QueryParameter{
@@ -267,6 +368,15 @@ func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[str
 		})
 	}
 
+	if live != nil {
+		conditions = append(conditions, cosmosLiveItemsCondition(live))
+	}
+	if expiry, err := expiryField(model); err != nil {
+		return nil, err
+	} else if expiry != nil {
+		conditions = append(conditions, cosmosUnexpiredCondition(expiry))
+	}
+
 	// Add WHERE clause if we have conditions
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
@@ -278,37 +388,174 @@ func (s *CosmosDBAdapter) Get(dest any, filter map[string]any, params ...map[str
 	}
 
 	// Execute query
-	page, err := s.executeQuery(containerClient, query, paramMap, queryOptions)
+	page, err := s.executeQuery(ctx, containerClient, model, query, paramMap, queryOptions)
 	if err != nil {
-		return fmt.Errorf("failed to execute query: %v", err)
+		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
 
 	if len(page.Items) == 0 {
-		return ErrNotFound
+		return nil, ErrNotFound
 	}
 
-	// Unmarshal first result
-	err = json.Unmarshal(page.Items[0], dest)
+	return s.withETag(model, page.Items[0]), nil
+}
+
+func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return s.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (s *CosmosDBAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		version, _, _ := getVersion(item)
+		w, err := s.prepareUpdate(ctx, item, filter, params...)
+		if err != nil {
+			setVersion(item, version)
+			return err
+		}
+
+		// Update item
+		response, err := w.container.ReplaceItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, w.item, &azcosmos.ItemOptions{IfMatchEtag: w.etag})
+		if err != nil {
+			setVersion(item, version)
+			if cosmosStatusCode(err) == http.StatusPreconditionFailed {
+				return ErrConflict
+			}
+			return fmt.Errorf("failed to update item: %w", err)
+		}
+		setETag(item, response.ETag)
+
+		return nil
+	})
+}
+
+// checkVersion verifies that item is based on the stored document, comparing the item's version field and/or
+// etag field to the stored ones. It returns the etag the replacement must match, or nil if item isn't versioned
+func (s *CosmosDBAdapter) checkVersion(item any, existingItem any, document []byte) (*azcore.ETag, error) {
+	etagField := getModelMetadata(item).field("etag")
+	version, versioned, err := getVersion(item)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal result: %v", err)
+		return nil, err
+	}
+	if !versioned && etagField == nil {
+		return nil, nil
 	}
 
-	return nil
+	var system struct {
+		ETag azcore.ETag `json:"_etag"`
+	}
+	if err := json.Unmarshal(document, &system); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %v", err)
+	}
+
+	if etagField != nil {
+		etag := etagField.value(item)
+		if etag.Kind() == reflect.String && etag.String() != "" && etag.String() != string(system.ETag) {
+			return nil, ErrConflict
+		}
+	}
+	if versioned {
+		if existingVersion, _, _ := getVersion(existingItem); existingVersion != version {
+			return nil, ErrConflict
+		}
+		setVersion(item, version+1)
+	}
+	return &system.ETag, nil
 }
 
-func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
+// withETag copies the _etag system property of document into model's etag field when its json name differs
+func (s *CosmosDBAdapter) withETag(model any, document []byte) []byte {
+	etagField := getModelMetadata(model).field("etag")
+	if etagField == nil || etagField.Key == "_etag" {
+		return document
+	}
+	var documentMap map[string]any
+	if err := json.Unmarshal(document, &documentMap); err != nil {
+		return document
+	}
+	documentMap[etagField.Key] = documentMap["_etag"]
+	result, err := json.Marshal(documentMap)
+	if err != nil {
+		return document
+	}
+	return result
+}
+
+// setETag sets the etag field of item, if it has one
+func setETag(item any, etag azcore.ETag) {
+	etagField := getModelMetadata(item).field("etag")
+	if etagField == nil {
+		return
+	}
+	if v := etagField.value(item); v.CanSet() && v.Kind() == reflect.String {
+		v.SetString(string(etag))
+	}
+}
+
+// classifyCosmosDBError maps failed requests to storage errors by status code, see cosmosStatusError. Transient
+// errors are retried after the delay requested in their x-ms-retry-after-ms header, if any
+func classifyCosmosDBError(err error) (error, bool, time.Duration) {
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) {
+		return nil, false, 0
+	}
+	kind, transient := cosmosStatusError(responseErr.StatusCode)
+	var after time.Duration
+	if transient && responseErr.RawResponse != nil {
+		if ms, parseErr := strconv.ParseFloat(responseErr.RawResponse.Header.Get("x-ms-retry-after-ms"), 64); parseErr == nil {
+			after = time.Duration(ms * float64(time.Millisecond))
+		} else if seconds, parseErr := strconv.Atoi(responseErr.RawResponse.Header.Get("Retry-After")); parseErr == nil {
+			after = time.Duration(seconds) * time.Second
+		}
+	}
+	return kind, transient, after
+}
+
+// cosmosStatusError returns the storage error matching a Cosmos DB status code and whether it's transient:
+// 409 is ErrAlreadyExists, 412 ErrPreconditionFailed, 429 ErrThrottled, 408 and 503 ErrUnavailable and
+// 449 (retry with) ErrConflict
+func cosmosStatusError(statusCode int) (error, bool) {
+	switch statusCode {
+	case http.StatusConflict:
+		return ErrAlreadyExists, false
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed, false
+	case http.StatusTooManyRequests:
+		return ErrThrottled, true
+	case http.StatusRequestTimeout, http.StatusServiceUnavailable:
+		return ErrUnavailable, true
+	case 449:
+		return ErrConflict, true
+	}
+	return nil, false
+}
+
+// cosmosStatusCode returns the HTTP status code of a failed Cosmos DB request, or 0 if err isn't a response error
+func cosmosStatusCode(err error) int {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode
+	}
+	return 0
+}
+
+// prepareUpdate reads the existing document matching filter and merges item into it
+func (s *CosmosDBAdapter) prepareUpdate(ctx context.Context, item any, filter map[string]any, params ...map[string]any) (cosmosWrite, error) {
+	w := cosmosWrite{containerName: s.getContainerName(item)}
 	if len(filter) == 0 {
-		return fmt.Errorf("filtering is required when updating a resource")
+		return w, fmt.Errorf("filtering is required when updating a resource")
 	}
 
 	// Extract provider-specific parameters
 	paramMap := s.extractParams(params...)
+	if err := applyTTL(item, paramMap); err != nil {
+		return w, err
+	}
 
-	containerName := s.getContainerName(item)
-	containerClient, err := s.databaseClient.NewContainer(containerName)
+	containerClient, err := s.databaseClient.NewContainer(w.containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return w, fmt.Errorf("failed to create container client: %v", err)
 	}
+	w.container = containerClient
 
 	// First get the item to update
 	itemType := reflect.TypeOf(item)
@@ -317,9 +564,17 @@ func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[
 	}
 	existingItem := reflect.New(itemType).Interface()
 
-	err = s.Get(existingItem, filter, params...)
+	document, err := s.getDocument(ctx, existingItem, filter, paramMap, nil)
 	if err != nil {
-		return err
+		return w, err
+	}
+	if err := json.Unmarshal(document, existingItem); err != nil {
+		return w, fmt.Errorf("failed to unmarshal result: %v", err)
+	}
+
+	// Versioned items are only replaced if they haven't changed since they were read
+	if w.etag, err = s.checkVersion(item, existingItem, document); err != nil {
+		return w, err
 	}
 
 	// Convert existing item to map for merging
@@ -330,6 +585,7 @@ func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[
 	for key, value := range itemMap {
 		existingItemMap[key] = value
 	}
+	setCosmosTTL(item, existingItemMap)
 
 	// Update timestamp
 	existingItemMap["_ts"] = time.Now().Unix()
@@ -337,18 +593,19 @@ func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[
 	// Ensure id and pk fields exist
 	id, exists := existingItemMap["id"]
 	if !exists {
-		return fmt.Errorf("item does not have an id field")
+		return w, fmt.Errorf("item does not have an id field")
 	}
+	w.id = fmt.Sprint(id)
 
 	// Get the partition key field name
-	pkFieldName := s.getPartitionKeyFieldName(paramMap)
+	pkFieldName := s.getPartitionKeyFieldName(item, paramMap)
 
 	// Get or set the partition key value
 	pk, exists := existingItemMap[pkFieldName]
 	if !exists {
 		// Check if partition key is provided in params
-		if paramPk, err := s.buildPartitionKey(paramMap); err != nil {
-			return fmt.Errorf("failed to build partition key: %v", err)
+		if paramPk, err := s.buildPartitionKey(item, paramMap); err != nil {
+			return w, fmt.Errorf("failed to build partition key: %v", err)
 		} else if paramPk != "" {
 			pk = paramPk
 			existingItemMap[pkFieldName] = pk
@@ -363,164 +620,504 @@ func (s *CosmosDBAdapter) Update(item any, filter map[string]any, params ...map[
 			}
 		}
 	}
+	w.partitionKey = fmt.Sprint(pk)
 
 	// Marshal updated item
-	itemBytes, err := json.Marshal(existingItemMap)
+	w.item, err = json.Marshal(existingItemMap)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item: %v", err)
+		return w, fmt.Errorf("failed to marshal item: %v", err)
 	}
 
-	// Create partition key
-	partitionKey := azcosmos.NewPartitionKeyString(pk.(string))
+	return w, nil
+}
 
-	// Update item
-	_, err = containerClient.ReplaceItem(context.Background(), partitionKey, id.(string), itemBytes, nil)
+func (s *CosmosDBAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return s.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (s *CosmosDBAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := softDeleteField(item)
 	if err != nil {
-		return fmt.Errorf("failed to update item: %v", err)
+		return err
 	}
+	if f == nil {
+		return s.Purge(ctx, item, filter, params...)
+	}
+	return s.retrier.do(ctx, true, func() error {
+		w, err := s.prepareDelete(item, filter, s.extractParams(params...))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		deleted, err := s.softDelete(ctx, w, f, now)
+		if deleted {
+			setDeletedAt(item, f, &now)
+		}
+		return err
+	})
+}
 
-	return nil
+// softDelete sets the deleted_at property of the document w refers to and reports whether it did. Like the other
+// adapters, deleting a document that doesn't exist or was already deleted does nothing
+func (s *CosmosDBAdapter) softDelete(ctx context.Context, w cosmosWrite, f *modelField, now time.Time) (bool, error) {
+	_, err := w.container.PatchItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, cosmosSoftDeletePatch(f, now), nil)
+	if status := cosmosStatusCode(err); status == http.StatusNotFound || status == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete item: %w", err)
+	}
+	return true, nil
 }
 
-func (s *CosmosDBAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
-	if len(filter) == 0 {
-		return fmt.Errorf("an id filter is required when deleting a resource")
+// Restore undeletes the soft deleted document matching filter, returning ErrNotFound if there's none
+func (s *CosmosDBAdapter) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		f, err := requireSoftDeleteField(item)
+		if err != nil {
+			return err
+		}
+		w, err := s.prepareDelete(item, filter, s.extractParams(params...))
+		if err != nil {
+			return err
+		}
+		_, err = w.container.PatchItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, cosmosRestorePatch(f), nil)
+		if status := cosmosStatusCode(err); status == http.StatusNotFound || status == http.StatusPreconditionFailed {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore item: %w", err)
+		}
+		setDeletedAt(item, f, nil)
+		return nil
+	})
+}
+
+// Patch changes the properties in changes of the document matching filter with the patch API, filling item with
+// the patched document. Cosmos DB applies at most COSMOSDB_MAX_PATCH_OPERATIONS operations per patch, each value
+// appended counts as one, only supports integer increments, and can only append to existing arrays
+func (s *CosmosDBAdapter) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	changes, idempotent, err := preparePatch(item, changes, jsonFieldName)
+	if err != nil {
+		return err
 	}
+	return s.retrier.do(ctx, idempotent, func() error {
+		paramMap := s.extractParams(params...)
+		w, err := s.prepareDelete(item, filter, paramMap)
+		if err != nil {
+			return err
+		}
+		patch, err := cosmosPatch(item, changes, paramMap)
+		if err != nil {
+			return err
+		}
+		response, err := w.container.PatchItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, patch, &azcosmos.ItemOptions{EnableContentResponseOnWrite: true})
+		if status := cosmosStatusCode(err); status == http.StatusNotFound || status == http.StatusPreconditionFailed {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to patch item: %w", err)
+		}
+		if err := json.Unmarshal(response.Value, item); err != nil {
+			return fmt.Errorf("failed to unmarshal patched item: %v", err)
+		}
+		setETag(item, response.ETag)
+		return nil
+	})
+}
 
-	// Extract provider-specific parameters
-	paramMap := s.extractParams(params...)
+const COSMOSDB_MAX_PATCH_OPERATIONS = 10
 
-	containerName := s.getContainerName(item)
-	containerClient, err := s.databaseClient.NewContainer(containerName)
+// cosmosPatch converts changes into patch operations, conditioned like reads on the document not being expired or
+// soft deleted
+func cosmosPatch(item any, changes map[string]any, paramMap map[string]any) (azcosmos.PatchOperations, error) {
+	patch := azcosmos.PatchOperations{}
+	condition, err := cosmosLiveItems(item, paramMap, "")
 	if err != nil {
-		return fmt.Errorf("failed to create container client: %v", err)
+		return patch, err
 	}
+	if condition != "" {
+		patch.SetCondition("FROM c WHERE " + condition)
+	}
+
+	expiry, err := expiryField(item)
+	if err != nil {
+		return patch, err
+	}
+
+	operations := 0
+	for key, change := range changes {
+		op, ok := change.(PatchOperation)
+		switch {
+		case !ok && expiry != nil && key == expiry.Key:
+			// ttl makes Cosmos DB delete the document once it expires, see setCosmosTTL. -1 never expires it
+			ttl := int64(-1)
+			if expiresAt, _ := change.(*time.Time); expiresAt != nil {
+				ttl = cosmosTTLSeconds(*expiresAt)
+			}
+			patch.AppendSet("/"+key, change)
+			patch.AppendSet("/ttl", ttl)
+			operations += 2
+		case !ok:
+			patch.AppendSet("/"+key, change)
+			operations++
+		case op.Op == PATCH_INCREMENT:
+			v := reflect.ValueOf(op.Value)
+			switch {
+			case v.CanInt():
+				patch.AppendIncrement("/"+key, v.Int())
+			case v.CanUint():
+				patch.AppendIncrement("/"+key, int64(v.Uint()))
+			default:
+				return patch, fmt.Errorf("can't increment %s by %v, cosmosdb only supports integer increments", key, op.Value)
+			}
+			operations++
+		case op.Op == PATCH_APPEND:
+			for _, value := range op.Value.([]any) {
+				patch.AppendAdd("/"+key+"/-", value)
+				operations++
+			}
+		}
+	}
+	if f := getModelMetadata(item).field("version"); f != nil {
+		patch.AppendIncrement("/"+f.Key, 1)
+		operations++
+	}
+	if operations > COSMOSDB_MAX_PATCH_OPERATIONS {
+		return patch, fmt.Errorf("a cosmosdb patch can't contain more than %d operations, got %d", COSMOSDB_MAX_PATCH_OPERATIONS, operations)
+	}
+	return patch, nil
+}
+
+// cosmosSoftDeletePatch returns the patch setting the deleted_at property f to now, conditioned on the document
+// not being deleted yet so deleting it twice keeps its first deletion time
+func cosmosSoftDeletePatch(f *modelField, now time.Time) azcosmos.PatchOperations {
+	patch := azcosmos.PatchOperations{}
+	patch.SetCondition("FROM c WHERE " + cosmosLiveItemsCondition(f))
+	patch.AppendSet("/"+f.Key, now)
+	return patch
+}
 
-	id := filter["id"]
+// cosmosRestorePatch returns the patch clearing the deleted_at property f, conditioned on the document being
+// soft deleted
+func cosmosRestorePatch(f *modelField) azcosmos.PatchOperations {
+	patch := azcosmos.PatchOperations{}
+	patch.SetCondition(fmt.Sprintf("FROM c WHERE IS_DEFINED(c.%s) AND NOT IS_NULL(c.%s)", f.Key, f.Key))
+	patch.AppendSet("/"+f.Key, nil)
+	return patch
+}
+
+// cosmosLiveItems adds the conditions leaving out expired documents of model to condition, as well as its soft
+// deleted documents unless paramMap includes INCLUDE_DELETED
+func cosmosLiveItems(model any, paramMap map[string]any, condition string) (string, error) {
+	conditions := []string{}
+	f, err := liveItemsField(model, paramMap)
+	if err != nil {
+		return "", err
+	}
+	if f != nil {
+		conditions = append(conditions, cosmosLiveItemsCondition(f))
+	}
+	f, err = expiryField(model)
+	if err != nil {
+		return "", err
+	}
+	if f != nil {
+		conditions = append(conditions, cosmosUnexpiredCondition(f))
+	}
+
+	if len(conditions) == 0 {
+		return condition, nil
+	}
+	if condition != "" {
+		conditions = append([]string{fmt.Sprintf("(%s)", condition)}, conditions...)
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// cosmosLiveItemsCondition returns the condition leaving out documents whose deleted_at property f is set
+func cosmosLiveItemsCondition(f *modelField) string {
+	return fmt.Sprintf("(NOT IS_DEFINED(c.%s) OR IS_NULL(c.%s))", f.Key, f.Key)
+}
+
+// cosmosUnexpiredCondition returns the condition leaving out documents whose expires_at property f has passed.
+// Expiry times are stored in UTC with a one second precision so they compare as strings. The time is single quoted
+// as the condition of a patch is written into its JSON body without escaping
+func cosmosUnexpiredCondition(f *modelField) string {
+	return fmt.Sprintf(`(NOT IS_DEFINED(c.%s) OR IS_NULL(c.%s) OR c.%s > '%s')`, f.Key, f.Key, f.Key, expiryNow().Format(time.RFC3339))
+}
+
+// setCosmosTTL sets the ttl property of the document itemMap to the number of seconds until item expires, which
+// makes Cosmos DB delete it once it expires. ttl is only honoured in containers with time to live enabled
+func setCosmosTTL(item any, itemMap map[string]any) {
+	f, err := expiryField(item)
+	if err != nil || f == nil {
+		return
+	}
+	if expiresAt := getExpiresAt(item, f); expiresAt != nil {
+		itemMap["ttl"] = cosmosTTLSeconds(*expiresAt)
+	}
+}
+
+// cosmosTTLSeconds returns the ttl of a document expiring at expiresAt, rounded up to a whole number of seconds
+func cosmosTTLSeconds(expiresAt time.Time) int64 {
+	return max(int64((time.Until(expiresAt)+time.Second-1)/time.Second), 1)
+}
+
+// Purge deletes the document matching filter for good, whether it was soft deleted or not
+func (s *CosmosDBAdapter) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		w, err := s.prepareDelete(item, filter, s.extractParams(params...))
+		if err != nil {
+			return err
+		}
+
+		// Delete item
+		_, err = w.container.DeleteItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, nil)
+		if err != nil {
+			return fmt.Errorf("failed to delete item: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// prepareDelete resolves the container, id and partition key of the document matching filter
+func (s *CosmosDBAdapter) prepareDelete(item any, filter map[string]any, paramMap map[string]any) (cosmosWrite, error) {
+	w := cosmosWrite{containerName: s.getContainerName(item)}
+	if len(filter) == 0 {
+		return w, fmt.Errorf("an id filter is required when deleting a resource")
+	}
+
+	containerClient, err := s.databaseClient.NewContainer(w.containerName)
+	if err != nil {
+		return w, fmt.Errorf("failed to create container client: %v", err)
+	}
+	w.container = containerClient
+
+	id, exists := filter["id"]
+	if !exists {
+		return w, fmt.Errorf("an id filter is required when deleting a resource")
+	}
+	w.id = fmt.Sprint(id)
 
 	// Try to get partition key from params first
-	pk, err := s.buildPartitionKey(paramMap)
+	pk, err := s.buildPartitionKey(item, paramMap)
 	if err != nil {
-		return fmt.Errorf("failed to build partition key: %v", err)
+		return w, fmt.Errorf("failed to build partition key: %v", err)
 	}
 
 	// If no partition key from params, try to get from filter
 	if pk == "" {
-		if filterPk, exists := filter["pk"]; exists {
-			pk = filterPk.(string)
+		if filterPk, exists := filter[s.getPartitionKeyFieldName(item, paramMap)]; exists {
+			pk = fmt.Sprint(filterPk)
+		} else if filterPk, exists := filter["pk"]; exists {
+			pk = fmt.Sprint(filterPk)
 		} else {
 			// Fallback to id
-			pk = id.(string)
+			pk = w.id
 		}
 	}
+	w.partitionKey = pk
 
-	// Create partition key
-	partitionKey := azcosmos.NewPartitionKeyString(pk)
-
-	// Delete item
-	_, err = containerClient.DeleteItem(context.Background(), partitionKey, id.(string), nil)
-	if err != nil {
-		return fmt.Errorf("failed to delete item: %v", err)
-	}
-
-	return nil
+	return w, nil
 }
 
 func (s *CosmosDBAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
-	// Extract sort direction from params
-	paramMap := s.extractParams(params...)
-	sortDirection := s.extractSortDirection(paramMap)
+	return s.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
 
-	return s.executePaginatedQuery(dest, sortKey, sortDirection, limit, cursor, filter, params...)
+func (s *CosmosDBAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		// Extract sort direction from params
+		paramMap := s.extractParams(params...)
+		sortDirection := s.extractSortDirection(paramMap)
+
+		condition, err := cosmosLiveItems(dest, paramMap, "")
+		if err != nil {
+			return "", err
+		}
+		return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, filter, condition, nil, params...)
+	})
 }
 
 func (s *CosmosDBAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
-	// Note: The Search method in CosmosDB is designed for full-text search scenarios
-	// For CosmosDB, full-text search requires Azure Cognitive Search integration
-	// This implementation treats Search as List with no filter
-	// For custom queries, use the Query method instead
+	return s.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
 
-	// Extract sort direction from params
-	paramMap := s.extractParams(params...)
-	sortDirection := s.extractSortDirection(paramMap)
+func (s *CosmosDBAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		// Extract sort direction from params
+		paramMap := s.extractParams(params...)
+		sortDirection := s.extractSortDirection(paramMap)
+
+		if query == "" {
+			condition, err := cosmosLiveItems(dest, paramMap, "")
+			if err != nil {
+				return "", err
+			}
+			return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, map[string]any{}, condition, nil, params...)
+		}
+
+		destType := reflect.TypeOf(dest).Elem().Elem()
+		model := reflect.New(destType).Elem().Interface()
+
+		parser, err := lucene.NewParserFromType(model)
+		if err != nil {
+			slog.Error("Parser creation failed", "error", err)
+			return "", err
+		}
+
+		condition, conditionParams, err := parser.ParseToCosmosSQL(query)
+		if err != nil {
+			slog.Error("Filter parsing failed", "error", err)
+			// Wrap InvalidFieldError as BadRequest for proper HTTP 400 response
+			if _, ok := err.(*lucene.InvalidFieldError); ok {
+				return "", &serviceErrors.BadRequest{Message: err.Error()}
+			}
+			return "", err
+		}
 
-	// Use executePaginatedQuery with empty filter (the query parameter is ignored for CosmosDB)
-	return s.executePaginatedQuery(dest, sortKey, sortDirection, limit, cursor, map[string]any{}, params...)
+		condition, err = cosmosLiveItems(dest, paramMap, condition)
+		if err != nil {
+			return "", err
+		}
+		return s.executePaginatedQuery(ctx, dest, sortKey, sortDirection, limit, cursor, map[string]any{}, condition, conditionParams, params...)
+	})
 }
 
 func (s *CosmosDBAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
-	// TODO Implement
-	var total int64
-	return total, nil
+	return s.CountContext(context.Background(), dest, filter, params...)
 }
 
-func (s *CosmosDBAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
-	// Note: For custom SQL queries, partition key parameters should be handled within the statement itself
-	// The params are available but not automatically applied to the query
-	// Users should include partition key conditions in their custom SQL statements when needed
+func (s *CosmosDBAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return retryResult(ctx, s.retrier, true, func() (int64, error) {
+		// Extract provider-specific parameters
+		paramMap := s.extractParams(params...)
 
-	containerName := s.getContainerName(dest)
-	containerClient, err := s.databaseClient.NewContainer(containerName)
-	if err != nil {
-		return "", fmt.Errorf("failed to create container client: %v", err)
-	}
+		containerName := s.getContainerName(dest)
+		containerClient, err := s.databaseClient.NewContainer(containerName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create container client: %v", err)
+		}
 
-	// Set up query options
-	enableCrossPartition := true
-	queryOptions := &azcosmos.QueryOptions{
-		EnableCrossPartitionQuery: &enableCrossPartition, // Enable cross-partition for custom queries
-		PageSizeHint:              int32(limit),
-	}
+		condition, err := cosmosLiveItems(dest, paramMap, "")
+		if err != nil {
+			return 0, err
+		}
+		whereClause, queryParams, err := s.buildWhereClause(dest, filter, paramMap, condition, nil)
+		if err != nil {
+			return 0, err
+		}
+		query := "SELECT VALUE COUNT(1) FROM c" + whereClause
 
-	// Handle cursor for pagination
-	if cursor != "" {
-		queryOptions.ContinuationToken = &cursor
-	}
+		pk, err := s.buildPartitionKey(dest, paramMap)
+		if err != nil {
+			return 0, fmt.Errorf("failed to build partition key: %v", err)
+		}
+		queryOptions := &azcosmos.QueryOptions{QueryParameters: queryParams}
+		partitionKey := azcosmos.NewPartitionKeyString(pk)
+		if pk == "" {
+			enableCrossPartition := true
+			queryOptions.EnableCrossPartitionQuery = &enableCrossPartition
+		}
 
-	// Execute the custom SQL statement
-	// Cross-partition is enabled by default for custom queries
-	pager := containerClient.NewQueryItemsPager(statement, azcosmos.NewPartitionKeyString(""), queryOptions)
+		// Cross-partition aggregates come back as partial counts spread over several pages, so all pages are summed
+		var total int64
+		pager := containerClient.NewQueryItemsPager(query, partitionKey, queryOptions)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return 0, fmt.Errorf("failed to execute query: %w", err)
+			}
+			for _, item := range page.Items {
+				var count int64
+				if err := json.Unmarshal(item, &count); err != nil {
+					return 0, fmt.Errorf("failed to unmarshal count: %v", err)
+				}
+				total += count
+			}
+		}
+		return total, nil
+	})
+}
 
-	// Get first page
-	page, err := pager.NextPage(context.Background())
-	if err != nil {
-		return "", fmt.Errorf("failed to execute query: %v", err)
-	}
+func (s *CosmosDBAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
 
-	// Process results
-	var results []json.RawMessage
-	for _, item := range page.Items {
-		results = append(results, json.RawMessage(item))
-	}
+func (s *CosmosDBAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		// Note: For custom SQL queries, partition key parameters should be handled within the statement itself
+		// The params are available but not automatically applied to the query
+		// Users should include partition key conditions in their custom SQL statements when needed
 
-	// Unmarshal results
-	if len(results) > 0 {
-		resultsJSON, err := json.Marshal(results)
+		containerName := s.getContainerName(dest)
+		containerClient, err := s.databaseClient.NewContainer(containerName)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal results: %v", err)
+			return "", fmt.Errorf("failed to create container client: %v", err)
 		}
-		err = json.Unmarshal(resultsJSON, dest)
+
+		// Set up query options
+		enableCrossPartition := true
+		queryOptions := &azcosmos.QueryOptions{
+			EnableCrossPartitionQuery: &enableCrossPartition, // Enable cross-partition for custom queries
+			PageSizeHint:              int32(limit),
+		}
+
+		// Handle cursor for pagination
+		if cursor != "" {
+			queryOptions.ContinuationToken = &cursor
+		}
+
+		// Execute the custom SQL statement
+		// Cross-partition is enabled by default for custom queries
+		pager := containerClient.NewQueryItemsPager(statement, azcosmos.NewPartitionKeyString(""), queryOptions)
+
+		// Get first page
+		page, err := pager.NextPage(ctx)
 		if err != nil {
-			return "", fmt.Errorf("failed to unmarshal results: %v", err)
+			return "", fmt.Errorf("failed to execute query: %w", err)
 		}
-	}
 
-	// Return continuation token for next page
-	nextCursor := ""
-	if page.ContinuationToken != nil {
-		nextCursor = *page.ContinuationToken
-	}
+		// Process results
+		var results []json.RawMessage
+		for _, item := range page.Items {
+			results = append(results, json.RawMessage(s.withETag(dest, item)))
+		}
 
-	return nextCursor, nil
+		// Unmarshal results
+		if len(results) > 0 {
+			resultsJSON, err := json.Marshal(results)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal results: %v", err)
+			}
+			err = json.Unmarshal(resultsJSON, dest)
+			if err != nil {
+				return "", fmt.Errorf("failed to unmarshal results: %v", err)
+			}
+		}
+
+		// Return continuation token for next page
+		nextCursor := ""
+		if page.ContinuationToken != nil {
+			nextCursor = *page.ContinuationToken
+		}
+
+		return nextCursor, nil
+	})
 }
 
 func (s *CosmosDBAdapter) executePaginatedQuery(
+	ctx context.Context,
 	dest any,
 	sortKey string,
 	sortDirection string,
 	limit int,
 	cursor string,
 	filter map[string]any,
+	condition string,
+	conditionParams []azcosmos.QueryParameter,
 	params ...map[string]any,
 ) (string, error) {
 	// Extract provider-specific parameters
@@ -534,47 +1131,14 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 
 	// Build base query
 	query := "SELECT * FROM c"
-	queryParams := []azcosmos.QueryParameter{}
-	paramIndex := 1
-
-	// Build WHERE conditions
-	conditions := []string{}
-
-	// Add filter conditions if provided
-	if len(filter) > 0 {
-		filterClause, filterParams := s.buildFilter(filter, &paramIndex)
-		if filterClause != "" {
-			conditions = append(conditions, filterClause)
-			queryParams = append(queryParams, filterParams...)
-		}
-	}
-
-	// Add partition key condition if provided in params
-	if pk, err := s.buildPartitionKey(paramMap); err != nil {
-		return "", fmt.Errorf("failed to build partition key: %v", err)
-	} else if pk != "" {
-		pkFieldName := s.getPartitionKeyFieldName(paramMap)
-		paramName := fmt.Sprintf("@param%d", paramIndex)
-		conditions = append(conditions, fmt.Sprintf("c.%s = %s", pkFieldName, paramName))
-		queryParams = append(queryParams, azcosmos.QueryParameter{
-			Name:  paramName,
-			Value: pk,
-		})
-		paramIndex++
-	}
-
-	// Add WHERE clause if we have conditions
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	whereClause, queryParams, err := s.buildWhereClause(dest, filter, paramMap, condition, conditionParams)
+	if err != nil {
+		return "", err
 	}
+	query += whereClause
 
 	// Add ordering - required for consistent pagination
-	if sortKey != "" {
-		query += fmt.Sprintf(" ORDER BY c.%s %s", sortKey, sortDirection)
-	} else {
-		// Use id as default sort key for consistent pagination
-		query += fmt.Sprintf(" ORDER BY c.id %s", sortDirection)
-	}
+	query += cosmosOrderBy(sortKey, sortDirection)
 
 	// Set up query options
 	queryOptions := &azcosmos.QueryOptions{
@@ -588,15 +1152,15 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	}
 
 	// Execute query
-	page, err := s.executeQuery(containerClient, query, paramMap, queryOptions)
+	page, err := s.executeQuery(ctx, containerClient, dest, query, paramMap, queryOptions)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute query: %v", err)
+		return "", fmt.Errorf("failed to execute query: %w", err)
 	}
 
 	// Process results
 	var results []json.RawMessage
 	for _, item := range page.Items {
-		results = append(results, json.RawMessage(item))
+		results = append(results, json.RawMessage(s.withETag(dest, item)))
 	}
 
 	// Unmarshal results
@@ -620,21 +1184,28 @@ func (s *CosmosDBAdapter) executePaginatedQuery(
 	return nextCursor, nil
 }
 
-func (s *CosmosDBAdapter) getContainerName(obj any) string {
-	// Get the type of obj
-	tableName := ""
-	tableName = reflect.TypeOf(obj).String()
-	tableName = tableName[strings.LastIndex(tableName, ".")+1:]
-
-	// Convert the table name to snake case
-	matchFirstCap := regexp.MustCompile("(.)([A-Z][a-z]+)")
-	matchAllCap := regexp.MustCompile("([a-z0-9])([A-Z])")
-	tableName = matchFirstCap.ReplaceAllString(tableName, "${1}_${2}")
-	tableName = matchAllCap.ReplaceAllString(tableName, "${1}_${2}")
+// cosmosOrderBy returns the ORDER BY clause of the sort spec sortKey, e.g. "-created_at,name" orders by
+// c.created_at DESC, c.name ASC. Fields without a - prefix are ordered in sortDirection and results are ordered by
+// id when sortKey is empty. Ordering by several fields requires a composite index on them
+func cosmosOrderBy(sortKey string, sortDirection string) string {
+	fields := parseSortSpec(sortKey)
+	if len(fields) == 0 {
+		fields = []sortField{{Name: "id"}}
+	}
+	terms := make([]string, len(fields))
+	for i, f := range fields {
+		direction := sortDirection
+		if f.Desc {
+			direction = "DESC"
+		}
+		terms[i] = fmt.Sprintf("c.%s %s", f.Name, direction)
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
 
-	tableName = strings.ToLower(tableName)
-	tableName += "s"
-	return tableName
+// getContainerName returns the container of obj, see modelTableName
+func (s *CosmosDBAdapter) getContainerName(obj any) string {
+	return modelTableName(obj, s.config["container_prefix"], s.config["container_suffix"])
 }
 
 func (s *CosmosDBAdapter) itemToMap(item any) map[string]interface{} {
@@ -649,9 +1220,9 @@ func (s *CosmosDBAdapter) itemToMap(item any) map[string]interface{} {
 	return itemMap
 }
 
-// buildPartitionKey constructs a partition key from parameters
-// Only supports explicit pk_field and pk_value parameters
-func (s *CosmosDBAdapter) buildPartitionKey(paramMap map[string]any) (string, error) {
+// buildPartitionKey constructs a partition key from the pk_value parameter, which requires either the pk_field
+// parameter or a field of model tagged magic:"pk"
+func (s *CosmosDBAdapter) buildPartitionKey(model any, paramMap map[string]any) (string, error) {
 	// Check for pk_field and pk_value parameters
 	if fieldName, exists := paramMap["pk_field"]; exists {
 		if fieldStr, ok := fieldName.(string); ok && fieldStr != "" {
@@ -662,12 +1233,21 @@ func (s *CosmosDBAdapter) buildPartitionKey(paramMap map[string]any) (string, er
 		}
 		return "", fmt.Errorf("pk_field must be a non-empty string")
 	}
+	if f := getModelMetadata(model).field("pk"); f != nil {
+		if value, exists := paramMap["pk_value"]; exists {
+			return fmt.Sprintf("%v", value), nil
+		}
+	}
 
 	return "", nil // No partition key specified
 }
 
-// getPartitionKeyFieldName gets the partition key field name from params, defaulting to "pk"
-func (s *CosmosDBAdapter) getPartitionKeyFieldName(paramMap map[string]any) string {
+// getPartitionKeyFieldName gets the partition key field name of model: the field tagged magic:"pk", which is the
+// partition key path EnsureContainer uses, then the pk_field param and "pk" by default
+func (s *CosmosDBAdapter) getPartitionKeyFieldName(model any, paramMap map[string]any) string {
+	if f := getModelMetadata(model).field("pk"); f != nil {
+		return f.Key
+	}
 	if fieldName, exists := paramMap["pk_field"]; exists {
 		if fieldStr, ok := fieldName.(string); ok && fieldStr != "" {
 			return fieldStr
@@ -677,14 +1257,63 @@ func (s *CosmosDBAdapter) getPartitionKeyFieldName(paramMap map[string]any) stri
 }
 
 // executeQuery executes a query and handles single-partition vs cross-partition logic
+// buildWhereClause returns the WHERE clause matching filter, the partition key given in paramMap and an optional
+// additional condition (such as a rendered search query), or "" if there are no conditions
+func (s *CosmosDBAdapter) buildWhereClause(
+	model any,
+	filter map[string]any,
+	paramMap map[string]any,
+	condition string,
+	conditionParams []azcosmos.QueryParameter,
+) (string, []azcosmos.QueryParameter, error) {
+	queryParams := []azcosmos.QueryParameter{}
+	paramIndex := 1
+
+	// Build WHERE conditions
+	conditions := []string{}
+	if condition != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s)", condition))
+		queryParams = append(queryParams, conditionParams...)
+	}
+
+	// Add filter conditions if provided
+	if len(filter) > 0 {
+		filterClause, filterParams := s.buildFilter(filter, &paramIndex)
+		if filterClause != "" {
+			conditions = append(conditions, filterClause)
+			queryParams = append(queryParams, filterParams...)
+		}
+	}
+
+	// Add partition key condition if provided in params
+	if pk, err := s.buildPartitionKey(model, paramMap); err != nil {
+		return "", nil, fmt.Errorf("failed to build partition key: %v", err)
+	} else if pk != "" {
+		pkFieldName := s.getPartitionKeyFieldName(model, paramMap)
+		paramName := fmt.Sprintf("@param%d", paramIndex)
+		conditions = append(conditions, fmt.Sprintf("c.%s = %s", pkFieldName, paramName))
+		queryParams = append(queryParams, azcosmos.QueryParameter{
+			Name:  paramName,
+			Value: pk,
+		})
+	}
+
+	if len(conditions) == 0 {
+		return "", queryParams, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), queryParams, nil
+}
+
 func (s *CosmosDBAdapter) executeQuery(
+	ctx context.Context,
 	containerClient *azcosmos.ContainerClient,
+	model any,
 	query string,
 	paramMap map[string]any,
 	queryOptions *azcosmos.QueryOptions,
 ) (azcosmos.QueryItemsResponse, error) {
 	// Determine if we need cross-partition query
-	pk, err := s.buildPartitionKey(paramMap)
+	pk, err := s.buildPartitionKey(model, paramMap)
 	if err != nil {
 		return azcosmos.QueryItemsResponse{}, fmt.Errorf("failed to build partition key: %v", err)
 	}
@@ -694,13 +1323,13 @@ func (s *CosmosDBAdapter) executeQuery(
 	if pk != "" {
 		// Single partition query - use the partition key
 		pager := containerClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(pk), queryOptions)
-		page, err = pager.NextPage(context.Background())
+		page, err = pager.NextPage(ctx)
 	} else {
 		// Cross-partition query
 		enableCrossPartition := true
 		queryOptions.EnableCrossPartitionQuery = &enableCrossPartition
 		pager := containerClient.NewQueryItemsPager(query, azcosmos.NewPartitionKeyString(""), queryOptions)
-		page, err = pager.NextPage(context.Background())
+		page, err = pager.NextPage(ctx)
 	}
 
 	return page, err
@@ -765,3 +1394,310 @@ func (s *CosmosDBAdapter) buildFilter(filter map[string]any, paramIndex *int) (s
 
 	return strings.Join(conditions, " AND "), queryParams
 }
+
+const COSMOSDB_MAX_TRANSACTION_ITEMS = 100
+
+// WithTransaction buffers every Create, Update and Delete performed through tx into a transactional
+// batch which is executed once fn returns. Cosmos DB only supports transactions within a single
+// container and partition key, so all writes in the transaction must target the same ones. Reads made
+// through tx are not part of the transaction and don't observe its pending writes
+func (s *CosmosDBAdapter) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return s.retrier.do(ctx, false, func() error {
+		tx := &cosmosDBTransaction{CosmosDBAdapter: s}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if tx.operations == 0 {
+			return nil
+		}
+
+		response, err := tx.container.ExecuteTransactionalBatch(ctx, tx.batch, nil)
+		if err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		if !response.Success {
+			// Operations that didn't fail themselves report 424 (failed dependency), find the one that did
+			for i, result := range response.OperationResults {
+				if result.StatusCode == http.StatusPreconditionFailed {
+					return fmt.Errorf("failed to commit transaction: operation %d failed: %w", i, ErrConflict)
+				}
+				if result.StatusCode == http.StatusFailedDependency {
+					continue
+				}
+				if kind, _ := cosmosStatusError(int(result.StatusCode)); kind != nil {
+					return fmt.Errorf("failed to commit transaction: operation %d failed with status code %d: %w", i, result.StatusCode, kind)
+				}
+				return fmt.Errorf("failed to commit transaction: operation %d failed with status code %d", i, result.StatusCode)
+			}
+			return fmt.Errorf("failed to commit transaction")
+		}
+		return nil
+	})
+}
+
+// cosmosDBTransaction is the StorageAdapter handed to WithTransaction callbacks
+type cosmosDBTransaction struct {
+	*CosmosDBAdapter
+	container     *azcosmos.ContainerClient
+	containerName string
+	partitionKey  string
+	batch         azcosmos.TransactionalBatch
+	operations    int
+}
+
+// scope binds the transaction to the container and partition key of its first write and verifies
+// that later writes target the same ones
+func (t *cosmosDBTransaction) scope(w cosmosWrite) error {
+	if t.operations >= COSMOSDB_MAX_TRANSACTION_ITEMS {
+		return fmt.Errorf("a cosmosdb transaction can't contain more than %d operations", COSMOSDB_MAX_TRANSACTION_ITEMS)
+	}
+	if t.operations == 0 {
+		t.container = w.container
+		t.containerName = w.containerName
+		t.partitionKey = w.partitionKey
+		t.batch = w.container.NewTransactionalBatch(azcosmos.NewPartitionKeyString(w.partitionKey))
+	} else if w.containerName != t.containerName || w.partitionKey != t.partitionKey {
+		return fmt.Errorf(
+			"a cosmosdb transaction is limited to a single container and partition key, got %s/%s but the transaction is bound to %s/%s",
+			w.containerName, w.partitionKey, t.containerName, t.partitionKey,
+		)
+	}
+	t.operations++
+	return nil
+}
+
+// WithTransaction on an open transaction simply joins it
+func (t *cosmosDBTransaction) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return fn(t)
+}
+
+func (t *cosmosDBTransaction) Create(item any, params ...map[string]any) error {
+	return t.CreateContext(context.Background(), item, params...)
+}
+
+func (t *cosmosDBTransaction) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	w, err := t.prepareCreate(ctx, item, t.extractParams(params...))
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.CreateItem(w.item, nil)
+	return nil
+}
+
+func (t *cosmosDBTransaction) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return t.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (t *cosmosDBTransaction) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	w, err := t.prepareUpdate(ctx, item, filter, params...)
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.ReplaceItem(w.id, w.item, &azcosmos.TransactionalBatchItemOptions{IfMatchETag: w.etag})
+	return nil
+}
+
+func (t *cosmosDBTransaction) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return t.DeleteContext(context.Background(), item, filter, params...)
+}
+
+// DeleteContext soft deletes documents of models tagged with deleted_at, which fails the transaction if the
+// document is already deleted
+func (t *cosmosDBTransaction) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := softDeleteField(item)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return t.Purge(ctx, item, filter, params...)
+	}
+	w, err := t.prepareDelete(item, filter, t.extractParams(params...))
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.PatchItem(w.id, cosmosSoftDeletePatch(f, time.Now().UTC()), nil)
+	return nil
+}
+
+func (t *cosmosDBTransaction) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := requireSoftDeleteField(item)
+	if err != nil {
+		return err
+	}
+	w, err := t.prepareDelete(item, filter, t.extractParams(params...))
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.PatchItem(w.id, cosmosRestorePatch(f), nil)
+	return nil
+}
+
+// Patch adds the patch of the document to the transaction, item isn't filled with the patched document
+func (t *cosmosDBTransaction) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	changes, _, err := preparePatch(item, changes, jsonFieldName)
+	if err != nil {
+		return err
+	}
+	paramMap := t.extractParams(params...)
+	w, err := t.prepareDelete(item, filter, paramMap)
+	if err != nil {
+		return err
+	}
+	patch, err := cosmosPatch(item, changes, paramMap)
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.PatchItem(w.id, patch, nil)
+	return nil
+}
+
+func (t *cosmosDBTransaction) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	w, err := t.prepareDelete(item, filter, t.extractParams(params...))
+	if err != nil {
+		return err
+	}
+	if err := t.scope(w); err != nil {
+		return err
+	}
+	t.batch.DeleteItem(w.id, nil)
+	return nil
+}
+
+// BatchCreate adds the creation of each of items to the transaction, see CreateContext
+func (t *cosmosDBTransaction) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return eachBatchItem(items, func(item any) error {
+		return t.CreateContext(ctx, item, params...)
+	})
+}
+
+// BatchUpsert adds the upsert of each of items to the transaction
+func (t *cosmosDBTransaction) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	paramMap := t.extractParams(params...)
+	return eachBatchItem(items, func(item any) error {
+		w, err := t.prepareCreate(ctx, item, paramMap)
+		if err != nil {
+			return err
+		}
+		if err := t.scope(w); err != nil {
+			return err
+		}
+		t.batch.UpsertItem(w.item, nil)
+		return nil
+	})
+}
+
+// BatchDelete adds the deletion of the documents matching each of filters to the transaction, see DeleteContext
+func (t *cosmosDBTransaction) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	results := make([]BatchResult, len(filters))
+	for i, filter := range filters {
+		results[i] = BatchResult{Index: i, Err: t.DeleteContext(ctx, item, filter, params...)}
+	}
+	return results, nil
+}
+
+const COSMOSDB_BATCH_CONCURRENCY = 10
+
+// BatchCreate creates items, running up to COSMOSDB_BATCH_CONCURRENCY requests in parallel
+func (s *CosmosDBAdapter) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	paramMap := s.extractParams(params...)
+	return s.executeBatch(ctx, items, func(item any) error {
+		w, err := s.prepareCreate(ctx, item, paramMap)
+		if err != nil {
+			return err
+		}
+		return s.retrier.do(ctx, false, func() error {
+			if _, err := w.container.CreateItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.item, nil); err != nil {
+				return fmt.Errorf("failed to create item: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// BatchUpsert creates or replaces items, running up to COSMOSDB_BATCH_CONCURRENCY requests in parallel
+func (s *CosmosDBAdapter) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	paramMap := s.extractParams(params...)
+	return s.executeBatch(ctx, items, func(item any) error {
+		w, err := s.prepareCreate(ctx, item, paramMap)
+		if err != nil {
+			return err
+		}
+		return s.retrier.do(ctx, true, func() error {
+			if _, err := w.container.UpsertItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.item, nil); err != nil {
+				return fmt.Errorf("failed to upsert item: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// BatchDelete deletes the items matching each of filters, running up to COSMOSDB_BATCH_CONCURRENCY requests in parallel
+func (s *CosmosDBAdapter) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	paramMap := s.extractParams(params...)
+	f, err := softDeleteField(item)
+	if err != nil {
+		return nil, err
+	}
+	return s.executeBatch(ctx, filters, func(filter any) error {
+		w, err := s.prepareDelete(item, *filter.(*map[string]any), paramMap)
+		if err != nil {
+			return err
+		}
+		return s.retrier.do(ctx, true, func() error {
+			if f != nil {
+				_, err := s.softDelete(ctx, w, f, time.Now().UTC())
+				return err
+			}
+			if _, err := w.container.DeleteItem(ctx, azcosmos.NewPartitionKeyString(w.partitionKey), w.id, nil); err != nil {
+				return fmt.Errorf("failed to delete item: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// executeBatch calls fn for every element of the slice items using a pool of COSMOSDB_BATCH_CONCURRENCY workers
+func (s *CosmosDBAdapter) executeBatch(ctx context.Context, items any, fn func(item any) error) ([]BatchResult, error) {
+	elements, err := batchItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(elements))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < min(COSMOSDB_BATCH_CONCURRENCY, len(elements)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = BatchResult{Index: i, Err: fn(elements[i])}
+			}
+		}()
+	}
+	for i := range elements {
+		if ctx.Err() != nil {
+			results[i] = BatchResult{Index: i, Err: ctx.Err()}
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, nil
+}
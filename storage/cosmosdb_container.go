@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+// COSMOSDB_DEFAULT_PARTITION_KEY_PATH is the partition key path of containers whose model has no field tagged
+// magic:"pk". It matches the pk property the adapter writes when no pk_field param is given
+const COSMOSDB_DEFAULT_PARTITION_KEY_PATH = "/pk"
+
+// CosmosDBContainerOptions controls how EnsureContainer provisions a container
+type CosmosDBContainerOptions struct {
+	// Throughput is the manual throughput of the container in RU/s. Containers with neither Throughput nor
+	// AutoscaleMaxThroughput share the throughput of their database, serverless accounts accept neither
+	Throughput int32
+	// AutoscaleMaxThroughput is the maximum throughput in RU/s of an autoscale container, it takes precedence over
+	// Throughput
+	AutoscaleMaxThroughput int32
+	// PartitionKeyPath overrides the partition key path taken from the magic tags, e.g. "/tenant"
+	PartitionKeyPath string
+	// IndexingPolicy replaces the indexing policy built from the magic tags
+	IndexingPolicy *azcosmos.IndexingPolicy
+	// EnableTTL turns on time to live so items with a magic:"expires_at" field are deleted once they expire
+	EnableTTL bool
+}
+
+// cosmosDBContainerSchema is the partition key, unique keys and indexing policy of a model's container, as declared
+// by its magic tags
+type cosmosDBContainerSchema struct {
+	PartitionKeyPath string
+	UniqueKeys       []azcosmos.UniqueKey
+	IndexingPolicy   *azcosmos.IndexingPolicy
+}
+
+// getCosmosDBContainerSchema reads the container schema of model from its magic tags:
+//
+//	type Task struct {
+//	    ID      string         `json:"id"`
+//	    Tenant  string         `json:"tenant" magic:"pk"`
+//	    Email   string         `json:"email" magic:"unique"`
+//	    Owner   string         `json:"owner" magic:"unique=owner_name"`
+//	    Name    string         `json:"name" magic:"unique=owner_name"`
+//	    Payload map[string]any `json:"payload" magic:"noindex"`
+//	}
+//
+// pk is the partition key, unique fields are unique within a partition on their own and fields sharing a unique=name
+// option are unique together. noindex fields are left out of the index, every other path is indexed
+func getCosmosDBContainerSchema(model any) (*cosmosDBContainerSchema, error) {
+	schema := &cosmosDBContainerSchema{
+		PartitionKeyPath: COSMOSDB_DEFAULT_PARTITION_KEY_PATH,
+		IndexingPolicy: &azcosmos.IndexingPolicy{
+			Automatic:     true,
+			IndexingMode:  azcosmos.IndexingModeConsistent,
+			IncludedPaths: []azcosmos.IncludedPath{{Path: "/*"}},
+		},
+	}
+	partitionKey := ""
+	uniqueKeys := map[string][]string{}
+	for _, f := range getModelMetadata(model).Fields {
+		if f.has("pk") {
+			if partitionKey != "" {
+				return nil, fmt.Errorf("%T has more than one partition key, %s and %s", model, partitionKey, f.Key)
+			}
+			if f.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("partition key field %s must be a string, got %s", f.Name, f.Type)
+			}
+			partitionKey = f.Key
+			schema.PartitionKeyPath = "/" + f.Key
+		}
+		for _, name := range f.Options["unique"] {
+			if name == "" {
+				name = f.Key
+			}
+			uniqueKeys[name] = append(uniqueKeys[name], "/"+f.Key)
+		}
+		if f.has("noindex") {
+			schema.IndexingPolicy.ExcludedPaths = append(schema.IndexingPolicy.ExcludedPaths, azcosmos.ExcludedPath{Path: cosmosIndexPath(f)})
+		}
+	}
+
+	names := make([]string, 0, len(uniqueKeys))
+	for name := range uniqueKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema.UniqueKeys = append(schema.UniqueKeys, azcosmos.UniqueKey{Paths: uniqueKeys[name]})
+	}
+	return schema, nil
+}
+
+// cosmosIndexPath returns the index path of the property of f, /key/? for scalars and /key/* for objects and arrays
+func cosmosIndexPath(f *modelField) string {
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		if t != reflect.TypeOf(time.Time{}) {
+			return "/" + f.Key + "/*"
+		}
+	}
+	return "/" + f.Key + "/?"
+}
+
+// EnsureContainer creates the container of model, and its database if needed, with the partition key, unique keys
+// and indexing policy declared by its magic tags, see getCosmosDBContainerSchema. Existing containers get the
+// indexing paths and time to live they are missing, their partition key and unique keys can't be changed and must
+// match. Their throughput is left as it is
+func (s *CosmosDBAdapter) EnsureContainer(ctx context.Context, model any, opts CosmosDBContainerOptions) error {
+	schema, err := getCosmosDBContainerSchema(model)
+	if err != nil {
+		return err
+	}
+	if opts.PartitionKeyPath != "" {
+		schema.PartitionKeyPath = opts.PartitionKeyPath
+	}
+	if opts.IndexingPolicy != nil {
+		schema.IndexingPolicy = opts.IndexingPolicy
+	}
+	if opts.EnableTTL {
+		if _, err := requireExpiryField(model); err != nil {
+			return err
+		}
+	}
+
+	if err := s.ensureDatabase(ctx); err != nil {
+		return err
+	}
+	containerName := s.getContainerName(model)
+	container, err := s.databaseClient.NewContainer(containerName)
+	if err != nil {
+		return fmt.Errorf("failed to create container client: %v", err)
+	}
+	response, err := container.Read(ctx, nil)
+	if cosmosStatusCode(err) == http.StatusNotFound {
+		return s.createContainer(ctx, containerName, schema, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read container %s: %w", containerName, err)
+	}
+	return s.updateContainer(ctx, container, response.ContainerProperties, schema, opts)
+}
+
+// ensureDatabase creates the database of the adapter if it doesn't exist
+func (s *CosmosDBAdapter) ensureDatabase(ctx context.Context) error {
+	_, err := s.databaseClient.Read(ctx, nil)
+	if cosmosStatusCode(err) != http.StatusNotFound {
+		if err != nil {
+			return fmt.Errorf("failed to read database %s: %w", s.databaseClient.ID(), err)
+		}
+		return nil
+	}
+	_, err = s.client.CreateDatabase(ctx, azcosmos.DatabaseProperties{ID: s.databaseClient.ID()}, nil)
+	if err != nil && cosmosStatusCode(err) != http.StatusConflict {
+		return fmt.Errorf("failed to create database %s: %w", s.databaseClient.ID(), err)
+	}
+	return nil
+}
+
+func (s *CosmosDBAdapter) createContainer(ctx context.Context, containerName string, schema *cosmosDBContainerSchema, opts CosmosDBContainerOptions) error {
+	properties := azcosmos.ContainerProperties{
+		ID: containerName,
+		PartitionKeyDefinition: azcosmos.PartitionKeyDefinition{
+			Kind:  azcosmos.PartitionKeyKindHash,
+			Paths: []string{schema.PartitionKeyPath},
+		},
+		IndexingPolicy: schema.IndexingPolicy,
+	}
+	if len(schema.UniqueKeys) > 0 {
+		properties.UniqueKeyPolicy = &azcosmos.UniqueKeyPolicy{UniqueKeys: schema.UniqueKeys}
+	}
+	if opts.EnableTTL {
+		properties.DefaultTimeToLive = cosmosTTLEnabled()
+	}
+
+	createOptions := &azcosmos.CreateContainerOptions{}
+	if opts.AutoscaleMaxThroughput > 0 {
+		throughput := azcosmos.NewAutoscaleThroughputProperties(opts.AutoscaleMaxThroughput)
+		createOptions.ThroughputProperties = &throughput
+	} else if opts.Throughput > 0 {
+		throughput := azcosmos.NewManualThroughputProperties(opts.Throughput)
+		createOptions.ThroughputProperties = &throughput
+	}
+
+	_, err := s.databaseClient.CreateContainer(ctx, properties, createOptions)
+	if err != nil && cosmosStatusCode(err) != http.StatusConflict {
+		return fmt.Errorf("failed to create container %s: %w", containerName, err)
+	}
+	return nil
+}
+
+func (s *CosmosDBAdapter) updateContainer(
+	ctx context.Context,
+	container *azcosmos.ContainerClient,
+	properties *azcosmos.ContainerProperties,
+	schema *cosmosDBContainerSchema,
+	opts CosmosDBContainerOptions,
+) error {
+	if !slices.Equal(properties.PartitionKeyDefinition.Paths, []string{schema.PartitionKeyPath}) {
+		return fmt.Errorf(
+			"the partition key of container %s is %v instead of %s, it can't be changed once a container is created",
+			properties.ID, properties.PartitionKeyDefinition.Paths, schema.PartitionKeyPath,
+		)
+	}
+	existingUniqueKeys := []azcosmos.UniqueKey{}
+	if properties.UniqueKeyPolicy != nil {
+		existingUniqueKeys = properties.UniqueKeyPolicy.UniqueKeys
+	}
+	if !sameUniqueKeys(existingUniqueKeys, schema.UniqueKeys) {
+		return fmt.Errorf("the unique keys of container %s don't match its model, they can't be changed once a container is created", properties.ID)
+	}
+
+	changed := false
+	if opts.IndexingPolicy != nil {
+		// Policies given explicitly replace the existing one
+		if properties.IndexingPolicy == nil || !reflect.DeepEqual(*properties.IndexingPolicy, *opts.IndexingPolicy) {
+			properties.IndexingPolicy = opts.IndexingPolicy
+			changed = true
+		}
+	} else if properties.IndexingPolicy != nil {
+		for _, path := range schema.IndexingPolicy.ExcludedPaths {
+			if !slices.Contains(properties.IndexingPolicy.ExcludedPaths, path) {
+				properties.IndexingPolicy.ExcludedPaths = append(properties.IndexingPolicy.ExcludedPaths, path)
+				changed = true
+			}
+		}
+	}
+	if opts.EnableTTL && properties.DefaultTimeToLive == nil {
+		properties.DefaultTimeToLive = cosmosTTLEnabled()
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := container.Replace(ctx, *properties, nil); err != nil {
+		return fmt.Errorf("failed to update container %s: %w", properties.ID, err)
+	}
+	return nil
+}
+
+// cosmosTTLEnabled returns the default time to live turning on time to live without expiring the items that have no
+// ttl property
+func cosmosTTLEnabled() *int32 {
+	ttl := int32(-1)
+	return &ttl
+}
+
+// sameUniqueKeys reports whether a and b hold the same unique keys, in any order
+func sameUniqueKeys(a []azcosmos.UniqueKey, b []azcosmos.UniqueKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	normalize := func(keys []azcosmos.UniqueKey) []string {
+		normalized := make([]string, len(keys))
+		for i, key := range keys {
+			paths := slices.Clone(key.Paths)
+			sort.Strings(paths)
+			normalized[i] = fmt.Sprint(paths)
+		}
+		sort.Strings(normalized)
+		return normalized
+	}
+	return slices.Equal(normalize(a), normalize(b))
+}
+
+// autoProvision runs EnsureContainer for the container of model the first time the adapter writes to it when the
+// auto_provision config is true. Time to live is turned on for models with a magic:"expires_at" field
+func (s *CosmosDBAdapter) autoProvision(ctx context.Context, model any) error {
+	if enabled, _ := strconv.ParseBool(s.config["auto_provision"]); !enabled {
+		return nil
+	}
+	containerName := s.getContainerName(model)
+	if _, ok := s.provisioned.Load(containerName); ok {
+		return nil
+	}
+	expiry, err := expiryField(model)
+	if err != nil {
+		return err
+	}
+	if err := s.EnsureContainer(ctx, model, CosmosDBContainerOptions{EnableTTL: expiry != nil}); err != nil {
+		return err
+	}
+	s.provisioned.Store(containerName, true)
+	return nil
+}
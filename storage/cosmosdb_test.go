@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type cosmosTenantTask struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant" magic:"pk"`
+	Name   string `json:"name"`
+}
+
+type cosmosTask struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// newTestCosmosDBAdapter returns an adapter whose requests go nowhere, for the operations that build requests
+// without sending them
+func newTestCosmosDBAdapter(t *testing.T) *CosmosDBAdapter {
+	t.Helper()
+	s := &CosmosDBAdapter{config: map[string]string{
+		"endpoint": "https://localhost:8081/",
+		"key":      "dGVzdA==",
+		"database": "magic",
+	}}
+	if err := s.Connect(); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	return s
+}
+
+// newFakeCosmosDBAdapter returns an adapter talking to a fake account that answers the requests made on documents
+// with the status code and body respond returns for their method
+func newFakeCosmosDBAdapter(t *testing.T, respond func(method string) (int, string)) *CosmosDBAdapter {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" {
+			endpoint := `{"name": "local", "databaseAccountEndpoint": "https://` + r.Host + `/"}`
+			_, _ = io.WriteString(w, `{"id": "magic", "writableLocations": [`+endpoint+`], "readableLocations": [`+endpoint+`]}`)
+			return
+		}
+		status, body := respond(r.Method)
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	s := &CosmosDBAdapter{config: map[string]string{
+		"endpoint":        server.URL + "/",
+		"key":             "dGVzdA==",
+		"database":        "magic",
+		"skip_tls_verify": "true",
+	}}
+	if err := s.Connect(); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	return s
+}
+
+func TestCosmosDBPartitionKey(t *testing.T) {
+	s := newTestCosmosDBAdapter(t)
+
+	tests := []struct {
+		name         string
+		item         any
+		params       map[string]any
+		filter       map[string]any
+		wantField    string
+		wantCreatePK string
+		wantDeletePK string
+	}{
+		{
+			name:         "tagged field",
+			item:         &cosmosTenantTask{ID: "1", Tenant: "acme"},
+			params:       map[string]any{},
+			filter:       map[string]any{"id": "1", "tenant": "acme"},
+			wantField:    "tenant",
+			wantCreatePK: "acme",
+			wantDeletePK: "acme",
+		},
+		{
+			name:         "tagged field with pk_value",
+			item:         &cosmosTenantTask{ID: "1"},
+			params:       map[string]any{"pk_value": "acme"},
+			filter:       map[string]any{"id": "1"},
+			wantField:    "tenant",
+			wantCreatePK: "acme",
+			wantDeletePK: "acme",
+		},
+		{
+			name:         "untagged non-string id",
+			item:         &cosmosTask{ID: 7},
+			params:       map[string]any{},
+			filter:       map[string]any{"id": 7},
+			wantField:    "pk",
+			wantCreatePK: "7",
+			wantDeletePK: "7",
+		},
+		{
+			name:         "pk_field param",
+			item:         &cosmosTask{ID: 7},
+			params:       map[string]any{"pk_field": "owner", "pk_value": "bob"},
+			filter:       map[string]any{"id": 7},
+			wantField:    "owner",
+			wantCreatePK: "bob",
+			wantDeletePK: "bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if field := s.getPartitionKeyFieldName(tt.item, tt.params); field != tt.wantField {
+				t.Errorf("getPartitionKeyFieldName() = %q, want %q", field, tt.wantField)
+			}
+
+			w, err := s.prepareCreate(t.Context(), tt.item, tt.params)
+			if err != nil {
+				t.Fatalf("prepareCreate() error: %v", err)
+			}
+			if w.partitionKey != tt.wantCreatePK {
+				t.Errorf("prepareCreate() partition key = %q, want %q", w.partitionKey, tt.wantCreatePK)
+			}
+			document := map[string]any{}
+			if err := json.Unmarshal(w.item, &document); err != nil {
+				t.Fatalf("invalid document: %v", err)
+			}
+			if value := document[tt.wantField]; value != tt.wantCreatePK {
+				t.Errorf("document %s = %v, want the partition key %q", tt.wantField, value, tt.wantCreatePK)
+			}
+
+			w, err = s.prepareDelete(tt.item, tt.filter, tt.params)
+			if err != nil {
+				t.Fatalf("prepareDelete() error: %v", err)
+			}
+			if w.partitionKey != tt.wantDeletePK {
+				t.Errorf("prepareDelete() partition key = %q, want %q", w.partitionKey, tt.wantDeletePK)
+			}
+		})
+	}
+}
+
+func TestCosmosOrderBy(t *testing.T) {
+	tests := []struct {
+		sortKey   string
+		direction string
+		want      string
+	}{
+		{sortKey: "", direction: "ASC", want: " ORDER BY c.id ASC"},
+		{sortKey: "name", direction: "DESC", want: " ORDER BY c.name DESC"},
+		{sortKey: "-created_at", direction: "ASC", want: " ORDER BY c.created_at DESC"},
+		{sortKey: "name,-rank", direction: "ASC", want: " ORDER BY c.name ASC, c.rank DESC"},
+		{sortKey: "+name, -rank", direction: "ASC", want: " ORDER BY c.name ASC, c.rank DESC"},
+	}
+	for _, tt := range tests {
+		if got := cosmosOrderBy(tt.sortKey, tt.direction); got != tt.want {
+			t.Errorf("cosmosOrderBy(%q, %q) = %q, want %q", tt.sortKey, tt.direction, got, tt.want)
+		}
+	}
+}
+
+type cosmosExpiringTask struct {
+	ID        string     `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at" magic:"expires_at"`
+}
+
+func TestCosmosPatchSetsTTL(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		changes map[string]any
+		wantTTL func(ttl float64) bool
+	}{
+		{name: "expiry by json name", changes: map[string]any{"expires_at": expiresAt}, wantTTL: func(ttl float64) bool { return ttl > 3500 && ttl <= 3600 }},
+		{name: "expiry by Go name", changes: map[string]any{"ExpiresAt": &expiresAt}, wantTTL: func(ttl float64) bool { return ttl > 3500 && ttl <= 3600 }},
+		{name: "expiry cleared", changes: map[string]any{"expires_at": nil}, wantTTL: func(ttl float64) bool { return ttl == -1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, _, err := preparePatch(&cosmosExpiringTask{}, tt.changes, jsonFieldName)
+			if err != nil {
+				t.Fatalf("preparePatch() error: %v", err)
+			}
+			patch, err := cosmosPatch(&cosmosExpiringTask{}, changes, map[string]any{})
+			if err != nil {
+				t.Fatalf("cosmosPatch() error: %v", err)
+			}
+			body, err := json.Marshal(patch)
+			if err != nil {
+				t.Fatalf("the patch isn't valid JSON: %v", err)
+			}
+			var decoded struct {
+				Operations []struct {
+					Op    string `json:"op"`
+					Path  string `json:"path"`
+					Value any    `json:"value"`
+				} `json:"operations"`
+			}
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				t.Fatalf("Unmarshal() error: %v", err)
+			}
+			paths := map[string]any{}
+			for _, op := range decoded.Operations {
+				paths[op.Path] = op.Value
+			}
+			ttl, _ := paths["/ttl"].(float64)
+			if _, ok := paths["/expires_at"]; !ok || !tt.wantTTL(ttl) {
+				t.Errorf("patch = %s, want /expires_at and /ttl set", body)
+			}
+		})
+	}
+}
+
+type cosmosSoftDeleteTask struct {
+	ID        string     `json:"id"`
+	DeletedAt *time.Time `json:"deleted_at" magic:"deleted_at"`
+}
+
+func TestCosmosDBSoftDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantDeleted bool
+	}{
+		{name: "live document", status: http.StatusOK, body: `{"id": "1", "deleted_at": "2026-01-01T00:00:00Z"}`, wantDeleted: true},
+		{name: "missing document", status: http.StatusNotFound, body: `{"code": "NotFound", "message": "Entity with the specified id does not exist in the system."}`},
+		{name: "document already deleted", status: http.StatusPreconditionFailed, body: `{"code": "PreconditionFailed", "message": "One of the specified pre-condition is not met."}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := []string{}
+			s := newFakeCosmosDBAdapter(t, func(method string) (int, string) {
+				methods = append(methods, method)
+				return tt.status, tt.body
+			})
+
+			task := cosmosSoftDeleteTask{ID: "1"}
+			if err := s.DeleteContext(context.Background(), &task, map[string]any{"id": "1"}); err != nil {
+				t.Fatalf("DeleteContext() error: %v", err)
+			}
+			if len(methods) != 1 || methods[0] != http.MethodPatch {
+				t.Errorf("sent %v, want a single patch", methods)
+			}
+			if deleted := task.DeletedAt != nil; deleted != tt.wantDeleted {
+				t.Errorf("DeletedAt = %v, want it set only when the document was deleted", task.DeletedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+// sortField is a single column of a sort spec
+type sortField struct {
+	Name string
+	Desc bool
+}
+
+// parseSortSpec parses a comma separated sort spec such as "-created_at,id", where a leading - sorts
+// the column in descending order and a leading + (or nothing) in ascending order
+func parseSortSpec(spec string) []sortField {
+	fields := []sortField{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		part = strings.TrimLeft(part, "+-")
+		if part != "" {
+			fields = append(fields, sortField{Name: part, Desc: desc})
+		}
+	}
+	return fields
+}
+
+// cursorToken is the decoded form of the opaque keyset cursors returned by paginated queries. It holds the
+// sort spec the cursor was created for and the sort values of the last item of the page
+type cursorToken struct {
+	Sort   string        `json:"s"`
+	Values []cursorValue `json:"v"`
+}
+
+// cursorValue is a sort value tagged with its type so it is decoded back into the same Go type
+type cursorValue struct {
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor holding the sort values of the last item of a page
+func encodeCursor(sort string, values []any) (string, error) {
+	token := cursorToken{Sort: sort, Values: make([]cursorValue, len(values))}
+	for i, value := range values {
+		v, err := newCursorValue(value)
+		if err != nil {
+			return "", err
+		}
+		token.Values[i] = v
+	}
+	b, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor returns the sort values held by cursor. ok is false when cursor isn't a keyset cursor, such as
+// cursors issued by earlier versions which only hold the raw value of a single column
+func decodeCursor(cursor string, sort string) (values []any, ok bool, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, false, nil
+	}
+	var token cursorToken
+	if err := json.Unmarshal(b, &token); err != nil || token.Values == nil {
+		return nil, false, nil
+	}
+	if token.Sort != sort {
+		return nil, true, &serviceErrors.BadRequest{Message: fmt.Sprintf("the cursor was created for sort '%s' and can't be used with sort '%s'", token.Sort, sort)}
+	}
+
+	values = make([]any, len(token.Values))
+	for i, v := range token.Values {
+		if values[i], err = v.decode(); err != nil {
+			return nil, true, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid cursor: %v", err)}
+		}
+	}
+	return values, true, nil
+}
+
+func newCursorValue(value any) (cursorValue, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return cursorValue{Type: "null"}, nil
+		}
+		v, err := valuer.Value()
+		if err != nil {
+			return cursorValue{}, fmt.Errorf("failed to encode cursor value: %v", err)
+		}
+		value = v
+	}
+
+	var t string
+	switch v := value.(type) {
+	case nil:
+		return cursorValue{Type: "null"}, nil
+	case time.Time:
+		t, value = "time", v.Format(time.RFC3339Nano)
+	case []byte:
+		t = "bytes"
+	default:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return cursorValue{Type: "null"}, nil
+			}
+			return newCursorValue(rv.Elem().Interface())
+		}
+		switch rv.Kind() {
+		case reflect.String:
+			t, value = "string", rv.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			t, value = "int", rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			t, value = "uint", rv.Uint()
+		case reflect.Float32, reflect.Float64:
+			t, value = "float", rv.Float()
+		case reflect.Bool:
+			t, value = "bool", rv.Bool()
+		default:
+			return cursorValue{}, fmt.Errorf("unsupported cursor value type %T", value)
+		}
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return cursorValue{}, fmt.Errorf("failed to encode cursor value: %v", err)
+	}
+	return cursorValue{Type: t, Value: b}, nil
+}
+
+func (c cursorValue) decode() (any, error) {
+	switch c.Type {
+	case "null":
+		return nil, nil
+	case "time":
+		var s string
+		if err := json.Unmarshal(c.Value, &s); err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case "bytes":
+		var b []byte
+		err := json.Unmarshal(c.Value, &b)
+		return b, err
+	case "string":
+		var s string
+		err := json.Unmarshal(c.Value, &s)
+		return s, err
+	case "int":
+		var i int64
+		err := json.Unmarshal(c.Value, &i)
+		return i, err
+	case "uint":
+		var u uint64
+		err := json.Unmarshal(c.Value, &u)
+		return u, err
+	case "float":
+		var f float64
+		err := json.Unmarshal(c.Value, &f)
+		return f, err
+	case "bool":
+		var b bool
+		err := json.Unmarshal(c.Value, &b)
+		return b, err
+	default:
+		return nil, fmt.Errorf("unknown cursor value type %s", c.Type)
+	}
+}
@@ -2,12 +2,16 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"reflect"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -15,32 +19,73 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
 
+	serviceErrors "github.com/tink3rlabs/magic/errors"
 	"github.com/tink3rlabs/magic/logger"
 	"github.com/tink3rlabs/magic/storage/search/lucene"
 )
 
 type DynamoDBAdapter struct {
-	DB     *dynamodb.Client
-	config map[string]string
+	DB      *dynamodb.Client
+	config  map[string]string
+	retrier *retrier
 }
 
 var dynamoDBAdapterLock = &sync.Mutex{}
 var dynamoDBAdapterInstance *DynamoDBAdapter
 
+// GetDynamoDBAdapterInstance returns the process-wide DynamoDBAdapter, exiting the process if it can't connect
 func GetDynamoDBAdapterInstance(config map[string]string) *DynamoDBAdapter {
+	s, err := getDynamoDBAdapterInstance(config)
+	if err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+	return s
+}
+
+func getDynamoDBAdapterInstance(config map[string]string) (*DynamoDBAdapter, error) {
+	dynamoDBAdapterLock.Lock()
+	defer dynamoDBAdapterLock.Unlock()
 	if dynamoDBAdapterInstance == nil {
-		dynamoDBAdapterLock.Lock()
-		defer dynamoDBAdapterLock.Unlock()
-		if dynamoDBAdapterInstance == nil {
-			dynamoDBAdapterInstance = &DynamoDBAdapter{config: config}
-			dynamoDBAdapterInstance.OpenConnection()
+		s, err := NewDynamoDBAdapter(config)
+		if err != nil {
+			return nil, err
 		}
+		dynamoDBAdapterInstance = s
 	}
-	return dynamoDBAdapterInstance
+	return dynamoDBAdapterInstance, nil
 }
 
+// NewDynamoDBAdapter returns a new DynamoDBAdapter with its own client, unlike GetDynamoDBAdapterInstance
+// which returns a process-wide instance
+func NewDynamoDBAdapter(config map[string]string) (*DynamoDBAdapter, error) {
+	s := &DynamoDBAdapter{config: copyConfig(config)}
+	if err := s.Connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the adapter's resources. The DynamoDB client holds no persistent connections so there
+// is nothing to release, it exists so all adapters can be closed the same way
+func (s *DynamoDBAdapter) Close() error {
+	return nil
+}
+
+// OpenConnection creates the DynamoDB client, exiting the process if it can't
 func (s *DynamoDBAdapter) OpenConnection() {
+	if err := s.Connect(); err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+}
+
+// Connect creates the DynamoDB client
+func (s *DynamoDBAdapter) Connect() error {
+	var err error
+	if s.retrier, err = newRetrier(s.config, classifyDynamoDBError); err != nil {
+		return err
+	}
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 
 	if s.config["region"] != "" {
@@ -57,7 +102,7 @@ func (s *DynamoDBAdapter) OpenConnection() {
 	}
 
 	if err != nil {
-		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+		return fmt.Errorf("failed to load AWS configuration: %v", err)
 	}
 
 	s.DB = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
@@ -66,21 +111,66 @@ func (s *DynamoDBAdapter) OpenConnection() {
 			o.BaseEndpoint = aws.String(s.config["endpoint"])
 		}
 	})
+	return nil
+}
+
+// classifyDynamoDBError maps failed conditions to ErrPreconditionFailed, throttling to ErrThrottled,
+// server errors to ErrUnavailable and transaction conflicts to ErrConflict. All but failed conditions are
+// transient
+func classifyDynamoDBError(err error) (error, bool, time.Duration) {
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ConditionalCheckFailed":
+				return ErrPreconditionFailed, false, 0
+			case "TransactionConflict":
+				return ErrConflict, true, 0
+			case "ProvisionedThroughputExceeded", "ThrottlingError":
+				return ErrThrottled, true, 0
+			}
+		}
+		return nil, false, 0
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ConditionalCheckFailedException":
+			return ErrPreconditionFailed, false, 0
+		case "ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException":
+			return ErrThrottled, true, 0
+		case "InternalServerError", "ServiceUnavailable":
+			return ErrUnavailable, true, 0
+		case "TransactionConflictException", "TransactionInProgressException":
+			return ErrConflict, true, 0
+		}
+	}
+	return nil, false, 0
 }
 
 type dynamoQueryBuilder func(*dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput
 
 func (s *DynamoDBAdapter) Execute(statement string) error {
-	_, err := s.DB.ExecuteStatement(context.TODO(), &dynamodb.ExecuteStatementInput{Statement: &statement})
-	if err != nil {
-		return fmt.Errorf("failed to execute statement %s: %v", statement, err)
-	}
-	return nil
+	return s.ExecuteContext(context.Background(), statement)
+}
+
+func (s *DynamoDBAdapter) ExecuteContext(ctx context.Context, statement string) error {
+	return s.retrier.do(ctx, false, func() error {
+		_, err := s.DB.ExecuteStatement(ctx, &dynamodb.ExecuteStatementInput{Statement: &statement})
+		if err != nil {
+			return fmt.Errorf("failed to execute statement %s: %w", statement, err)
+		}
+		return nil
+	})
 }
 
 func (s *DynamoDBAdapter) Ping() error {
+	return s.PingContext(context.Background())
+}
+
+func (s *DynamoDBAdapter) PingContext(ctx context.Context) error {
 	// dynamodb is a managed service so as long as it responds to api calls we can consider it up
-	_, err := s.DB.ListTables(context.TODO(), &dynamodb.ListTablesInput{})
+	_, err := s.DB.ListTables(ctx, &dynamodb.ListTablesInput{})
 	return err
 }
 
@@ -110,73 +200,455 @@ func (s *DynamoDBAdapter) GetLatestMigration() (int, error) {
 }
 
 func (s *DynamoDBAdapter) Create(item any, params ...map[string]any) error {
-	i, err := attributevalue.MarshalMapWithOptions(item, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	return s.CreateContext(context.Background(), item, params...)
+}
+
+func (s *DynamoDBAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return s.retrier.do(ctx, false, func() error {
+		return s.putItem(ctx, item, false, params...)
+	})
+}
+
+// putItem creates or replaces item, see preparePut for how versioned items are handled
+func (s *DynamoDBAdapter) putItem(ctx context.Context, item any, update bool, params ...map[string]any) error {
+	version, _, _ := getVersion(item)
+	put, err := s.preparePut(item, update, params...)
 	if err != nil {
-		return fmt.Errorf("failed to marshal input item into dynamodb item, %v", err)
+		return err
 	}
 
-	_, err = s.DB.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(s.getTableName(item)),
-		Item:      i,
+	_, err = s.DB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 put.TableName,
+		Item:                      put.Item,
+		ConditionExpression:       put.ConditionExpression,
+		ExpressionAttributeNames:  put.ExpressionAttributeNames,
+		ExpressionAttributeValues: put.ExpressionAttributeValues,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create or update item: %v", err)
+		setVersion(item, version)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			if !update {
+				return ErrAlreadyExists
+			}
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to create or update item: %w", err)
 	}
 
 	return nil
 }
 
-func (s *DynamoDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
-	key, err := attributevalue.MarshalMapWithOptions(filter, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+// preparePut builds the Put used to create or update item. Items with a version field are only created if they
+// don't exist yet and only updated if the stored version matches the item's, in which case the version is incremented
+func (s *DynamoDBAdapter) preparePut(item any, update bool, params ...map[string]any) (*types.Put, error) {
+	put := &types.Put{TableName: aws.String(s.getTableName(item))}
+	if err := applyTTL(item, params...); err != nil {
+		return nil, err
+	}
+
+	version, versioned, err := getVersion(item)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %v", err)
+		return nil, err
+	}
+	if versioned {
+		put.ExpressionAttributeNames = map[string]string{"#version": getModelMetadata(item).field("version").Key}
+		if update {
+			put.ConditionExpression = aws.String("#version = :version")
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			}
+			setVersion(item, version+1)
+		} else {
+			put.ConditionExpression = aws.String("attribute_not_exists(#version)")
+			if err := initVersion(item); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	response, err := s.DB.GetItem(context.TODO(), &dynamodb.GetItemInput{
-		TableName: aws.String(s.getTableName(dest)),
-		Key:       key,
-	})
+	put.Item, err = marshalDynamoDBItem(item)
+	if err != nil {
+		setVersion(item, version)
+		return nil, err
+	}
+	return put, nil
+}
 
+// marshalDynamoDBItem converts item into a DynamoDB item. The expires_at attribute holds the expiry time in
+// seconds since the epoch, the format DynamoDB TTL requires, the type attribute the entity type of item and templated
+// keys the value built from their template, see keyTemplatePlaceholder
+func marshalDynamoDBItem(item any) (map[string]types.AttributeValue, error) {
+	av, err := attributevalue.MarshalMapWithOptions(item, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input item into dynamodb item, %v", err)
+	}
+	f, err := expiryField(item)
 	if err != nil {
-		return fmt.Errorf("failed to get item, %v", err)
+		return nil, err
+	}
+	if f != nil {
+		if expiresAt := getExpiresAt(item, f); expiresAt != nil {
+			av[f.Key] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)}
+		}
 	}
+	f, err = entityTypeField(item)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		setEntityType(item, f)
+		av[f.Key] = &types.AttributeValueMemberS{Value: entityType(item)}
+	}
+	if err := applyKeyTemplates(item, av); err != nil {
+		return nil, err
+	}
+	return av, nil
+}
+
+func (s *DynamoDBAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
+	return s.GetContext(context.Background(), dest, filter, params...)
+}
 
-	if response.Item == nil {
-		return ErrNotFound
-	} else {
-		err = attributevalue.UnmarshalMapWithOptions(response.Item, &dest, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
+func (s *DynamoDBAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		key, _, err := dynamoDBKey(dest, filter)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal dynamodb Get result into dest, %v", err)
+			return err
 		}
 
-		return nil
-	}
+		response, err := s.DB.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(s.getTableName(dest)),
+			Key:       key,
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to get item, %w", err)
+		}
+
+		live, err := liveItemsField(dest, params...)
+		if err != nil {
+			return err
+		}
+		expiry, err := expiryField(dest)
+		if err != nil {
+			return err
+		}
+		entity, err := entityTypeField(dest)
+		if err != nil {
+			return err
+		}
+		if response.Item == nil ||
+			(live != nil && isDynamoDBSet(response.Item[live.Key])) ||
+			(expiry != nil && isDynamoDBExpired(response.Item[expiry.Key])) ||
+			(entity != nil && !isDynamoDBEntityType(response.Item[entity.Key], entityType(dest))) {
+			return ErrNotFound
+		} else {
+			err = attributevalue.UnmarshalMapWithOptions(response.Item, &dest, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal dynamodb Get result into dest, %v", err)
+			}
+
+			return nil
+		}
+	})
 }
 
 func (s *DynamoDBAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
-	return s.Create(item)
+	return s.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (s *DynamoDBAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		return s.putItem(ctx, item, true, params...)
+	})
 }
 
 func (s *DynamoDBAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
-	key, err := attributevalue.MarshalMapWithOptions(filter, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	return s.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (s *DynamoDBAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := softDeleteField(item)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return s.Purge(ctx, item, filter, params...)
+	}
+	return s.retrier.do(ctx, true, func() error {
+		now := time.Now().UTC()
+		update, err := s.prepareSoftDelete(item, f, filter, now)
+		if err != nil {
+			return err
+		}
+		_, err = s.updateItem(ctx, update, types.ReturnValueNone)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// the item doesn't exist or was already deleted
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete item, %w", err)
+		}
+		setDeletedAt(item, f, &now)
+		return nil
+	})
+}
+
+// Restore undeletes the soft deleted item whose key is filter, returning ErrNotFound if there's none
+func (s *DynamoDBAdapter) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		f, err := requireSoftDeleteField(item)
+		if err != nil {
+			return err
+		}
+		update, err := s.prepareRestore(item, f, filter)
+		if err != nil {
+			return err
+		}
+		_, err = s.updateItem(ctx, update, types.ReturnValueNone)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore item, %w", err)
+		}
+		setDeletedAt(item, f, nil)
+		return nil
+	})
+}
+
+// prepareSoftDelete returns the update setting the deleted_at attribute of the item whose key is filter to now.
+// It's conditioned on the item existing and not being deleted yet, so deleting an item twice keeps its first
+// deletion time
+func (s *DynamoDBAdapter) prepareSoftDelete(item any, f *modelField, filter map[string]any, now time.Time) (*types.Update, error) {
+	key, keyName, err := dynamoDBKey(item, filter)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item id into dynamodb attribute, %v", err)
+		return nil, err
 	}
+	deletedAt, err := attributevalue.Marshal(now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deletion time into dynamodb attribute, %v", err)
+	}
+	return &types.Update{
+		TableName:           aws.String(s.getTableName(item)),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET #deleted_at = :deleted_at"),
+		ConditionExpression: aws.String("attribute_exists(#key) AND (attribute_not_exists(#deleted_at) OR attribute_type(#deleted_at, :null))"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":        keyName,
+			"#deleted_at": f.Key,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted_at": deletedAt,
+			":null":       &types.AttributeValueMemberS{Value: "NULL"},
+		},
+	}, nil
+}
 
-	_, err = s.DB.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-		TableName: aws.String(s.getTableName(item)),
-		Key:       key,
+// prepareRestore returns the update removing the deleted_at attribute of the item whose key is filter, conditioned
+// on the item being soft deleted
+func (s *DynamoDBAdapter) prepareRestore(item any, f *modelField, filter map[string]any) (*types.Update, error) {
+	key, _, err := dynamoDBKey(item, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Update{
+		TableName:                 aws.String(s.getTableName(item)),
+		Key:                       key,
+		UpdateExpression:          aws.String("REMOVE #deleted_at"),
+		ConditionExpression:       aws.String("attribute_exists(#deleted_at) AND NOT attribute_type(#deleted_at, :null)"),
+		ExpressionAttributeNames:  map[string]string{"#deleted_at": f.Key},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":null": &types.AttributeValueMemberS{Value: "NULL"}},
+	}, nil
+}
+
+// updateItem runs a prepared update outside of a transaction, returning the attributes selected by returnValues
+func (s *DynamoDBAdapter) updateItem(ctx context.Context, update *types.Update, returnValues types.ReturnValue) (map[string]types.AttributeValue, error) {
+	response, err := s.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 update.TableName,
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+		ReturnValues:              returnValues,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Attributes, nil
+}
+
+// Patch changes the attributes in changes of the item whose key is filter with a single UpdateItem, filling item
+// with the patched item. Increments and appends use if_not_exists so they also work on missing attributes
+func (s *DynamoDBAdapter) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	changes, idempotent, err := preparePatch(item, changes, jsonFieldName)
+	if err != nil {
+		return err
+	}
+	return s.retrier.do(ctx, idempotent, func() error {
+		update, err := s.patchUpdate(item, filter, changes, params...)
+		if err != nil {
+			return err
+		}
+		attributes, err := s.updateItem(ctx, update, types.ReturnValueAllNew)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to patch item, %w", err)
+		}
+		if err := attributevalue.UnmarshalMapWithOptions(attributes, item, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" }); err != nil {
+			return fmt.Errorf("failed to unmarshal patched item, %v", err)
+		}
+		return nil
 	})
+}
 
+// patchUpdate returns the update applying changes to the item whose key is filter, conditioned on the item existing
+// and, like reads, on it not being expired or soft deleted
+func (s *DynamoDBAdapter) patchUpdate(item any, filter map[string]any, changes map[string]any, params ...map[string]any) (*types.Update, error) {
+	key, keyName, err := dynamoDBKey(item, filter)
+	if err != nil {
+		return nil, err
+	}
+	condition, names, values, err := liveItemsExpression(item, params...)
 	if err != nil {
-		return fmt.Errorf("failed to delete item, %v", err)
+		return nil, err
+	}
+	if condition != "" {
+		condition = " AND " + condition
 	}
+	condition = "attribute_exists(#key)" + condition
+	names["#key"] = keyName
 
-	return nil
+	expiry, err := expiryField(item)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(changes))
+	for k := range changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	set, remove := []string{}, []string{}
+	for i, k := range keys {
+		name, value := fmt.Sprintf("#p%d", i), fmt.Sprintf(":p%d", i)
+		names[name] = k
+
+		change := changes[k]
+		if change == nil {
+			remove = append(remove, name)
+			continue
+		}
+		op, isOp := change.(PatchOperation)
+		if isOp {
+			change = op.Value
+		}
+		av, err := attributevalue.MarshalWithOptions(change, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the change of %s into dynamodb attribute, %v", k, err)
+		}
+		if t, ok := change.(*time.Time); ok && expiry != nil && k == expiry.Key {
+			av = &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+		}
+		values[value] = av
+
+		switch {
+		case !isOp:
+			set = append(set, fmt.Sprintf("%s = %s", name, value))
+		case op.Op == PATCH_INCREMENT:
+			set = append(set, fmt.Sprintf("%s = if_not_exists(%s, :zero) + %s", name, name, value))
+			values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+		case op.Op == PATCH_APPEND:
+			set = append(set, fmt.Sprintf("%s = list_append(if_not_exists(%s, :empty), %s)", name, name, value))
+			values[":empty"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{}}
+		}
+	}
+	if f := getModelMetadata(item).field("version"); f != nil {
+		names["#version"] = f.Key
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+		set = append(set, "#version = if_not_exists(#version, :zero) + :one")
+	}
+
+	expression := []string{}
+	if len(set) > 0 {
+		expression = append(expression, "SET "+strings.Join(set, ", "))
+	}
+	if len(remove) > 0 {
+		expression = append(expression, "REMOVE "+strings.Join(remove, ", "))
+	}
+	return &types.Update{
+		TableName:                 aws.String(s.getTableName(item)),
+		Key:                       key,
+		UpdateExpression:          aws.String(strings.Join(expression, " ")),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}, nil
+}
+
+// dynamoDBKey marshals filter into the key of an item of model, also returning the name of one of its attributes.
+// Key attributes built from a template may be given by the fields of their template, see dynamoDBKeyFilter
+func dynamoDBKey(model any, filter map[string]any) (map[string]types.AttributeValue, string, error) {
+	if len(filter) == 0 {
+		return nil, "", fmt.Errorf("a key is required to identify the item")
+	}
+	filter, err := dynamoDBKeyFilter(model, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := attributevalue.MarshalMapWithOptions(filter, func(eo *attributevalue.EncoderOptions) { eo.TagKey = "json" })
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal item id into dynamodb attribute, %v", err)
+	}
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return key, names[0], nil
+}
+
+// isDynamoDBSet reports whether av holds a value other than NULL
+func isDynamoDBSet(av types.AttributeValue) bool {
+	if av == nil {
+		return false
+	}
+	_, isNull := av.(*types.AttributeValueMemberNULL)
+	return !isNull
+}
+
+// Purge deletes the item whose key is filter for good, whether it was soft deleted or not
+func (s *DynamoDBAdapter) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		key, _, err := dynamoDBKey(item, filter)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.getTableName(item)),
+			Key:       key,
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to delete item, %w", err)
+		}
+
+		return nil
+	})
 }
 
 func (s *DynamoDBAdapter) executePaginatedQuery(
+	ctx context.Context,
 	dest any,
 	limit int,
 	cursor string,
@@ -192,7 +664,7 @@ func (s *DynamoDBAdapter) executePaginatedQuery(
 
 	input = builder(input)
 
-	response, err := s.DB.ExecuteStatement(context.TODO(), input)
+	response, err := s.DB.ExecuteStatement(ctx, input)
 	if err != nil {
 		slog.Error("Query execution failed", "error", err)
 		return "", err
@@ -216,120 +688,628 @@ func (s *DynamoDBAdapter) executePaginatedQuery(
 }
 
 func (s *DynamoDBAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
-	return s.executePaginatedQuery(dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
-		query := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(dest))
-
-		if len(filter) > 0 {
-			params, _ := s.buildParams(filter)
-			input.Parameters = params
-			query += fmt.Sprintf(` WHERE %s`, s.buildFilter(filter))
-		}
+	return s.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
 
-		if sortKey != "" {
-			query += fmt.Sprintf(` ORDER BY %s`, sortKey)
+// ListContext queries the table, or one of its indexes, when filter has a value for its partition key, see
+// getDynamoDBTableSchema. Other filters scan the whole table and fail with ErrScanNotAllowed unless scans are
+// allowed with DYNAMODB_ALLOW_SCAN
+func (s *DynamoDBAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	filter, prefixes := expandKeyTemplates(dest, filter)
+	fields := map[string]bool{}
+	for key, value := range filter {
+		fields[key] = isDynamoDBKeyValue(value)
+	}
+	// Models without a usable key schema can still be scanned
+	q, err := findKeyQuery(dest, fields, sortKey)
+	if err != nil && !s.allowScan(params...) {
+		return "", err
+	}
+	if q != nil {
+		return retryResult(ctx, s.retrier, true, func() (string, error) {
+			return s.queryItems(ctx, dest, q, filter, prefixes, "", nil, nil, limit, cursor, params...)
+		})
+	}
+	if !s.allowScan(params...) {
+		return "", fmt.Errorf("failed to list %s: %w", s.getTableName(dest), ErrScanNotAllowed)
+	}
+	if sortKey != "" {
+		return "", &serviceErrors.BadRequest{
+			Message: fmt.Sprintf("can't sort by '%s', only results filtered on a partition key can be sorted", sortKey),
 		}
-
-		input.Statement = aws.String(query)
-		return input
+	}
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		return s.queryItems(ctx, dest, nil, filter, nil, "", nil, nil, limit, cursor, params...)
 	})
 }
 
 func (s *DynamoDBAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return s.executePaginatedQuery(dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
-		// Parse Lucene query
-		destType := reflect.TypeOf(dest).Elem().Elem()
-		model := reflect.New(destType).Elem().Interface()
-		parser, _ := lucene.NewParserFromType(model)
-		whereClause, params, _ := parser.ParseToDynamoDBPartiQL(query)
+	return s.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
 
-		// Build query
-		query := fmt.Sprintf(`SELECT * FROM "%s"`, s.getTableName(dest))
-		if whereClause != "" {
-			query += fmt.Sprintf(` WHERE %s`, whereClause)
+// SearchContext reads from the table, or one of its indexes, when the query requires a value for its partition
+// key, e.g. "tenant:acme AND name:john". The key terms form the key condition of a Query and the rest of the query
+// its filter expression, see lucene.Parser.ParseToDynamoDBExpression. Other queries scan the whole table and fail
+// with ErrScanNotAllowed unless scans are allowed with DYNAMODB_ALLOW_SCAN
+func (s *DynamoDBAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	// Parse Lucene query
+	destType := reflect.TypeOf(dest).Elem().Elem()
+	model := reflect.New(destType).Elem().Interface()
+	parser, err := lucene.NewParserFromType(model)
+	if err != nil {
+		return "", err
+	}
+	required, err := parser.RequiredFields(query)
+	if err != nil {
+		return "", err
+	}
+	fields := map[string]bool{}
+	for _, field := range required {
+		fields[field] = true
+	}
+	// Models without a usable key schema can still be scanned
+	q, err := findKeyQuery(dest, fields, sortKey)
+	if err != nil && !s.allowScan(params...) {
+		return "", err
+	}
+	if q == nil && !s.allowScan(params...) {
+		return "", fmt.Errorf("failed to search %s: %w", s.getTableName(dest), ErrScanNotAllowed)
+	}
+	if q == nil && sortKey != "" {
+		return "", &serviceErrors.BadRequest{
+			Message: fmt.Sprintf("can't sort by '%s', only results filtered on a partition key can be sorted", sortKey),
 		}
-		if sortKey != "" {
-			query += fmt.Sprintf(` ORDER BY %s`, sortKey)
+	}
+
+	keyFields := []string{}
+	if q != nil {
+		keyFields = append(keyFields, q.Key.PartitionKey)
+		if q.Key.SortKey != "" {
+			keyFields = append(keyFields, q.Key.SortKey)
 		}
+	}
+	search, err := parser.ParseToDynamoDBExpression(query, keyFields...)
+	if err != nil {
+		return "", err
+	}
+	keys, err := dynamoDBKeyValues(model, search.Keys)
+	if err != nil {
+		return "", err
+	}
 
-		input.Statement = aws.String(query)
-		input.Parameters = params
-		return input
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		return s.queryItems(ctx, dest, q, keys, nil, search.Condition, search.Names, search.Values, limit, cursor, params...)
 	})
 }
 
 func (s *DynamoDBAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
-	// TODO Implement
-	var total int64
-	return total, nil
+	return s.CountContext(context.Background(), dest, filter, params...)
+}
+
+// CountContext counts the items matching filter with a Query on the table, or one of its indexes, when filter has a
+// value for its partition key, as ListContext does. Other filters scan the whole table and fail with
+// ErrScanNotAllowed unless scans are allowed with DYNAMODB_ALLOW_SCAN
+func (s *DynamoDBAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	filter, prefixes := expandKeyTemplates(dest, filter)
+	fields := map[string]bool{}
+	for key, value := range filter {
+		fields[key] = isDynamoDBKeyValue(value)
+	}
+	// Models without a usable key schema can still be scanned
+	q, err := findKeyQuery(dest, fields, "")
+	if err != nil && !s.allowScan(params...) {
+		return 0, err
+	}
+	if q == nil && !s.allowScan(params...) {
+		return 0, fmt.Errorf("failed to count %s: %w", s.getTableName(dest), ErrScanNotAllowed)
+	}
+
+	return retryResult(ctx, s.retrier, true, func() (int64, error) {
+		live, liveNames, liveValues, err := liveItemsExpression(dest, params...)
+		if err != nil {
+			return 0, err
+		}
+		expressions, err := s.buildReadExpressions(q, filter, prefixes, live, liveNames, liveValues)
+		if err != nil {
+			return 0, err
+		}
+
+		var total int64
+		if q != nil {
+			input := expressions.queryInput(s.getTableName(dest), q)
+			input.Select = types.SelectCount
+			paginator := dynamodb.NewQueryPaginator(s.DB, input)
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(ctx)
+				if err != nil {
+					return 0, fmt.Errorf("failed to count items: %w", err)
+				}
+				total += int64(page.Count)
+			}
+			return total, nil
+		}
+
+		input := expressions.scanInput(s.getTableName(dest))
+		input.Select = types.SelectCount
+		paginator := dynamodb.NewScanPaginator(s.DB, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return 0, fmt.Errorf("failed to count items: %w", err)
+			}
+			total += int64(page.Count)
+		}
+		return total, nil
+	})
 }
 
 func (s *DynamoDBAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return s.executePaginatedQuery(dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
-		input.Statement = aws.String(statement)
-		return input
+	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
+
+func (s *DynamoDBAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		return s.executePaginatedQuery(ctx, dest, limit, cursor, func(input *dynamodb.ExecuteStatementInput) *dynamodb.ExecuteStatementInput {
+			input.Statement = aws.String(statement)
+			return input
+		})
 	})
 }
 
-func (s *DynamoDBAdapter) getTableName(obj any) string {
-	// Get the type of obj
-	tableName := ""
-	tableName = reflect.TypeOf(obj).String()
-	tableName = tableName[strings.LastIndex(tableName, ".")+1:]
+// liveItemsExpression returns the condition expression leaving the expired items of model, and the items of other
+// entity types sharing its table, out of a read, as well as its soft deleted items unless params include
+// INCLUDE_DELETED. It returns "" if no item needs to be left out
+func liveItemsExpression(model any, params ...map[string]any) (string, map[string]string, map[string]types.AttributeValue, error) {
+	conditions := []string{}
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	f, err := liveItemsField(model, params...)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if f != nil {
+		conditions = append(conditions, "(attribute_not_exists(#deleted_at) OR attribute_type(#deleted_at, :null))")
+		names["#deleted_at"] = f.Key
+		values[":null"] = &types.AttributeValueMemberS{Value: "NULL"}
+	}
+
+	f, err = expiryField(model)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if f != nil {
+		conditions = append(conditions, "(attribute_not_exists(#expires_at) OR attribute_type(#expires_at, :null) OR #expires_at > :now)")
+		names["#expires_at"] = f.Key
+		values[":null"] = &types.AttributeValueMemberS{Value: "NULL"}
+		values[":now"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiryNow().Unix(), 10)}
+	}
 
-	// Convert the table name to snake case
-	matchFirstCap := regexp.MustCompile("(.)([A-Z][a-z]+)")
-	matchAllCap := regexp.MustCompile("([a-z0-9])([A-Z])")
-	tableName = matchFirstCap.ReplaceAllString(tableName, "${1}_${2}")
-	tableName = matchAllCap.ReplaceAllString(tableName, "${1}_${2}")
+	f, err = entityTypeField(model)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if f != nil {
+		conditions = append(conditions, "#entity_type = :entity_type")
+		names["#entity_type"] = f.Key
+		values[":entity_type"] = &types.AttributeValueMemberS{Value: entityType(model)}
+	}
+	return strings.Join(conditions, " AND "), names, values, nil
+}
 
-	tableName = strings.ToLower(tableName)
-	tableName += "s"
-	return tableName
+// isDynamoDBEntityType reports whether the type attribute av holds entityType
+func isDynamoDBEntityType(av types.AttributeValue, entityType string) bool {
+	s, ok := av.(*types.AttributeValueMemberS)
+	return ok && s.Value == entityType
 }
 
-func (s *DynamoDBAdapter) buildFilter(filter map[string]any) string {
+// isDynamoDBExpired reports whether the expires_at attribute av holds a time that has passed. DynamoDB TTL
+// deletes expired items in the background, possibly days later, so reads have to leave them out themselves
+func isDynamoDBExpired(av types.AttributeValue) bool {
+	n, ok := av.(*types.AttributeValueMemberN)
+	if !ok {
+		return false
+	}
+	seconds, err := strconv.ParseInt(n.Value, 10, 64)
+	return err == nil && seconds <= expiryNow().Unix()
+}
+
+// getTableName returns the table of obj, see modelTableName
+func (s *DynamoDBAdapter) getTableName(obj any) string {
+	return modelTableName(obj, s.config["table_prefix"], s.config["table_suffix"])
+}
+
+// buildFilterExpression converts filter into a condition expression matching the items whose attributes equal its
+// values, slices match any of their values and nil matches a missing or null attribute
+func (s *DynamoDBAdapter) buildFilterExpression(filter map[string]any) (string, map[string]string, map[string]types.AttributeValue, error) {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	clauses := []string{}
-	for key, value := range filter {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	for i, key := range keys {
+		name := fmt.Sprintf("#f%d", i)
+		names[name] = key
+
+		value := filter[key]
+		if value == nil {
+			clauses = append(clauses, fmt.Sprintf("(attribute_not_exists(%s) OR attribute_type(%s, :null))", name, name))
+			values[":null"] = &types.AttributeValueMemberS{Value: "NULL"}
+			continue
+		}
+
 		if reflect.ValueOf(value).Kind() == reflect.Slice {
-			c := "IN ("
-			len := reflect.ValueOf(value).Len()
-			for i := 0; i < len; i++ {
-				if i < len-1 {
-					c += "?,"
-				} else {
-					c += "?)"
+			slice := reflect.ValueOf(value)
+			placeholders := make([]string, slice.Len())
+			for j := 0; j < slice.Len(); j++ {
+				placeholder := fmt.Sprintf(":f%d_%d", i, j)
+				v, err := attributevalue.Marshal(slice.Index(j).Interface())
+				if err != nil {
+					return "", nil, nil, err
 				}
+				placeholders[j] = placeholder
+				values[placeholder] = v
 			}
-			clauses = append(clauses, fmt.Sprintf("%s %s", key, c))
-		} else {
-			clauses = append(clauses, fmt.Sprintf("%s=?", key))
+			clauses = append(clauses, fmt.Sprintf("%s IN (%s)", name, strings.Join(placeholders, ", ")))
+			continue
+		}
+
+		placeholder := fmt.Sprintf(":f%d", i)
+		v, err := attributevalue.Marshal(value)
+		if err != nil {
+			return "", nil, nil, err
 		}
+		values[placeholder] = v
+		clauses = append(clauses, fmt.Sprintf("%s = %s", name, placeholder))
 	}
-	return strings.Join(clauses, " AND ")
+	return strings.Join(clauses, " AND "), names, values, nil
 }
 
-func (s *DynamoDBAdapter) buildParams(filter map[string]any) ([]types.AttributeValue, error) {
-	values := make([]types.AttributeValue, 0, len(filter))
+const DYNAMODB_MAX_TRANSACTION_ITEMS = 100
 
-	for _, value := range filter {
-		if reflect.ValueOf(value).Kind() == reflect.Slice {
-			len := reflect.ValueOf(value).Len()
-			for i := 0; i < len; i++ {
-				t := reflect.ValueOf(value).Index(i).Interface()
-				v, err := attributevalue.Marshal(t)
-				if err != nil {
-					return values, err
+// WithTransaction buffers every Create, Update and Delete performed through tx and applies them
+// atomically with a single TransactWriteItems call once fn returns. Reads made through tx are not
+// part of the transaction and don't observe its pending writes. DynamoDB limits a transaction to
+// 100 items
+func (s *DynamoDBAdapter) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return s.retrier.do(ctx, false, func() error {
+		tx := &dynamoDBTransaction{DynamoDBAdapter: s}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if len(tx.items) == 0 {
+			return nil
+		}
+
+		_, err := s.DB.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: tx.items})
+		if err != nil {
+			var canceled *types.TransactionCanceledException
+			if errors.As(err, &canceled) {
+				// Reasons are listed in the order of the items, the first failed condition names the item at fault
+				for i, reason := range canceled.CancellationReasons {
+					if aws.ToString(reason.Code) == "ConditionalCheckFailed" && i < len(tx.conditionErrs) {
+						return fmt.Errorf("failed to commit transaction, item %d: %w", i, tx.conditionErrs[i])
+					}
 				}
-				values = append(values, v)
 			}
-		} else {
-			v, err := attributevalue.Marshal(value)
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	})
+}
+
+// dynamoDBTransaction is the StorageAdapter handed to WithTransaction callbacks
+type dynamoDBTransaction struct {
+	*DynamoDBAdapter
+	items         []types.TransactWriteItem
+	conditionErrs []error
+}
+
+// add buffers item, conditionErr is returned by WithTransaction if the condition of item fails, matching the error
+// the same operation returns outside of a transaction
+func (t *dynamoDBTransaction) add(item types.TransactWriteItem, conditionErr error) error {
+	if len(t.items) >= DYNAMODB_MAX_TRANSACTION_ITEMS {
+		return fmt.Errorf("a dynamodb transaction can't contain more than %d items", DYNAMODB_MAX_TRANSACTION_ITEMS)
+	}
+	t.items = append(t.items, item)
+	t.conditionErrs = append(t.conditionErrs, conditionErr)
+	return nil
+}
+
+// WithTransaction on an open transaction simply joins it
+func (t *dynamoDBTransaction) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return fn(t)
+}
+
+func (t *dynamoDBTransaction) Execute(statement string) error {
+	return t.ExecuteContext(context.Background(), statement)
+}
+
+func (t *dynamoDBTransaction) ExecuteContext(ctx context.Context, statement string) error {
+	return &NotSupportedError{Adapter: DYNAMODB, Operation: "Execute within a transaction"}
+}
+
+func (t *dynamoDBTransaction) Create(item any, params ...map[string]any) error {
+	return t.CreateContext(context.Background(), item, params...)
+}
+
+func (t *dynamoDBTransaction) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	put, err := t.preparePut(item, false, params...)
+	if err != nil {
+		return err
+	}
+	return t.add(types.TransactWriteItem{Put: put}, ErrAlreadyExists)
+}
+
+func (t *dynamoDBTransaction) Update(item any, filter map[string]any, params ...map[string]any) error {
+	return t.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (t *dynamoDBTransaction) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	put, err := t.preparePut(item, true, params...)
+	if err != nil {
+		return err
+	}
+	// Only versioned updates have a condition, which fails on a stale version
+	return t.add(types.TransactWriteItem{Put: put}, ErrConflict)
+}
+
+func (t *dynamoDBTransaction) Delete(item any, filter map[string]any, params ...map[string]any) error {
+	return t.DeleteContext(context.Background(), item, filter, params...)
+}
+
+// DeleteContext soft deletes items of models tagged with deleted_at, which fails the transaction if the item
+// doesn't exist or is already deleted
+func (t *dynamoDBTransaction) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := softDeleteField(item)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return t.Purge(ctx, item, filter, params...)
+	}
+	update, err := t.prepareSoftDelete(item, f, filter, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return t.add(types.TransactWriteItem{Update: update}, ErrNotFound)
+}
+
+func (t *dynamoDBTransaction) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	f, err := requireSoftDeleteField(item)
+	if err != nil {
+		return err
+	}
+	update, err := t.prepareRestore(item, f, filter)
+	if err != nil {
+		return err
+	}
+	return t.add(types.TransactWriteItem{Update: update}, ErrNotFound)
+}
+
+// Patch adds the update of the item to the transaction, item isn't filled with the patched item
+func (t *dynamoDBTransaction) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	changes, _, err := preparePatch(item, changes, jsonFieldName)
+	if err != nil {
+		return err
+	}
+	update, err := t.patchUpdate(item, filter, changes, params...)
+	if err != nil {
+		return err
+	}
+	return t.add(types.TransactWriteItem{Update: update}, ErrNotFound)
+}
+
+func (t *dynamoDBTransaction) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	key, _, err := dynamoDBKey(item, filter)
+	if err != nil {
+		return err
+	}
+	return t.add(types.TransactWriteItem{Delete: &types.Delete{
+		TableName: aws.String(t.getTableName(item)),
+		Key:       key,
+	}}, nil)
+}
+
+// BatchCreate adds the creation of each of items to the transaction, see CreateContext
+func (t *dynamoDBTransaction) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return eachBatchItem(items, func(item any) error {
+		return t.CreateContext(ctx, item, params...)
+	})
+}
+
+// BatchUpsert adds an unconditional write of each of items to the transaction, versions are not checked
+func (t *dynamoDBTransaction) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return eachBatchItem(items, func(item any) error {
+		if err := applyTTL(item, params...); err != nil {
+			return err
+		}
+		av, err := marshalDynamoDBItem(item)
+		if err != nil {
+			return err
+		}
+		return t.add(types.TransactWriteItem{Put: &types.Put{TableName: aws.String(t.getTableName(item)), Item: av}}, nil)
+	})
+}
+
+// BatchDelete adds the deletion of the items whose keys are given by filters to the transaction, see DeleteContext
+func (t *dynamoDBTransaction) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	results := make([]BatchResult, len(filters))
+	for i, filter := range filters {
+		results[i] = BatchResult{Index: i, Err: t.DeleteContext(ctx, item, filter, params...)}
+	}
+	return results, nil
+}
+
+const (
+	DYNAMODB_BATCH_SIZE    = 25
+	DYNAMODB_BATCH_RETRIES = 5
+)
+
+// BatchCreate writes items with BatchWriteItem. BatchWriteItem doesn't support conditions, so an item replaces any
+// existing item with the same key, even if it is versioned
+func (s *DynamoDBAdapter) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	elements, err := batchItems(items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range elements {
+		if err := initVersion(item); err != nil {
+			return nil, err
+		}
+	}
+	return s.BatchUpsert(ctx, items, params...)
+}
+
+// BatchUpsert writes items with BatchWriteItem, DYNAMODB_BATCH_SIZE items per request. Versions are not checked
+func (s *DynamoDBAdapter) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	elements, err := batchItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]types.WriteRequest, len(elements))
+	results := make([]BatchResult, len(elements))
+	for i, item := range elements {
+		results[i].Index = i
+		if err := applyTTL(item, params...); err != nil {
+			results[i].Err = err
+			continue
+		}
+		av, err := marshalDynamoDBItem(item)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: av}}
+	}
+
+	tableName := ""
+	if len(elements) > 0 {
+		tableName = s.getTableName(elements[0])
+	}
+	s.executeBatchWrite(ctx, tableName, requests, results)
+	return results, nil
+}
+
+// BatchDelete deletes the items whose keys are given by filters with BatchWriteItem, DYNAMODB_BATCH_SIZE items per request
+func (s *DynamoDBAdapter) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	if f, err := softDeleteField(item); err != nil {
+		return nil, err
+	} else if f != nil {
+		// BatchWriteItem can't update items, so they are soft deleted one at a time
+		results := make([]BatchResult, len(filters))
+		for i, filter := range filters {
+			results[i] = BatchResult{Index: i, Err: s.DeleteContext(ctx, item, filter, params...)}
+		}
+		return results, nil
+	}
+
+	requests := make([]types.WriteRequest, len(filters))
+	results := make([]BatchResult, len(filters))
+	for i, filter := range filters {
+		results[i].Index = i
+		key, _, err := dynamoDBKey(item, filter)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		requests[i] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}
+	}
+
+	s.executeBatchWrite(ctx, s.getTableName(item), requests, results)
+	return results, nil
+}
+
+// executeBatchWrite sends requests in chunks of DYNAMODB_BATCH_SIZE, skipping those whose result already holds an
+// error. Unprocessed items are retried with exponential backoff up to DYNAMODB_BATCH_RETRIES times, after which
+// they are reported as failed
+func (s *DynamoDBAdapter) executeBatchWrite(ctx context.Context, tableName string, requests []types.WriteRequest, results []BatchResult) {
+	pending := []int{}
+	for i := range requests {
+		if results[i].Err == nil {
+			pending = append(pending, i)
+		}
+	}
+
+	for start := 0; start < len(pending); start += DYNAMODB_BATCH_SIZE {
+		chunk := pending[start:min(start+DYNAMODB_BATCH_SIZE, len(pending))]
+
+		for attempt := 0; len(chunk) > 0; attempt++ {
+			if attempt > DYNAMODB_BATCH_RETRIES {
+				for _, i := range chunk {
+					results[i].Err = fmt.Errorf("item was not processed after %d retries: %w", DYNAMODB_BATCH_RETRIES, ErrThrottled)
+				}
+				break
+			}
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					for _, i := range chunk {
+						results[i].Err = ctx.Err()
+					}
+					return
+				case <-time.After(time.Duration(50<<attempt) * time.Millisecond):
+				}
+			}
+
+			writeRequests := make([]types.WriteRequest, len(chunk))
+			for j, i := range chunk {
+				writeRequests[j] = requests[i]
+			}
+			output, err := retryResult(ctx, s.retrier, true, func() (*dynamodb.BatchWriteItemOutput, error) {
+				return s.DB.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+					RequestItems: map[string][]types.WriteRequest{tableName: writeRequests},
+				})
+			})
 			if err != nil {
-				return values, err
+				for _, i := range chunk {
+					results[i].Err = fmt.Errorf("failed to write batch: %w", err)
+				}
+				break
 			}
-			values = append(values, v)
+			chunk = unprocessedRequests(requests, chunk, output.UnprocessedItems[tableName])
+		}
+	}
+}
+
+// unprocessedRequests returns the indexes of chunk whose request is among the unprocessed ones
+func unprocessedRequests(requests []types.WriteRequest, chunk []int, unprocessed []types.WriteRequest) []int {
+	if len(unprocessed) == 0 {
+		return nil
+	}
+	remaining := map[string]int{}
+	for _, r := range unprocessed {
+		remaining[writeRequestFingerprint(r)]++
+	}
+	result := []int{}
+	for _, i := range chunk {
+		f := writeRequestFingerprint(requests[i])
+		if remaining[f] > 0 {
+			remaining[f]--
+			result = append(result, i)
 		}
 	}
+	return result
+}
 
-	return values, nil
+// writeRequestFingerprint returns a string identifying the item or key of a write request
+func writeRequestFingerprint(r types.WriteRequest) string {
+	var av map[string]types.AttributeValue
+	prefix := "put:"
+	if r.PutRequest != nil {
+		av = r.PutRequest.Item
+	} else if r.DeleteRequest != nil {
+		prefix = "delete:"
+		av = r.DeleteRequest.Key
+	}
+	var m map[string]any
+	if err := attributevalue.UnmarshalMap(av, &m); err != nil {
+		return prefix
+	}
+	b, _ := json.Marshal(m)
+	return prefix + string(b)
 }
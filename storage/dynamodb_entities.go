@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Key templates build the value of a string field from other fields of the model, which is how single-table designs
+// prefix their keys with the entity type:
+//
+//	type Order struct {
+//	    PK     string `json:"PK" magic:"pk,template=TENANT#{tenant}"`
+//	    SK     string `json:"SK" magic:"sk,template=ORDER#{id}"`
+//	    Type   string `json:"type" magic:"type"`
+//	    Tenant string `json:"tenant"`
+//	    ID     string `json:"id"`
+//	}
+//
+// Placeholders name fields by their json name. Templated fields are filled on every write, and filters of Get,
+// Update, Delete, Patch, Restore and Purge may give the fields of the templates instead of the key itself, e.g.
+// {"tenant": "acme", "id": "1"}. List queries the partition key when the filter has the fields of its template, and
+// narrows the sort key down to the constant prefix of its template ("ORDER#") when it doesn't have all of its fields
+var keyTemplatePlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// keyTemplate returns the template of f, or "" if f isn't built from other fields
+func keyTemplate(f *modelField) string {
+	if template := f.Options["template"]; len(template) > 0 {
+		return template[0]
+	}
+	return ""
+}
+
+// templateFields returns the fields of model whose value is built from a template
+func templateFields(model any) ([]*modelField, error) {
+	fields := []*modelField{}
+	for _, f := range getModelMetadata(model).Fields {
+		if keyTemplate(f) == "" {
+			continue
+		}
+		if f.Type.Kind() != reflect.String {
+			return nil, fmt.Errorf("templated field %s must be a string, got %s", f.Name, f.Type)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// renderKeyTemplate replaces the placeholders of template with the values of the fields they name, ok is false if
+// values lacks one of them or holds a value that can't be part of a key, such as a slice
+func renderKeyTemplate(template string, values map[string]any) (string, bool) {
+	ok := true
+	rendered := keyTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, found := values[placeholder[1:len(placeholder)-1]]
+		if !found || !isDynamoDBKeyValue(value) {
+			ok = false
+			return ""
+		}
+		return formatKeyValue(value)
+	})
+	return rendered, ok
+}
+
+// keyTemplatePrefix returns the constant part of template before its first placeholder
+func keyTemplatePrefix(template string) string {
+	if loc := keyTemplatePlaceholder.FindStringIndex(template); loc != nil {
+		return template[:loc[0]]
+	}
+	return template
+}
+
+func formatKeyValue(value any) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// fieldValues returns the values of the exported fields of item by json name, descending into embedded structs
+func fieldValues(item any) map[string]any {
+	values := map[string]any{}
+	v := reflect.Indirect(reflect.ValueOf(item))
+	if v.Kind() != reflect.Struct {
+		return values
+	}
+	var collect func(v reflect.Value)
+	collect = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				collect(v.Field(i))
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			key := field.Name
+			if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" && jsonName != "-" {
+				key = jsonName
+			}
+			values[key] = v.Field(i).Interface()
+		}
+	}
+	collect(v)
+	return values
+}
+
+// applyKeyTemplates fills the templated fields of item and sets them in av, the marshalled item
+func applyKeyTemplates(item any, av map[string]types.AttributeValue) error {
+	fields, err := templateFields(item)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	values := fieldValues(item)
+	settable := reflect.ValueOf(item).Kind() == reflect.Ptr
+	for _, f := range fields {
+		rendered, ok := renderKeyTemplate(keyTemplate(f), values)
+		if !ok {
+			return fmt.Errorf("can't build %s from template '%s', it names a missing or non scalar field", f.Name, keyTemplate(f))
+		}
+		if field := f.value(item); settable && field.CanSet() {
+			field.SetString(rendered)
+		}
+		av[f.Key] = &types.AttributeValueMemberS{Value: rendered}
+	}
+	return nil
+}
+
+// dynamoDBKeyFilter returns the key attributes of the item of model matching filter, building the templated ones
+// from the fields of their template when filter doesn't have them. Filters of models without templated keys are
+// returned as they are
+func dynamoDBKeyFilter(model any, filter map[string]any) (map[string]any, error) {
+	fields, err := templateFields(model)
+	if err != nil || len(fields) == 0 {
+		return filter, err
+	}
+	schema, err := getDynamoDBTableSchema(model)
+	if err != nil {
+		return nil, err
+	}
+	templates := map[string]string{}
+	for _, f := range fields {
+		templates[f.Key] = keyTemplate(f)
+	}
+
+	key := map[string]any{}
+	for _, name := range []string{schema.Key.PartitionKey, schema.Key.SortKey} {
+		if name == "" {
+			continue
+		}
+		if value, ok := filter[name]; ok {
+			key[name] = value
+			continue
+		}
+		template, ok := templates[name]
+		if !ok {
+			return nil, fmt.Errorf("a value for %s is required to identify the item", name)
+		}
+		rendered, ok := renderKeyTemplate(template, filter)
+		if !ok {
+			return nil, fmt.Errorf("the fields of template '%s' are required to identify the item", template)
+		}
+		key[name] = rendered
+	}
+	return key, nil
+}
+
+// expandKeyTemplates adds the templated fields of model that can be built from filter to a copy of filter, and
+// returns the constant prefix of those that can't, see readPage
+func expandKeyTemplates(model any, filter map[string]any) (map[string]any, map[string]string) {
+	fields, err := templateFields(model)
+	if err != nil || len(fields) == 0 {
+		return filter, nil
+	}
+	expanded := maps.Clone(filter)
+	if expanded == nil {
+		expanded = map[string]any{}
+	}
+	prefixes := map[string]string{}
+	for _, f := range fields {
+		if _, ok := filter[f.Key]; ok {
+			continue
+		}
+		if rendered, ok := renderKeyTemplate(keyTemplate(f), filter); ok {
+			expanded[f.Key] = rendered
+		} else if prefix := keyTemplatePrefix(keyTemplate(f)); prefix != "" {
+			prefixes[f.Key] = prefix
+		}
+	}
+	return expanded, prefixes
+}
+
+// DynamoDBEntities maps the entity types stored in a table shared by several models to their Go types, so the items
+// of a single-table design can be read together with ListEntities. Every model must tag the same field with
+// magic:"type" and have the same TableName, see TableNamer
+type DynamoDBEntities struct {
+	models  []any
+	types   map[string]reflect.Type
+	typeKey string
+}
+
+// NewDynamoDBEntities returns the entity mapping of models, which are struct values or pointers
+func NewDynamoDBEntities(models ...any) (*DynamoDBEntities, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("at least one model is required")
+	}
+	e := &DynamoDBEntities{types: map[string]reflect.Type{}}
+	tableName := modelTableName(models[0], "", "")
+	for _, model := range models {
+		f, err := entityTypeField(model)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, fmt.Errorf("%T has no entity type, tag a string field with magic:\"type\"", model)
+		}
+		if e.typeKey != "" && f.Key != e.typeKey {
+			return nil, fmt.Errorf("%T stores its entity type in %s instead of %s", model, f.Key, e.typeKey)
+		}
+		if name := modelTableName(model, "", ""); name != tableName {
+			return nil, fmt.Errorf("%T is stored in %s instead of %s", model, name, tableName)
+		}
+		entity := entityType(model)
+		if _, ok := e.types[entity]; ok {
+			return nil, fmt.Errorf("entity type %s is used by more than one model", entity)
+		}
+		e.typeKey = f.Key
+		e.types[entity] = reflect.TypeOf(modelInstance(model)).Elem()
+		e.models = append(e.models, modelInstance(model))
+	}
+	return e, nil
+}
+
+// ListEntities reads a page of the items of entities matching filter and decodes each of them into a pointer to the
+// Go type of its entity type, e.g. an *Order or an *Invoice. The items of other entity types, expired items and,
+// unless params include INCLUDE_DELETED, soft deleted items are left out. Like List it queries the table or an index
+// when filter covers a partition key, templated keys included, and only scans the table when scans are allowed
+func (s *DynamoDBAdapter) ListEntities(ctx context.Context, entities *DynamoDBEntities, filter map[string]any, limit int, cursor string, params ...map[string]any) ([]any, string, error) {
+	// Keep the templated keys and prefixes all entity types agree on
+	model := entities.models[0]
+	expanded, prefixes := expandKeyTemplates(model, filter)
+	for _, other := range entities.models[1:] {
+		otherFilter, otherPrefixes := expandKeyTemplates(other, filter)
+		for key, value := range expanded {
+			if otherValue, ok := otherFilter[key]; !ok || !reflect.DeepEqual(value, otherValue) {
+				delete(expanded, key)
+			}
+		}
+		for key, prefix := range prefixes {
+			if otherPrefixes[key] != prefix {
+				delete(prefixes, key)
+			}
+		}
+	}
+
+	filter = expanded
+	fields := map[string]bool{}
+	for key, value := range filter {
+		fields[key] = isDynamoDBKeyValue(value)
+	}
+	q, err := findKeyQuery(model, fields, "")
+	if err != nil && !s.allowScan(params...) {
+		return nil, "", err
+	}
+	if q == nil && !s.allowScan(params...) {
+		return nil, "", fmt.Errorf("failed to list %s: %w", s.getTableName(model), ErrScanNotAllowed)
+	}
+
+	entityTypes := make([]any, 0, len(entities.types))
+	for entity := range entities.types {
+		entityTypes = append(entityTypes, entity)
+	}
+	sort.Slice(entityTypes, func(i, j int) bool { return entityTypes[i].(string) < entityTypes[j].(string) })
+	condition, names, values, err := s.buildFilterExpression(map[string]any{entities.typeKey: entityTypes})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build filter expression: %v", err)
+	}
+	// Rename the placeholders so they don't collide with those of filter
+	condition = strings.NewReplacer("#f0", "#entity_type", ":f0_", ":entity_type_").Replace(condition)
+	names = map[string]string{"#entity_type": entities.typeKey}
+	for placeholder, value := range values {
+		delete(values, placeholder)
+		values[strings.Replace(placeholder, ":f0_", ":entity_type_", 1)] = value
+	}
+
+	var next string
+	results, err := retryResult(ctx, s.retrier, true, func() ([]any, error) {
+		items, lastKey, err := s.readPage(ctx, s.getTableName(model), q, filter, prefixes, condition, names, values, limit, cursor)
+		if err != nil {
+			return nil, err
+		}
+		next = lastKey
+		results := []any{}
+		for _, item := range items {
+			entity, ok := item[entities.typeKey].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			t, ok := entities.types[entity.Value]
+			if !ok {
+				continue
+			}
+			result := reflect.New(t).Interface()
+			if live, err := isLiveDynamoDBItem(item, result, params...); err != nil || !live {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = attributevalue.UnmarshalMapWithOptions(item, result, func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" })
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal %s item, %v", entity.Value, err)
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return results, next, nil
+}
+
+// isLiveDynamoDBItem reports whether item, read for model, is neither expired nor, unless params include
+// INCLUDE_DELETED, soft deleted
+func isLiveDynamoDBItem(item map[string]types.AttributeValue, model any, params ...map[string]any) (bool, error) {
+	live, err := liveItemsField(model, params...)
+	if err != nil {
+		return false, err
+	}
+	expiry, err := expiryField(model)
+	if err != nil {
+		return false, err
+	}
+	return !(live != nil && isDynamoDBSet(item[live.Key])) && !(expiry != nil && isDynamoDBExpired(item[expiry.Key])), nil
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type entityOrder struct {
+	PK     string `json:"PK" magic:"pk,template=TENANT#{tenant}"`
+	SK     string `json:"SK" magic:"sk,template=ORDER#{id}"`
+	Type   string `json:"type" magic:"type"`
+	Tenant string `json:"tenant"`
+	ID     string `json:"id"`
+	Total  int    `json:"total"`
+}
+
+func (entityOrder) TableName() string  { return "shop" }
+func (entityOrder) EntityType() string { return "order" }
+
+type entityInvoice struct {
+	PK     string `json:"PK" magic:"pk,template=TENANT#{tenant}"`
+	SK     string `json:"SK" magic:"sk,template=INVOICE#{id}"`
+	Type   string `json:"type" magic:"type"`
+	Tenant string `json:"tenant"`
+	ID     string `json:"id"`
+	Paid   bool   `json:"paid"`
+}
+
+func (entityInvoice) TableName() string  { return "shop" }
+func (entityInvoice) EntityType() string { return "invoice" }
+
+// entityProfile has a single item per tenant, its sort key is a constant
+type entityProfile struct {
+	PK     string `json:"PK" magic:"pk,template=TENANT#{tenant}"`
+	SK     string `json:"SK" magic:"sk,template=PROFILE"`
+	Type   string `json:"type" magic:"type"`
+	Tenant string `json:"tenant"`
+}
+
+func (entityProfile) TableName() string { return "shop" }
+
+type entityLegacyOrder struct {
+	Type string `json:"type" magic:"type"`
+	ID   string `json:"id" magic:"pk"`
+}
+
+func (entityLegacyOrder) TableName() string  { return "legacy_orders" }
+func (entityLegacyOrder) EntityType() string { return "legacy_order" }
+
+func TestExpandKeyTemplates(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        any
+		filter       map[string]any
+		want         map[string]any
+		wantPrefixes map[string]string
+	}{
+		{
+			name:         "every placeholder set",
+			model:        &entityOrder{},
+			filter:       map[string]any{"tenant": "acme", "id": "1"},
+			want:         map[string]any{"tenant": "acme", "id": "1", "PK": "TENANT#acme", "SK": "ORDER#1"},
+			wantPrefixes: map[string]string{},
+		},
+		{
+			name:         "missing placeholder narrows down to the prefix",
+			model:        &entityOrder{},
+			filter:       map[string]any{"tenant": "acme"},
+			want:         map[string]any{"tenant": "acme", "PK": "TENANT#acme"},
+			wantPrefixes: map[string]string{"SK": "ORDER#"},
+		},
+		{
+			name:         "non scalar values aren't rendered",
+			model:        &entityOrder{},
+			filter:       map[string]any{"tenant": []string{"acme", "globex"}, "id": "1"},
+			want:         map[string]any{"tenant": []string{"acme", "globex"}, "id": "1", "SK": "ORDER#1"},
+			wantPrefixes: map[string]string{"PK": "TENANT#"},
+		},
+		{
+			name:         "keys given by the filter are kept",
+			model:        &entityOrder{},
+			filter:       map[string]any{"PK": "TENANT#globex", "tenant": "acme"},
+			want:         map[string]any{"PK": "TENANT#globex", "tenant": "acme"},
+			wantPrefixes: map[string]string{"SK": "ORDER#"},
+		},
+		{
+			name:         "literal only sort key",
+			model:        &entityProfile{},
+			filter:       map[string]any{},
+			want:         map[string]any{"SK": "PROFILE"},
+			wantPrefixes: map[string]string{"PK": "TENANT#"},
+		},
+		{
+			name:   "model without templates",
+			model:  &dynamoTask{},
+			filter: map[string]any{"tenant": "acme"},
+			want:   map[string]any{"tenant": "acme"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := len(tt.filter)
+			got, prefixes := expandKeyTemplates(tt.model, tt.filter)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandKeyTemplates() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(prefixes, tt.wantPrefixes) {
+				t.Errorf("expandKeyTemplates() prefixes = %v, want %v", prefixes, tt.wantPrefixes)
+			}
+			if len(tt.filter) != original {
+				t.Errorf("expandKeyTemplates() modified the filter, got %v", tt.filter)
+			}
+		})
+	}
+}
+
+func TestDynamoDBKeyFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   any
+		filter  map[string]any
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name:   "keys built from the fields of their template",
+			model:  &entityOrder{},
+			filter: map[string]any{"tenant": "acme", "id": "1", "total": 5},
+			want:   map[string]any{"PK": "TENANT#acme", "SK": "ORDER#1"},
+		},
+		{
+			name:   "keys given as they are",
+			model:  &entityOrder{},
+			filter: map[string]any{"PK": "TENANT#acme", "SK": "ORDER#1"},
+			want:   map[string]any{"PK": "TENANT#acme", "SK": "ORDER#1"},
+		},
+		{
+			name:   "literal only sort key",
+			model:  &entityProfile{},
+			filter: map[string]any{"tenant": "acme"},
+			want:   map[string]any{"PK": "TENANT#acme", "SK": "PROFILE"},
+		},
+		{
+			name:    "missing template field",
+			model:   &entityOrder{},
+			filter:  map[string]any{"tenant": "acme"},
+			wantErr: "the fields of template 'ORDER#{id}' are required",
+		},
+		{
+			name:   "model without templates",
+			model:  &dynamoTask{},
+			filter: map[string]any{"tenant": "acme", "name": "paint"},
+			want:   map[string]any{"tenant": "acme", "name": "paint"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dynamoDBKeyFilter(tt.model, tt.filter)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dynamoDBKeyFilter() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dynamoDBKeyFilter() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dynamoDBKeyFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynamoDBListNarrowsTemplatedSortKeys(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string { return `{"Items": []}` })
+
+	var orders []entityOrder
+	if _, err := adapter.ListContext(context.Background(), &orders, "", map[string]any{"tenant": "acme"}, 10, ""); err != nil {
+		t.Fatalf("ListContext() error: %v", err)
+	}
+	request := (*requests)[0]
+	values, _ := request.Input["ExpressionAttributeValues"].(map[string]any)
+	if request.Operation != "Query" || request.Input["KeyConditionExpression"] != "#pk = :pk AND begins_with(#sk, :sk)" {
+		t.Fatalf("sent %s %v, want a Query on the partition key and the sort key prefix", request.Operation, request.Input)
+	}
+	want := map[string]any{":pk": map[string]any{"S": "TENANT#acme"}, ":sk": map[string]any{"S": "ORDER#"}}
+	for placeholder, value := range want {
+		if !reflect.DeepEqual(values[placeholder], value) {
+			t.Errorf("ExpressionAttributeValues[%s] = %v, want %v", placeholder, values[placeholder], value)
+		}
+	}
+}
+
+func TestNewDynamoDBEntities(t *testing.T) {
+	tests := []struct {
+		name    string
+		models  []any
+		wantErr string
+	}{
+		{name: "models sharing a table", models: []any{entityOrder{}, &entityInvoice{}, &entityProfile{}}},
+		{name: "no models", wantErr: "at least one model is required"},
+		{name: "model without a type field", models: []any{&entityOrder{}, &dynamoTask{}}, wantErr: "has no entity type"},
+		{name: "models in different tables", models: []any{&entityOrder{}, &entityLegacyOrder{}}, wantErr: "is stored in legacy_orders instead of shop"},
+		{name: "duplicate entity types", models: []any{&entityOrder{}, entityOrder{}}, wantErr: "entity type order is used by more than one model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDynamoDBEntities(tt.models...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("NewDynamoDBEntities() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewDynamoDBEntities() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDynamoDBListEntities(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string {
+		return `{
+			"Items": [
+				{"PK": {"S": "TENANT#acme"}, "SK": {"S": "INVOICE#1"}, "type": {"S": "invoice"}, "tenant": {"S": "acme"}, "id": {"S": "1"}, "paid": {"BOOL": true}},
+				{"PK": {"S": "TENANT#acme"}, "SK": {"S": "NOTE#1"}, "type": {"S": "note"}, "tenant": {"S": "acme"}},
+				{"PK": {"S": "TENANT#acme"}, "SK": {"S": "ORDER#1"}, "type": {"S": "order"}, "tenant": {"S": "acme"}, "id": {"S": "1"}, "total": {"N": "42"}},
+				{"PK": {"S": "TENANT#acme"}, "SK": {"S": "UNTYPED"}, "tenant": {"S": "acme"}}
+			],
+			"LastEvaluatedKey": {"PK": {"S": "TENANT#acme"}, "SK": {"S": "UNTYPED"}}
+		}`
+	})
+	entities, err := NewDynamoDBEntities(&entityOrder{}, &entityInvoice{})
+	if err != nil {
+		t.Fatalf("NewDynamoDBEntities() error: %v", err)
+	}
+
+	items, cursor, err := adapter.ListEntities(context.Background(), entities, map[string]any{"tenant": "acme"}, 10, "")
+	if err != nil {
+		t.Fatalf("ListEntities() error: %v", err)
+	}
+	want := []any{
+		&entityInvoice{PK: "TENANT#acme", SK: "INVOICE#1", Type: "invoice", Tenant: "acme", ID: "1", Paid: true},
+		&entityOrder{PK: "TENANT#acme", SK: "ORDER#1", Type: "order", Tenant: "acme", ID: "1", Total: 42},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("ListEntities() = %+v, want %+v", items, want)
+	}
+	if cursor == "" {
+		t.Errorf("ListEntities() cursor is empty, want the last evaluated key")
+	}
+
+	// The sort key prefixes of orders and invoices differ, so only the partition key is queried
+	request := (*requests)[0]
+	if request.Operation != "Query" || request.Input["KeyConditionExpression"] != "#pk = :pk" {
+		t.Fatalf("sent %s %v, want a Query on the partition key", request.Operation, request.Input)
+	}
+	if filter := request.Input["FilterExpression"]; filter != "(#f0 = :f0) AND (#entity_type IN (:entity_type_0, :entity_type_1))" {
+		t.Errorf("FilterExpression = %v, want the entity types", filter)
+	}
+
+	// Without a partition key the whole table would be scanned
+	if _, _, err := adapter.ListEntities(context.Background(), entities, map[string]any{"id": "1"}, 10, ""); !errors.Is(err, ErrScanNotAllowed) {
+		t.Errorf("ListEntities() without a partition key error = %v, want ErrScanNotAllowed", err)
+	}
+}
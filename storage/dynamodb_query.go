@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+// DYNAMODB_ALLOW_SCAN is the params key allowing List, Search and Count to scan the whole table when their filter or
+// query doesn't cover the partition key of the table or of one of its indexes, e.g.
+// adapter.List(&tasks, "", filter, 10, "", map[string]any{storage.DYNAMODB_ALLOW_SCAN: true}). Setting allow_scan
+// to true in the adapter config allows scans on every call
+const DYNAMODB_ALLOW_SCAN = "allow_scan"
+
+// ErrScanNotAllowed is returned by List, Search and Count on DynamoDB when they would have to scan the whole table
+var ErrScanNotAllowed = errors.New("the request doesn't filter on a partition key and would scan the whole table")
+
+// dynamoDBKeyQuery is the table or index a read is served from and the order of its results
+type dynamoDBKeyQuery struct {
+	IndexName string
+	Key       dynamoDBKeySchema
+	Forward   bool
+}
+
+// allowScan reports whether reads may scan the whole table, the DYNAMODB_ALLOW_SCAN param overrides the
+// allow_scan config
+func (s *DynamoDBAdapter) allowScan(params ...map[string]any) bool {
+	allowed, _ := strconv.ParseBool(s.config["allow_scan"])
+	for _, p := range params {
+		if v, ok := p[DYNAMODB_ALLOW_SCAN]; ok {
+			allowed = v == true || v == "true"
+		}
+	}
+	return allowed
+}
+
+// findKeyQuery returns the table or index whose partition key is one of fields and whose sort key can order the
+// results by sortKey, preferring the table, then local and then global secondary indexes, and among those one
+// whose sort key is also one of fields. It returns nil when fields cover no partition key
+func findKeyQuery(model any, fields map[string]bool, sortKey string) (*dynamoDBKeyQuery, error) {
+	schema, err := getDynamoDBTableSchema(model)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []dynamoDBKeyQuery{{Key: schema.Key}}
+	for _, indexes := range []map[string]dynamoDBKeySchema{schema.LSIs, schema.GSIs} {
+		for _, name := range sortedIndexNames(indexes) {
+			candidates = append(candidates, dynamoDBKeyQuery{IndexName: name, Key: indexes[name]})
+		}
+	}
+
+	var found *dynamoDBKeyQuery
+	unsortable := ""
+	for _, candidate := range candidates {
+		if !fields[candidate.Key.PartitionKey] {
+			continue
+		}
+		// Results share the partition key so sorting by it has no effect
+		order := []sortField{}
+		for _, f := range parseSortSpec(sortKey) {
+			if f.Name != candidate.Key.PartitionKey {
+				order = append(order, f)
+			}
+		}
+		candidate.Forward = true
+		if len(order) > 1 || (len(order) == 1 && order[0].Name != candidate.Key.SortKey) {
+			unsortable = candidate.Key.PartitionKey
+			continue
+		}
+		if len(order) == 1 {
+			candidate.Forward = !order[0].Desc
+		}
+		if found == nil || (!fields[found.Key.SortKey] && fields[candidate.Key.SortKey]) {
+			found = &candidate
+		}
+	}
+	if found == nil && unsortable != "" {
+		return nil, &serviceErrors.BadRequest{
+			Message: fmt.Sprintf("can't sort by '%s', results filtered on %s can only be sorted by the sort key of the table or of an index", sortKey, unsortable),
+		}
+	}
+	return found, nil
+}
+
+// isDynamoDBKeyValue reports whether a filter value can be matched with a key condition
+func isDynamoDBKeyValue(value any) bool {
+	if value == nil {
+		return false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return false
+	}
+	return true
+}
+
+// dynamoDBKeyValues converts the key values of a search to the type of their model field, so "id:42" matches the
+// string key "42" of a model whose id is a string
+func dynamoDBKeyValues(model any, keys map[string]any) (map[string]any, error) {
+	converted := map[string]any{}
+	for _, f := range getModelMetadata(model).Fields {
+		value, ok := keys[f.Key]
+		if !ok {
+			continue
+		}
+		t := f.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.String:
+			converted[f.Key] = fmt.Sprint(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			number, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+			if err != nil {
+				return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("%s must be a number", f.Key)}
+			}
+			converted[f.Key] = number
+		default:
+			converted[f.Key] = value
+		}
+	}
+	return converted, nil
+}
+
+// queryItems reads a page of the items matching filter and condition with a Query on the table or index of q, or
+// with a Scan when q is nil. prefixes holds the prefix of key attributes built from a template that filter doesn't
+// have all the fields of, see expandKeyTemplates
+func (s *DynamoDBAdapter) queryItems(
+	ctx context.Context,
+	dest any,
+	q *dynamoDBKeyQuery,
+	filter map[string]any,
+	prefixes map[string]string,
+	condition string,
+	conditionNames map[string]string,
+	conditionValues map[string]types.AttributeValue,
+	limit int,
+	cursor string,
+	params ...map[string]any,
+) (string, error) {
+	live, liveNames, liveValues, err := liveItemsExpression(dest, params...)
+	if err != nil {
+		return "", err
+	}
+	condition, names, values := joinConditions(condition, conditionNames, conditionValues, live, liveNames, liveValues)
+	items, next, err := s.readPage(ctx, s.getTableName(dest), q, filter, prefixes, condition, names, values, limit, cursor)
+	if err != nil {
+		return "", err
+	}
+	err = attributevalue.UnmarshalListOfMapsWithOptions(
+		items,
+		dest,
+		func(eo *attributevalue.DecoderOptions) { eo.TagKey = "json" },
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return next, nil
+}
+
+// joinConditions joins two condition expressions with AND, along with their attribute names and values
+func joinConditions(
+	a string, aNames map[string]string, aValues map[string]types.AttributeValue,
+	b string, bNames map[string]string, bValues map[string]types.AttributeValue,
+) (string, map[string]string, map[string]types.AttributeValue) {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	maps.Copy(names, aNames)
+	maps.Copy(names, bNames)
+	maps.Copy(values, aValues)
+	maps.Copy(values, bValues)
+	switch {
+	case a == "":
+		return b, names, values
+	case b == "":
+		return a, names, values
+	}
+	return fmt.Sprintf("(%s) AND (%s)", a, b), names, values
+}
+
+// dynamoDBReadExpressions are the expressions of a Query or Scan reading the items of a table matching a filter
+type dynamoDBReadExpressions struct {
+	KeyCondition *string
+	Filter       *string
+	Names        map[string]string
+	Values       map[string]types.AttributeValue
+}
+
+// queryInput returns the input of a Query on the table or index of q
+func (e *dynamoDBReadExpressions) queryInput(tableName string, q *dynamoDBKeyQuery) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		ScanIndexForward:          aws.Bool(q.Forward),
+		KeyConditionExpression:    e.KeyCondition,
+		FilterExpression:          e.Filter,
+		ExpressionAttributeNames:  e.Names,
+		ExpressionAttributeValues: e.Values,
+	}
+	if q.IndexName != "" {
+		input.IndexName = aws.String(q.IndexName)
+	}
+	return input
+}
+
+// scanInput returns the input of a Scan of the whole table
+func (e *dynamoDBReadExpressions) scanInput(tableName string) *dynamodb.ScanInput {
+	return &dynamodb.ScanInput{
+		TableName:                 aws.String(tableName),
+		FilterExpression:          e.Filter,
+		ExpressionAttributeNames:  e.Names,
+		ExpressionAttributeValues: e.Values,
+	}
+}
+
+// buildReadExpressions builds the expressions reading the items matching filter and condition. The partition key of
+// q, and its sort key when filter has a value for it or prefixes a prefix, form the key condition and the rest of
+// filter the filter expression. q is nil for scans
+func (s *DynamoDBAdapter) buildReadExpressions(
+	q *dynamoDBKeyQuery,
+	filter map[string]any,
+	prefixes map[string]string,
+	condition string,
+	conditionNames map[string]string,
+	conditionValues map[string]types.AttributeValue,
+) (*dynamoDBReadExpressions, error) {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	rest := maps.Clone(filter)
+	expressions := &dynamoDBReadExpressions{}
+	if q != nil {
+		pk, err := attributevalue.Marshal(filter[q.Key.PartitionKey])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal partition key, %v", err)
+		}
+		names["#pk"] = q.Key.PartitionKey
+		values[":pk"] = pk
+		keyCondition := "#pk = :pk"
+		delete(rest, q.Key.PartitionKey)
+
+		if value, ok := filter[q.Key.SortKey]; ok && q.Key.SortKey != "" && isDynamoDBKeyValue(value) {
+			sk, err := attributevalue.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal sort key, %v", err)
+			}
+			names["#sk"] = q.Key.SortKey
+			values[":sk"] = sk
+			keyCondition += " AND #sk = :sk"
+			delete(rest, q.Key.SortKey)
+		} else if prefix := prefixes[q.Key.SortKey]; q.Key.SortKey != "" && prefix != "" {
+			names["#sk"] = q.Key.SortKey
+			values[":sk"] = &types.AttributeValueMemberS{Value: prefix}
+			keyCondition += " AND begins_with(#sk, :sk)"
+		}
+		expressions.KeyCondition = aws.String(keyCondition)
+	}
+
+	expression, filterNames, filterValues, err := s.buildFilterExpression(rest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build filter expression: %v", err)
+	}
+	expression, filterNames, filterValues = joinConditions(expression, filterNames, filterValues, condition, conditionNames, conditionValues)
+	maps.Copy(names, filterNames)
+	maps.Copy(values, filterValues)
+	if len(names) > 0 {
+		expressions.Names = names
+	}
+	if len(values) > 0 {
+		expressions.Values = values
+	}
+	if expression != "" {
+		expressions.Filter = aws.String(expression)
+	}
+	return expressions, nil
+}
+
+// readPage reads a page of the items of a table matching filter and condition, with a Query on the table or index
+// of q or with a Scan when q is nil
+func (s *DynamoDBAdapter) readPage(
+	ctx context.Context,
+	tableName string,
+	q *dynamoDBKeyQuery,
+	filter map[string]any,
+	prefixes map[string]string,
+	condition string,
+	conditionNames map[string]string,
+	conditionValues map[string]types.AttributeValue,
+	limit int,
+	cursor string,
+) ([]map[string]types.AttributeValue, string, error) {
+	expressions, err := s.buildReadExpressions(q, filter, prefixes, condition, conditionNames, conditionValues)
+	if err != nil {
+		return nil, "", err
+	}
+	index := ""
+	if q != nil {
+		index = q.IndexName
+	}
+
+	var startKey map[string]types.AttributeValue
+	if cursor != "" {
+		if startKey, err = decodeDynamoDBCursor(cursor, index); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	if q != nil {
+		input := expressions.queryInput(tableName, q)
+		input.Limit = aws.Int32(int32(limit))
+		input.ExclusiveStartKey = startKey
+		response, err := s.DB.Query(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query items, %w", err)
+		}
+		items, lastKey = response.Items, response.LastEvaluatedKey
+	} else {
+		input := expressions.scanInput(tableName)
+		input.Limit = aws.Int32(int32(limit))
+		input.ExclusiveStartKey = startKey
+		response, err := s.DB.Scan(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan items, %w", err)
+		}
+		items, lastKey = response.Items, response.LastEvaluatedKey
+	}
+
+	next, err := encodeDynamoDBCursor(lastKey, index)
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// dynamoDBCursor is the decoded form of the cursors returned by queries, the last evaluated key of a page
+type dynamoDBCursor struct {
+	Index string                         `json:"i,omitempty"`
+	Key   map[string]dynamoDBCursorValue `json:"k"`
+}
+
+// dynamoDBCursorValue is a key attribute, B values are base64 encoded
+type dynamoDBCursorValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+func encodeDynamoDBCursor(key map[string]types.AttributeValue, index string) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	cursor := dynamoDBCursor{Index: index, Key: map[string]dynamoDBCursorValue{}}
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch v := key[name].(type) {
+		case *types.AttributeValueMemberS:
+			cursor.Key[name] = dynamoDBCursorValue{Type: "S", Value: v.Value}
+		case *types.AttributeValueMemberN:
+			cursor.Key[name] = dynamoDBCursorValue{Type: "N", Value: v.Value}
+		case *types.AttributeValueMemberB:
+			cursor.Key[name] = dynamoDBCursorValue{Type: "B", Value: base64.StdEncoding.EncodeToString(v.Value)}
+		default:
+			return "", fmt.Errorf("failed to encode cursor: unsupported key attribute %s", name)
+		}
+	}
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeDynamoDBCursor(encoded string, index string) (map[string]types.AttributeValue, error) {
+	var cursor dynamoDBCursor
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	if err != nil || len(cursor.Key) == 0 {
+		return nil, &serviceErrors.BadRequest{Message: "invalid cursor"}
+	}
+	if cursor.Index != index {
+		return nil, &serviceErrors.BadRequest{Message: "the cursor was created for a different query and can't be used with this one"}
+	}
+
+	key := map[string]types.AttributeValue{}
+	for name, v := range cursor.Key {
+		switch v.Type {
+		case "S":
+			key[name] = &types.AttributeValueMemberS{Value: v.Value}
+		case "N":
+			key[name] = &types.AttributeValueMemberN{Value: v.Value}
+		case "B":
+			value, err := base64.StdEncoding.DecodeString(v.Value)
+			if err != nil {
+				return nil, &serviceErrors.BadRequest{Message: "invalid cursor"}
+			}
+			key[name] = &types.AttributeValueMemberB{Value: value}
+		default:
+			return nil, &serviceErrors.BadRequest{Message: "invalid cursor"}
+		}
+	}
+	return key, nil
+}
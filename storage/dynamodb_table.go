@@ -0,0 +1,513 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// DYNAMODB_DEFAULT_CAPACITY is the read and write capacity of provisioned tables and indexes when none is given
+	DYNAMODB_DEFAULT_CAPACITY = 5
+	// DYNAMODB_TABLE_WAIT_TIMEOUT bounds how long EnsureTable waits for a table and its indexes to become active
+	DYNAMODB_TABLE_WAIT_TIMEOUT = 10 * time.Minute
+)
+
+// dynamoDBTablePollInterval is how often EnsureTable checks whether a table is active
+var dynamoDBTablePollInterval = 2 * time.Second
+
+// DynamoDBTableOptions controls how EnsureTable provisions a table
+type DynamoDBTableOptions struct {
+	// BillingMode is PAY_PER_REQUEST (on-demand) when empty
+	BillingMode types.BillingMode
+	// ReadCapacity and WriteCapacity apply to the table and its global secondary indexes with PROVISIONED billing,
+	// both default to DYNAMODB_DEFAULT_CAPACITY
+	ReadCapacity  int64
+	WriteCapacity int64
+	// EnableTTL turns on DynamoDB TTL for the field tagged magic:"expires_at"
+	EnableTTL bool
+	// StreamViewType enables a stream with this view type, streams are left as they are when it's empty
+	StreamViewType types.StreamViewType
+}
+
+// dynamoDBKeySchema is a partition key and an optional sort key
+type dynamoDBKeySchema struct {
+	PartitionKey string
+	SortKey      string
+}
+
+func (k dynamoDBKeySchema) elements() []types.KeySchemaElement {
+	elements := []types.KeySchemaElement{{AttributeName: aws.String(k.PartitionKey), KeyType: types.KeyTypeHash}}
+	if k.SortKey != "" {
+		elements = append(elements, types.KeySchemaElement{AttributeName: aws.String(k.SortKey), KeyType: types.KeyTypeRange})
+	}
+	return elements
+}
+
+// dynamoDBTableSchema is the key schema and indexes of a model's table, as declared by its magic tags
+type dynamoDBTableSchema struct {
+	Key        dynamoDBKeySchema
+	GSIs       map[string]dynamoDBKeySchema
+	LSIs       map[string]dynamoDBKeySchema
+	Attributes map[string]types.ScalarAttributeType
+}
+
+// getDynamoDBTableSchema reads the table schema of model from its magic tags:
+//
+//	type Task struct {
+//	    Tenant    string `json:"tenant" magic:"pk"`
+//	    ID        string `json:"id" magic:"sk"`
+//	    Owner     string `json:"owner" magic:"gsi=by_owner"`
+//	    Status    string `json:"status" magic:"gsi_sk=by_owner"`
+//	    CreatedAt string `json:"created_at" magic:"lsi=by_created_at"`
+//	}
+//
+// pk and sk are the keys of the table, gsi and gsi_sk the partition and sort keys of global secondary indexes and lsi
+// the sort key of local secondary indexes, which share the partition key of the table. A field may belong to several
+// indexes, e.g. magic:"gsi=by_owner,gsi_sk=by_status", but each key is tagged on a single field and an index can't be
+// both global and local. Models without a pk field use their id field as partition key
+func getDynamoDBTableSchema(model any) (*dynamoDBTableSchema, error) {
+	schema := &dynamoDBTableSchema{
+		GSIs:       map[string]dynamoDBKeySchema{},
+		LSIs:       map[string]dynamoDBKeySchema{},
+		Attributes: map[string]types.ScalarAttributeType{},
+	}
+	addAttribute := func(name string, t reflect.Type) error {
+		attributeType, err := dynamoDBAttributeType(t)
+		if err != nil {
+			return fmt.Errorf("invalid key attribute %s: %v", name, err)
+		}
+		schema.Attributes[name] = attributeType
+		return nil
+	}
+
+	// setKey sets a key attribute of the schema, a key is declared by a single field
+	setKey := func(key *string, attribute string, description string) error {
+		if *key != "" && *key != attribute {
+			return fmt.Errorf("%s of %T is declared by both %s and %s", description, model, *key, attribute)
+		}
+		*key = attribute
+		return nil
+	}
+
+	for _, f := range getModelMetadata(model).Fields {
+		if f.has("pk") && f.has("sk") {
+			return nil, fmt.Errorf("%s of %T can't be both the partition key and the sort key", f.Key, model)
+		}
+		if f.has("pk") {
+			if err := setKey(&schema.Key.PartitionKey, f.Key, "the partition key"); err != nil {
+				return nil, err
+			}
+		}
+		if f.has("sk") {
+			if err := setKey(&schema.Key.SortKey, f.Key, "the sort key"); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range f.Options["gsi"] {
+			index := schema.GSIs[name]
+			if err := setKey(&index.PartitionKey, f.Key, fmt.Sprintf("the partition key of global secondary index '%s'", name)); err != nil {
+				return nil, err
+			}
+			schema.GSIs[name] = index
+		}
+		for _, name := range f.Options["gsi_sk"] {
+			index := schema.GSIs[name]
+			if err := setKey(&index.SortKey, f.Key, fmt.Sprintf("the sort key of global secondary index '%s'", name)); err != nil {
+				return nil, err
+			}
+			schema.GSIs[name] = index
+		}
+		for _, name := range f.Options["lsi"] {
+			index := schema.LSIs[name]
+			if err := setKey(&index.SortKey, f.Key, fmt.Sprintf("the sort key of local secondary index '%s'", name)); err != nil {
+				return nil, err
+			}
+			schema.LSIs[name] = index
+		}
+		if f.has("pk") || f.has("sk") || f.has("gsi") || f.has("gsi_sk") || f.has("lsi") {
+			if err := addAttribute(f.Key, f.Type); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if schema.Key.PartitionKey == "" {
+		key, t := idField(model)
+		if key == "" {
+			return nil, fmt.Errorf("%T has no partition key, tag one with magic:\"pk\" or name it id", model)
+		}
+		schema.Key.PartitionKey = key
+		if err := addAttribute(key, t); err != nil {
+			return nil, err
+		}
+	}
+	for name, index := range schema.GSIs {
+		if _, ok := schema.LSIs[name]; ok {
+			return nil, fmt.Errorf("index '%s' of %T is declared as both a global and a local secondary index", name, model)
+		}
+		if name == "" || index.PartitionKey == "" {
+			return nil, fmt.Errorf("global secondary index '%s' of %T has no partition key, tag one with magic:\"gsi=%s\"", name, model, name)
+		}
+	}
+	for name, index := range schema.LSIs {
+		if name == "" || schema.Key.SortKey == "" {
+			return nil, fmt.Errorf("local secondary index '%s' of %T requires a table with a sort key", name, model)
+		}
+		index.PartitionKey = schema.Key.PartitionKey
+		schema.LSIs[name] = index
+	}
+	return schema, nil
+}
+
+// idField returns the json name and type of the field named id of model, descending into embedded structs
+func idField(model any) (string, reflect.Type) {
+	t := reflect.TypeOf(model)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", nil
+	}
+	var find func(t reflect.Type) (string, reflect.Type)
+	find = func(t reflect.Type) (string, reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if key, fieldType := find(field.Type); key != "" {
+					return key, fieldType
+				}
+				continue
+			}
+			key := field.Name
+			if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" && jsonName != "-" {
+				key = jsonName
+			}
+			if field.IsExported() && strings.EqualFold(key, "id") {
+				return key, field.Type
+			}
+		}
+		return "", nil
+	}
+	return find(t)
+}
+
+// dynamoDBAttributeType returns the scalar attribute type values of t are stored as
+func dynamoDBAttributeType(t reflect.Type) (types.ScalarAttributeType, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return types.ScalarAttributeTypeS, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return types.ScalarAttributeTypeN, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return types.ScalarAttributeTypeB, nil
+		}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return types.ScalarAttributeTypeS, nil
+		}
+	}
+	return "", fmt.Errorf("keys must be strings, numbers or binary, got %s", t)
+}
+
+// EnsureTable creates the table of model from the key schema and indexes declared by its magic tags, see
+// getDynamoDBTableSchema, and waits for it to become active. Existing tables get the global secondary indexes, billing
+// mode and stream they are missing, their key schema and local secondary indexes can't be changed and must match.
+// With opts.EnableTTL DynamoDB TTL is turned on for the field tagged magic:"expires_at"
+func (s *DynamoDBAdapter) EnsureTable(ctx context.Context, model any, opts DynamoDBTableOptions) error {
+	schema, err := getDynamoDBTableSchema(model)
+	if err != nil {
+		return err
+	}
+	if opts.BillingMode == "" {
+		opts.BillingMode = types.BillingModePayPerRequest
+	}
+	if opts.ReadCapacity <= 0 {
+		opts.ReadCapacity = DYNAMODB_DEFAULT_CAPACITY
+	}
+	if opts.WriteCapacity <= 0 {
+		opts.WriteCapacity = DYNAMODB_DEFAULT_CAPACITY
+	}
+	var expiry *modelField
+	if opts.EnableTTL {
+		if expiry, err = requireExpiryField(model); err != nil {
+			return err
+		}
+	}
+
+	tableName := s.getTableName(model)
+	ctx, cancel := context.WithTimeout(ctx, DYNAMODB_TABLE_WAIT_TIMEOUT)
+	defer cancel()
+
+	table, err := s.describeTable(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	if table == nil {
+		if err := s.createTable(ctx, tableName, schema, opts); err != nil {
+			return err
+		}
+	} else if err := s.updateTable(ctx, table, schema, opts); err != nil {
+		return err
+	}
+
+	if expiry != nil {
+		return s.enableTTL(ctx, tableName, expiry.Key)
+	}
+	return nil
+}
+
+// describeTable returns the description of a table, or nil if it doesn't exist
+func (s *DynamoDBAdapter) describeTable(ctx context.Context, tableName string) (*types.TableDescription, error) {
+	response, err := s.DB.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table %s, %w", tableName, err)
+	}
+	return response.Table, nil
+}
+
+func (s *DynamoDBAdapter) createTable(ctx context.Context, tableName string, schema *dynamoDBTableSchema, opts DynamoDBTableOptions) error {
+	input := &dynamodb.CreateTableInput{
+		TableName:            aws.String(tableName),
+		KeySchema:            schema.Key.elements(),
+		AttributeDefinitions: schema.attributeDefinitions(),
+		BillingMode:          opts.BillingMode,
+	}
+	if opts.BillingMode == types.BillingModeProvisioned {
+		input.ProvisionedThroughput = opts.throughput()
+	}
+	for _, name := range sortedIndexNames(schema.GSIs) {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, schema.globalSecondaryIndex(name, opts))
+	}
+	for _, name := range sortedIndexNames(schema.LSIs) {
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+			IndexName:  aws.String(name),
+			KeySchema:  schema.LSIs[name].elements(),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		})
+	}
+	if opts.StreamViewType != "" {
+		input.StreamSpecification = &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: opts.StreamViewType}
+	}
+
+	_, err := s.DB.CreateTable(ctx, input)
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("failed to create table %s, %w", tableName, err)
+	}
+	return s.waitForTable(ctx, tableName)
+}
+
+func (s *DynamoDBAdapter) updateTable(ctx context.Context, table *types.TableDescription, schema *dynamoDBTableSchema, opts DynamoDBTableOptions) error {
+	tableName := aws.ToString(table.TableName)
+	if !sameKeySchema(table.KeySchema, schema.Key) {
+		return fmt.Errorf("the key schema of table %s doesn't match its model, it can't be changed once a table is created", tableName)
+	}
+	existingLSIs := map[string]bool{}
+	for _, index := range table.LocalSecondaryIndexes {
+		existingLSIs[aws.ToString(index.IndexName)] = true
+	}
+	for name := range schema.LSIs {
+		if !existingLSIs[name] {
+			return fmt.Errorf("table %s has no local secondary index %s, local secondary indexes can only be created with the table", tableName, name)
+		}
+	}
+	if err := s.waitForTable(ctx, tableName); err != nil {
+		return err
+	}
+
+	billingMode := types.BillingModeProvisioned
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != "" {
+		billingMode = table.BillingModeSummary.BillingMode
+	}
+	existingGSIs := map[string]bool{}
+	for _, index := range table.GlobalSecondaryIndexes {
+		existingGSIs[aws.ToString(index.IndexName)] = true
+	}
+
+	if billingMode != opts.BillingMode {
+		input := &dynamodb.UpdateTableInput{TableName: table.TableName, BillingMode: opts.BillingMode}
+		if opts.BillingMode == types.BillingModeProvisioned {
+			input.ProvisionedThroughput = opts.throughput()
+			for _, index := range table.GlobalSecondaryIndexes {
+				input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, types.GlobalSecondaryIndexUpdate{
+					Update: &types.UpdateGlobalSecondaryIndexAction{IndexName: index.IndexName, ProvisionedThroughput: opts.throughput()},
+				})
+			}
+		}
+		if err := s.updateTableAndWait(ctx, input); err != nil {
+			return err
+		}
+	}
+
+	// DynamoDB creates a single global secondary index per UpdateTable call
+	for _, name := range sortedIndexNames(schema.GSIs) {
+		if existingGSIs[name] {
+			continue
+		}
+		index := schema.globalSecondaryIndex(name, opts)
+		err := s.updateTableAndWait(ctx, &dynamodb.UpdateTableInput{
+			TableName:            table.TableName,
+			AttributeDefinitions: schema.attributeDefinitions(schema.GSIs[name]),
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName:             index.IndexName,
+					KeySchema:             index.KeySchema,
+					Projection:            index.Projection,
+					ProvisionedThroughput: index.ProvisionedThroughput,
+				},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.StreamViewType != "" {
+		stream := table.StreamSpecification
+		if stream == nil || !aws.ToBool(stream.StreamEnabled) {
+			return s.updateTableAndWait(ctx, &dynamodb.UpdateTableInput{
+				TableName:           table.TableName,
+				StreamSpecification: &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: opts.StreamViewType},
+			})
+		}
+		if stream.StreamViewType != opts.StreamViewType {
+			return fmt.Errorf("table %s already has a %s stream, disable it to change its view type", tableName, stream.StreamViewType)
+		}
+	}
+	return nil
+}
+
+func (s *DynamoDBAdapter) updateTableAndWait(ctx context.Context, input *dynamodb.UpdateTableInput) error {
+	if _, err := s.DB.UpdateTable(ctx, input); err != nil {
+		return fmt.Errorf("failed to update table %s, %w", aws.ToString(input.TableName), err)
+	}
+	return s.waitForTable(ctx, aws.ToString(input.TableName))
+}
+
+// waitForTable waits for a table and all of its global secondary indexes to become active
+func (s *DynamoDBAdapter) waitForTable(ctx context.Context, tableName string) error {
+	for {
+		table, err := s.describeTable(ctx, tableName)
+		if err != nil {
+			return err
+		}
+		if table != nil && table.TableStatus == types.TableStatusActive {
+			active := true
+			for _, index := range table.GlobalSecondaryIndexes {
+				active = active && index.IndexStatus == types.IndexStatusActive
+			}
+			if active {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("table %s didn't become active, %w", tableName, ctx.Err())
+		case <-time.After(dynamoDBTablePollInterval):
+		}
+	}
+}
+
+// enableTTL turns on TTL for attribute unless it already is
+func (s *DynamoDBAdapter) enableTTL(ctx context.Context, tableName string, attribute string) error {
+	response, err := s.DB.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("failed to describe the TTL of table %s, %w", tableName, err)
+	}
+	if ttl := response.TimeToLiveDescription; ttl != nil {
+		switch ttl.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if aws.ToString(ttl.AttributeName) != attribute {
+				return fmt.Errorf("table %s already has TTL enabled on %s", tableName, aws.ToString(ttl.AttributeName))
+			}
+			return nil
+		}
+	}
+	_, err = s.DB.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName:               aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: aws.String(attribute), Enabled: aws.Bool(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL on table %s, %w", tableName, err)
+	}
+	return nil
+}
+
+func (o DynamoDBTableOptions) throughput() *types.ProvisionedThroughput {
+	return &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(o.ReadCapacity), WriteCapacityUnits: aws.Int64(o.WriteCapacity)}
+}
+
+// attributeDefinitions returns the definitions of the key attributes of keys, or of all key attributes when keys is
+// empty
+func (t *dynamoDBTableSchema) attributeDefinitions(keys ...dynamoDBKeySchema) []types.AttributeDefinition {
+	names := []string{}
+	for name := range t.Attributes {
+		used := len(keys) == 0
+		for _, key := range keys {
+			used = used || name == key.PartitionKey || name == key.SortKey
+		}
+		if used {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	definitions := make([]types.AttributeDefinition, 0, len(names))
+	for _, name := range names {
+		definitions = append(definitions, types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: t.Attributes[name]})
+	}
+	return definitions
+}
+
+func (t *dynamoDBTableSchema) globalSecondaryIndex(name string, opts DynamoDBTableOptions) types.GlobalSecondaryIndex {
+	index := types.GlobalSecondaryIndex{
+		IndexName:  aws.String(name),
+		KeySchema:  t.GSIs[name].elements(),
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+	if opts.BillingMode == types.BillingModeProvisioned {
+		index.ProvisionedThroughput = opts.throughput()
+	}
+	return index
+}
+
+func sortedIndexNames(indexes map[string]dynamoDBKeySchema) []string {
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sameKeySchema reports whether elements describe key
+func sameKeySchema(elements []types.KeySchemaElement, key dynamoDBKeySchema) bool {
+	var partitionKey, sortKey string
+	for _, e := range elements {
+		switch e.KeyType {
+		case types.KeyTypeHash:
+			partitionKey = aws.ToString(e.AttributeName)
+		case types.KeyTypeRange:
+			sortKey = aws.ToString(e.AttributeName)
+		}
+	}
+	return partitionKey == key.PartitionKey && sortKey == key.SortKey
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type tableTask struct {
+	Tenant    string     `json:"tenant" magic:"pk"`
+	ID        string     `json:"id" magic:"sk"`
+	Owner     string     `json:"owner" magic:"gsi=by_owner"`
+	Status    string     `json:"status" magic:"gsi_sk=by_owner,gsi=by_status"`
+	Size      int        `json:"size" magic:"gsi_sk=by_status"`
+	CreatedAt time.Time  `json:"created_at" magic:"lsi=by_created_at"`
+	ExpiresAt *time.Time `json:"expires_at" magic:"expires_at"`
+}
+
+func (tableTask) TableName() string { return "tasks" }
+
+type tableBase struct {
+	ID int64 `json:"id"`
+}
+
+type tableNote struct {
+	tableBase
+	Text string `json:"text"`
+}
+
+func TestGetDynamoDBTableSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   any
+		want    *dynamoDBTableSchema
+		wantErr string
+	}{
+		{
+			name:  "keys and indexes from tags",
+			model: &tableTask{},
+			want: &dynamoDBTableSchema{
+				Key: dynamoDBKeySchema{PartitionKey: "tenant", SortKey: "id"},
+				GSIs: map[string]dynamoDBKeySchema{
+					"by_owner":  {PartitionKey: "owner", SortKey: "status"},
+					"by_status": {PartitionKey: "status", SortKey: "size"},
+				},
+				LSIs: map[string]dynamoDBKeySchema{"by_created_at": {PartitionKey: "tenant", SortKey: "created_at"}},
+				Attributes: map[string]types.ScalarAttributeType{
+					"tenant": types.ScalarAttributeTypeS, "id": types.ScalarAttributeTypeS, "owner": types.ScalarAttributeTypeS,
+					"status": types.ScalarAttributeTypeS, "size": types.ScalarAttributeTypeN, "created_at": types.ScalarAttributeTypeS,
+				},
+			},
+		},
+		{
+			name:  "id field as partition key",
+			model: &tableNote{},
+			want: &dynamoDBTableSchema{
+				Key:        dynamoDBKeySchema{PartitionKey: "id"},
+				GSIs:       map[string]dynamoDBKeySchema{},
+				LSIs:       map[string]dynamoDBKeySchema{},
+				Attributes: map[string]types.ScalarAttributeType{"id": types.ScalarAttributeTypeN},
+			},
+		},
+		{
+			name:    "no partition key",
+			model:   &struct{ Name string }{},
+			wantErr: "has no partition key",
+		},
+		{
+			name: "duplicate partition key",
+			model: &struct {
+				A string `json:"a" magic:"pk"`
+				B string `json:"b" magic:"pk"`
+			}{},
+			wantErr: "the partition key of *struct",
+		},
+		{
+			name: "duplicate sort key",
+			model: &struct {
+				ID string `json:"id" magic:"pk"`
+				A  string `json:"a" magic:"sk"`
+				B  string `json:"b" magic:"sk"`
+			}{},
+			wantErr: "is declared by both a and b",
+		},
+		{
+			name: "partition and sort key on the same field",
+			model: &struct {
+				ID string `json:"id" magic:"pk,sk"`
+			}{},
+			wantErr: "id of *struct",
+		},
+		{
+			name: "duplicate global secondary index partition key",
+			model: &struct {
+				ID string `json:"id"`
+				A  string `json:"a" magic:"gsi=by_a"`
+				B  string `json:"b" magic:"gsi=by_a"`
+			}{},
+			wantErr: "the partition key of global secondary index 'by_a'",
+		},
+		{
+			name: "duplicate local secondary index sort key",
+			model: &struct {
+				ID string `json:"id" magic:"pk"`
+				SK string `json:"sk" magic:"sk"`
+				A  string `json:"a" magic:"lsi=by_a"`
+				B  string `json:"b" magic:"lsi=by_a"`
+			}{},
+			wantErr: "the sort key of local secondary index 'by_a'",
+		},
+		{
+			name: "index both global and local",
+			model: &struct {
+				ID string `json:"id" magic:"pk"`
+				SK string `json:"sk" magic:"sk"`
+				A  string `json:"a" magic:"gsi=by_a,lsi=by_a"`
+			}{},
+			wantErr: "is declared as both a global and a local secondary index",
+		},
+		{
+			name: "global secondary index without partition key",
+			model: &struct {
+				ID string `json:"id"`
+				A  string `json:"a" magic:"gsi_sk=by_a"`
+			}{},
+			wantErr: "global secondary index 'by_a'",
+		},
+		{
+			name: "local secondary index without table sort key",
+			model: &struct {
+				ID string `json:"id"`
+				A  string `json:"a" magic:"lsi=by_a"`
+			}{},
+			wantErr: "requires a table with a sort key",
+		},
+		{
+			name: "key of unsupported type",
+			model: &struct {
+				ID   string `json:"id"`
+				Done bool   `json:"done" magic:"gsi=by_done"`
+			}{},
+			wantErr: "invalid key attribute done",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getDynamoDBTableSchema(tt.model)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getDynamoDBTableSchema() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getDynamoDBTableSchema() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDynamoDBTableSchema() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestTableAdapter returns a fake DynamoDB adapter whose DescribeTable calls return table, or a not found error when
+// table is empty, and whose DescribeTimeToLive calls return ttl. Tables are polled without waiting
+func newTestTableAdapter(t *testing.T, table string, ttl string) (*DynamoDBAdapter, *[]dynamoDBRequest) {
+	t.Helper()
+	pollInterval := dynamoDBTablePollInterval
+	dynamoDBTablePollInterval = time.Millisecond
+	t.Cleanup(func() { dynamoDBTablePollInterval = pollInterval })
+
+	created := false
+	return newTestDynamoDBAdapter(t, func(operation string) string {
+		switch operation {
+		case "DescribeTable":
+			if table == "" && !created {
+				return `{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", "message": "not found"}`
+			}
+			if table == "" {
+				return `{"Table": {"TableName": "tasks", "TableStatus": "ACTIVE"}}`
+			}
+			return `{"Table": ` + table + `}`
+		case "CreateTable":
+			created = true
+		case "DescribeTimeToLive":
+			return ttl
+		}
+		return `{}`
+	})
+}
+
+// requestsOf returns the inputs of the requests of operation
+func requestsOf(requests []dynamoDBRequest, operation string) []map[string]any {
+	inputs := []map[string]any{}
+	for _, request := range requests {
+		if request.Operation == operation {
+			inputs = append(inputs, request.Input)
+		}
+	}
+	return inputs
+}
+
+// assertJSONInput fails t unless input is the JSON object want
+func assertJSONInput(t *testing.T, operation string, input map[string]any, want string) {
+	t.Helper()
+	var wantInput map[string]any
+	if err := json.Unmarshal([]byte(want), &wantInput); err != nil {
+		t.Fatalf("invalid expected %s input: %v", operation, err)
+	}
+	if !reflect.DeepEqual(input, wantInput) {
+		got, _ := json.Marshal(input)
+		t.Errorf("%s input = %s, want %s", operation, got, want)
+	}
+}
+
+func TestDynamoDBEnsureTableCreatesTable(t *testing.T) {
+	adapter, requests := newTestTableAdapter(t, "", `{"TimeToLiveDescription": {"TimeToLiveStatus": "DISABLED"}}`)
+
+	err := adapter.EnsureTable(context.Background(), &tableTask{}, DynamoDBTableOptions{
+		BillingMode:    types.BillingModeProvisioned,
+		ReadCapacity:   10,
+		EnableTTL:      true,
+		StreamViewType: types.StreamViewTypeNewImage,
+	})
+	if err != nil {
+		t.Fatalf("EnsureTable() error: %v", err)
+	}
+
+	creates := requestsOf(*requests, "CreateTable")
+	if len(creates) != 1 {
+		t.Fatalf("sent %d CreateTable requests, want 1", len(creates))
+	}
+	throughput := `{"ReadCapacityUnits": 10, "WriteCapacityUnits": 5}`
+	assertJSONInput(t, "CreateTable", creates[0], `{
+		"TableName": "tasks",
+		"BillingMode": "PROVISIONED",
+		"ProvisionedThroughput": `+throughput+`,
+		"KeySchema": [{"AttributeName": "tenant", "KeyType": "HASH"}, {"AttributeName": "id", "KeyType": "RANGE"}],
+		"AttributeDefinitions": [
+			{"AttributeName": "created_at", "AttributeType": "S"},
+			{"AttributeName": "id", "AttributeType": "S"},
+			{"AttributeName": "owner", "AttributeType": "S"},
+			{"AttributeName": "size", "AttributeType": "N"},
+			{"AttributeName": "status", "AttributeType": "S"},
+			{"AttributeName": "tenant", "AttributeType": "S"}
+		],
+		"GlobalSecondaryIndexes": [
+			{
+				"IndexName": "by_owner",
+				"KeySchema": [{"AttributeName": "owner", "KeyType": "HASH"}, {"AttributeName": "status", "KeyType": "RANGE"}],
+				"Projection": {"ProjectionType": "ALL"},
+				"ProvisionedThroughput": `+throughput+`
+			},
+			{
+				"IndexName": "by_status",
+				"KeySchema": [{"AttributeName": "status", "KeyType": "HASH"}, {"AttributeName": "size", "KeyType": "RANGE"}],
+				"Projection": {"ProjectionType": "ALL"},
+				"ProvisionedThroughput": `+throughput+`
+			}
+		],
+		"LocalSecondaryIndexes": [
+			{
+				"IndexName": "by_created_at",
+				"KeySchema": [{"AttributeName": "tenant", "KeyType": "HASH"}, {"AttributeName": "created_at", "KeyType": "RANGE"}],
+				"Projection": {"ProjectionType": "ALL"}
+			}
+		],
+		"StreamSpecification": {"StreamEnabled": true, "StreamViewType": "NEW_IMAGE"}
+	}`)
+
+	ttl := requestsOf(*requests, "UpdateTimeToLive")
+	if len(ttl) != 1 {
+		t.Fatalf("sent %d UpdateTimeToLive requests, want 1", len(ttl))
+	}
+	assertJSONInput(t, "UpdateTimeToLive", ttl[0], `{
+		"TableName": "tasks",
+		"TimeToLiveSpecification": {"AttributeName": "expires_at", "Enabled": true}
+	}`)
+}
+
+// tasksTable returns the description of a tasks table created on demand with the by_owner index only, after change
+// modifies it
+func tasksTable(change func(table map[string]any)) string {
+	table := map[string]any{
+		"TableName":              "tasks",
+		"TableStatus":            "ACTIVE",
+		"KeySchema":              []any{map[string]any{"AttributeName": "tenant", "KeyType": "HASH"}, map[string]any{"AttributeName": "id", "KeyType": "RANGE"}},
+		"BillingModeSummary":     map[string]any{"BillingMode": "PAY_PER_REQUEST"},
+		"GlobalSecondaryIndexes": []any{map[string]any{"IndexName": "by_owner", "IndexStatus": "ACTIVE"}},
+		"LocalSecondaryIndexes":  []any{map[string]any{"IndexName": "by_created_at"}},
+	}
+	if change != nil {
+		change(table)
+	}
+	description, _ := json.Marshal(table)
+	return string(description)
+}
+
+// withStream returns a change adding a stream of viewType to a table description
+func withStream(viewType string) func(table map[string]any) {
+	return func(table map[string]any) {
+		table["StreamSpecification"] = map[string]any{"StreamEnabled": true, "StreamViewType": viewType}
+	}
+}
+
+func TestDynamoDBEnsureTableUpdatesTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    DynamoDBTableOptions
+		table   string
+		ttl     string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "missing global secondary index",
+			table: tasksTable(nil),
+			want: []string{`{
+				"TableName": "tasks",
+				"AttributeDefinitions": [{"AttributeName": "size", "AttributeType": "N"}, {"AttributeName": "status", "AttributeType": "S"}],
+				"GlobalSecondaryIndexUpdates": [{"Create": {
+					"IndexName": "by_status",
+					"KeySchema": [{"AttributeName": "status", "KeyType": "HASH"}, {"AttributeName": "size", "KeyType": "RANGE"}],
+					"Projection": {"ProjectionType": "ALL"}
+				}}]
+			}`},
+		},
+		{
+			name: "billing mode and stream",
+			opts: DynamoDBTableOptions{BillingMode: types.BillingModeProvisioned, StreamViewType: types.StreamViewTypeKeysOnly},
+			table: tasksTable(func(table map[string]any) {
+				table["GlobalSecondaryIndexes"] = []any{map[string]any{"IndexName": "by_owner", "IndexStatus": "ACTIVE"}, map[string]any{"IndexName": "by_status", "IndexStatus": "ACTIVE"}}
+			}),
+			want: []string{
+				`{
+					"TableName": "tasks",
+					"BillingMode": "PROVISIONED",
+					"ProvisionedThroughput": {"ReadCapacityUnits": 5, "WriteCapacityUnits": 5},
+					"GlobalSecondaryIndexUpdates": [
+						{"Update": {"IndexName": "by_owner", "ProvisionedThroughput": {"ReadCapacityUnits": 5, "WriteCapacityUnits": 5}}},
+						{"Update": {"IndexName": "by_status", "ProvisionedThroughput": {"ReadCapacityUnits": 5, "WriteCapacityUnits": 5}}}
+					]
+				}`,
+				`{"TableName": "tasks", "StreamSpecification": {"StreamEnabled": true, "StreamViewType": "KEYS_ONLY"}}`,
+			},
+		},
+		{
+			name:  "TTL already enabled",
+			opts:  DynamoDBTableOptions{EnableTTL: true, StreamViewType: types.StreamViewTypeNewImage},
+			table: tasksTable(withStream("NEW_IMAGE")),
+			ttl:   `{"TimeToLiveDescription": {"TimeToLiveStatus": "ENABLED", "AttributeName": "expires_at"}}`,
+			want: []string{`{
+				"TableName": "tasks",
+				"AttributeDefinitions": [{"AttributeName": "size", "AttributeType": "N"}, {"AttributeName": "status", "AttributeType": "S"}],
+				"GlobalSecondaryIndexUpdates": [{"Create": {
+					"IndexName": "by_status",
+					"KeySchema": [{"AttributeName": "status", "KeyType": "HASH"}, {"AttributeName": "size", "KeyType": "RANGE"}],
+					"Projection": {"ProjectionType": "ALL"}
+				}}]
+			}`},
+		},
+		{
+			name: "different key schema",
+			table: tasksTable(func(table map[string]any) {
+				table["KeySchema"] = []any{map[string]any{"AttributeName": "tenant", "KeyType": "HASH"}}
+			}),
+			wantErr: "the key schema of table tasks doesn't match its model",
+		},
+		{
+			name: "missing local secondary index",
+			table: tasksTable(func(table map[string]any) {
+				delete(table, "LocalSecondaryIndexes")
+			}),
+			wantErr: "table tasks has no local secondary index by_created_at",
+		},
+		{
+			name:    "stream with another view type",
+			opts:    DynamoDBTableOptions{StreamViewType: types.StreamViewTypeNewImage},
+			table:   tasksTable(withStream("KEYS_ONLY")),
+			wantErr: "table tasks already has a KEYS_ONLY stream",
+		},
+		{
+			name:    "TTL enabled on another attribute",
+			opts:    DynamoDBTableOptions{EnableTTL: true},
+			table:   tasksTable(nil),
+			ttl:     `{"TimeToLiveDescription": {"TimeToLiveStatus": "ENABLED", "AttributeName": "deleted_at"}}`,
+			wantErr: "table tasks already has TTL enabled on deleted_at",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter, requests := newTestTableAdapter(t, tt.table, tt.ttl)
+			err := adapter.EnsureTable(context.Background(), &tableTask{}, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EnsureTable() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EnsureTable() error: %v", err)
+			}
+			if creates := requestsOf(*requests, "CreateTable"); len(creates) != 0 {
+				t.Errorf("sent %d CreateTable requests for an existing table", len(creates))
+			}
+			if ttl := requestsOf(*requests, "UpdateTimeToLive"); len(ttl) != 0 {
+				t.Errorf("sent %d UpdateTimeToLive requests, want none", len(ttl))
+			}
+			updates := requestsOf(*requests, "UpdateTable")
+			if len(updates) != len(tt.want) {
+				t.Fatalf("sent %d UpdateTable requests, want %d", len(updates), len(tt.want))
+			}
+			for i, want := range tt.want {
+				assertJSONInput(t, "UpdateTable", updates[i], want)
+			}
+		})
+	}
+}
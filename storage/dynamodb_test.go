@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+// dynamoDBRequest is a request received by the fake DynamoDB endpoint of newTestDynamoDBAdapter
+type dynamoDBRequest struct {
+	Operation string
+	Input     map[string]any
+}
+
+// newTestDynamoDBAdapter returns an adapter talking to a fake endpoint that records each request and answers it with
+// the response respond returns for its operation, e.g. "Query". Responses with a __type are sent as errors
+func newTestDynamoDBAdapter(t *testing.T, respond func(operation string) string) (*DynamoDBAdapter, *[]dynamoDBRequest) {
+	t.Helper()
+	requests := []dynamoDBRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := dynamoDBRequest{Operation: strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")}
+		if err := json.Unmarshal(body, &request.Input); err != nil {
+			t.Errorf("invalid request body %s: %v", body, err)
+		}
+		requests = append(requests, request)
+		response := respond(request.Operation)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if strings.Contains(response, `"__type"`) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+
+	adapter, err := NewDynamoDBAdapter(map[string]string{
+		"endpoint":   server.URL,
+		"region":     "us-east-1",
+		"access_key": "test",
+		"secret_key": "test",
+	})
+	if err != nil {
+		t.Fatalf("NewDynamoDBAdapter() error: %v", err)
+	}
+	return adapter, &requests
+}
+
+type dynamoTask struct {
+	Tenant string `json:"tenant" magic:"pk"`
+	ID     string `json:"id" magic:"sk"`
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+}
+
+func (dynamoTask) TableName() string { return "tasks" }
+
+func TestDynamoDBCountQueriesByKey(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string { return `{"Count": 3}` })
+
+	count, err := adapter.CountContext(context.Background(), &dynamoTask{}, map[string]any{"tenant": "acme", "name": "paint"})
+	if err != nil {
+		t.Fatalf("CountContext() error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("CountContext() = %d, want 3", count)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(*requests))
+	}
+	request := (*requests)[0]
+	if request.Operation != "Query" || request.Input["Select"] != "COUNT" || request.Input["KeyConditionExpression"] != "#pk = :pk" {
+		t.Errorf("sent %s %v, want a COUNT Query on the partition key", request.Operation, request.Input)
+	}
+
+	// Counting without a partition key would scan the whole table
+	_, err = adapter.CountContext(context.Background(), &dynamoTask{}, map[string]any{"name": "paint"})
+	if !errors.Is(err, ErrScanNotAllowed) {
+		t.Errorf("CountContext() error = %v, want ErrScanNotAllowed", err)
+	}
+	_, err = adapter.CountContext(context.Background(), &dynamoTask{}, map[string]any{"name": "paint"}, map[string]any{DYNAMODB_ALLOW_SCAN: true})
+	if err != nil || (*requests)[len(*requests)-1].Operation != "Scan" {
+		t.Errorf("CountContext() with scans allowed error = %v, want a Scan", err)
+	}
+}
+
+func TestDynamoDBSearchQueriesByKey(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string {
+		return `{
+			"Items": [{"tenant": {"S": "acme"}, "id": {"S": "1"}, "name": {"S": "paint walls"}, "size": {"N": "2"}}],
+			"LastEvaluatedKey": {"tenant": {"S": "acme"}, "id": {"S": "1"}}
+		}`
+	})
+
+	var tasks []dynamoTask
+	cursor, err := adapter.SearchContext(context.Background(), &tasks, "-id", "tenant:acme AND name:paint* AND size:[1 TO 5]", 10, "")
+	if err != nil {
+		t.Fatalf("SearchContext() error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != "paint walls" || tasks[0].Size != 2 {
+		t.Errorf("SearchContext() = %+v, want the item returned by the query", tasks)
+	}
+	request := (*requests)[0]
+	if request.Operation != "Query" || request.Input["KeyConditionExpression"] != "#pk = :pk" || request.Input["ScanIndexForward"] != false {
+		t.Fatalf("sent %s %v, want a descending Query on the partition key", request.Operation, request.Input)
+	}
+	filter, _ := request.Input["FilterExpression"].(string)
+	if filter != "(begins_with(#s0, :s0)) AND (#s1 BETWEEN :s1 AND :s2)" {
+		t.Errorf("FilterExpression = %q, want the rest of the query", filter)
+	}
+
+	// The cursor is the same as the one of List and resumes the query
+	if _, err := adapter.SearchContext(context.Background(), &tasks, "-id", "tenant:acme", 10, cursor); err != nil {
+		t.Fatalf("SearchContext() with cursor error: %v", err)
+	}
+	startKey, _ := (*requests)[1].Input["ExclusiveStartKey"].(map[string]any)
+	if len(startKey) != 2 {
+		t.Errorf("ExclusiveStartKey = %v, want the last evaluated key of the first page", startKey)
+	}
+
+	// Searching without a partition key would scan the whole table
+	_, err = adapter.SearchContext(context.Background(), &tasks, "", "name:paint*", 10, "")
+	if !errors.Is(err, ErrScanNotAllowed) {
+		t.Errorf("SearchContext() error = %v, want ErrScanNotAllowed", err)
+	}
+}
+
+func TestDynamoDBListScansWithFilterExpressions(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string {
+		return `{"Items": [{"tenant": {"S": "acme"}, "id": {"S": "1"}, "name": {"S": "paint"}, "size": {"N": "2"}}]}`
+	})
+	allowScan := map[string]any{DYNAMODB_ALLOW_SCAN: true}
+
+	var tasks []dynamoTask
+	_, err := adapter.ListContext(context.Background(), &tasks, "", map[string]any{"size": 2, "name": "paint"}, 10, "", allowScan)
+	if err != nil {
+		t.Fatalf("ListContext() error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != "paint" {
+		t.Errorf("ListContext() = %+v, want the item returned by the scan", tasks)
+	}
+	request := (*requests)[0]
+	if request.Operation != "Scan" || request.Input["FilterExpression"] != "#f0 = :f0 AND #f1 = :f1" {
+		t.Fatalf("sent %s %v, want a Scan with a filter expression", request.Operation, request.Input)
+	}
+	names, _ := request.Input["ExpressionAttributeNames"].(map[string]any)
+	values, _ := request.Input["ExpressionAttributeValues"].(map[string]any)
+	if names["#f0"] != "name" || names["#f1"] != "size" || fmt.Sprint(values[":f0"]) != "map[S:paint]" || fmt.Sprint(values[":f1"]) != "map[N:2]" {
+		t.Errorf("sent names %v and values %v, want each value bound to its own attribute", names, values)
+	}
+
+	// Scans can't be sorted
+	_, err = adapter.ListContext(context.Background(), &tasks, "name", map[string]any{"name": "paint"}, 10, "", allowScan)
+	var badRequest *serviceErrors.BadRequest
+	if !errors.As(err, &badRequest) || len(*requests) != 1 {
+		t.Errorf("ListContext() of a sorted scan error = %v, want a BadRequest without sending a request", err)
+	}
+}
+
+type dynamoExpiringTask struct {
+	Tenant    string     `json:"tenant" magic:"pk"`
+	ID        string     `json:"id" magic:"sk"`
+	ExpiresAt *time.Time `json:"expires_at" magic:"expires_at"`
+}
+
+func (dynamoExpiringTask) TableName() string { return "tasks" }
+
+func TestDynamoDBPatchStoresExpiryAsEpochSeconds(t *testing.T) {
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string {
+		return `{"Attributes": {"tenant": {"S": "acme"}, "id": {"S": "1"}, "expires_at": {"N": "1798761600"}}}`
+	})
+	expiresAt := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The expiry field is named by its Go name, it's still written to the TTL attribute
+	var task dynamoExpiringTask
+	err := adapter.Patch(context.Background(), &task, map[string]any{"tenant": "acme", "id": "1"}, map[string]any{"ExpiresAt": expiresAt})
+	if err != nil {
+		t.Fatalf("Patch() error: %v", err)
+	}
+	request := (*requests)[0]
+	names, _ := request.Input["ExpressionAttributeNames"].(map[string]any)
+	values, _ := request.Input["ExpressionAttributeValues"].(map[string]any)
+	if request.Operation != "UpdateItem" || request.Input["UpdateExpression"] != "SET #p0 = :p0" {
+		t.Fatalf("sent %s %v, want an UpdateItem setting one attribute", request.Operation, request.Input)
+	}
+	if names["#p0"] != "expires_at" || fmt.Sprint(values[":p0"]) != "map[N:1798761600]" {
+		t.Errorf("sent names %v and values %v, want expires_at set to the epoch seconds of the expiry time", names, values)
+	}
+	if task.ExpiresAt == nil || !task.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("patched item = %+v, want the stored expiry time", task)
+	}
+}
+
+type dynamoVersionedTask struct {
+	Tenant    string     `json:"tenant" magic:"pk"`
+	ID        string     `json:"id" magic:"sk"`
+	Version   int64      `json:"version" magic:"version"`
+	DeletedAt *time.Time `json:"deleted_at" magic:"deleted_at"`
+}
+
+func (dynamoVersionedTask) TableName() string { return "tasks" }
+
+func TestDynamoDBTransactionMapsFailedConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		failed int
+		want   error
+	}{
+		{name: "create of an existing item", failed: 0, want: ErrAlreadyExists},
+		{name: "update of a stale version", failed: 1, want: ErrConflict},
+		{name: "delete of a missing item", failed: 2, want: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := []string{`{"Code": "None"}`, `{"Code": "None"}`, `{"Code": "None"}`}
+			reasons[tt.failed] = `{"Code": "ConditionalCheckFailed", "Message": "The conditional request failed"}`
+			adapter, _ := newTestDynamoDBAdapter(t, func(string) string {
+				return `{
+					"__type": "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+					"Message": "Transaction cancelled",
+					"CancellationReasons": [` + strings.Join(reasons, ",") + `]
+				}`
+			})
+
+			err := adapter.WithTransaction(context.Background(), func(tx StorageAdapter) error {
+				if err := tx.Create(&dynamoVersionedTask{Tenant: "acme", ID: "1"}); err != nil {
+					return err
+				}
+				if err := tx.Update(&dynamoVersionedTask{Tenant: "acme", ID: "2", Version: 3}, map[string]any{"tenant": "acme", "id": "2"}); err != nil {
+					return err
+				}
+				return tx.Delete(&dynamoVersionedTask{}, map[string]any{"tenant": "acme", "id": "3"})
+			})
+			for _, other := range []error{ErrAlreadyExists, ErrConflict, ErrNotFound} {
+				if errors.Is(err, other) != (other == tt.want) {
+					t.Fatalf("WithTransaction() error = %v, want %v", err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,14 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+
+	"github.com/tink3rlabs/magic/logger"
 )
 
 var memoryAdapterLock = &sync.Mutex{}
@@ -11,32 +18,72 @@ type MemoryAdapter struct {
 }
 
 var memoryAdapterInstance *MemoryAdapter
+var memoryDatabaseCount atomic.Int64
 
+// GetMemoryAdapterInstance returns the process-wide MemoryAdapter, exiting the process if it can't be created
 func GetMemoryAdapterInstance() *MemoryAdapter {
+	m, err := getMemoryAdapterInstance()
+	if err != nil {
+		logger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+	return m
+}
+
+func getMemoryAdapterInstance() (*MemoryAdapter, error) {
+	memoryAdapterLock.Lock()
+	defer memoryAdapterLock.Unlock()
 	if memoryAdapterInstance == nil {
-		memoryAdapterLock.Lock()
-		defer memoryAdapterLock.Unlock()
-		if memoryAdapterInstance == nil {
-			// Memory adapter simply uses the SQLAdapter without persistance to disk
-			// The SQLITE database will just be stored in memory and not written to a file
-			config := map[string]string{
-				"provider": "sqlite",
-			}
-			db := GetSQLAdapterInstance(config)
-			memoryAdapterInstance = &MemoryAdapter{DB: db}
+		m, err := NewMemoryAdapter()
+		if err != nil {
+			return nil, err
 		}
+		memoryAdapterInstance = m
 	}
-	return memoryAdapterInstance
+	return memoryAdapterInstance, nil
+}
+
+// NewMemoryAdapter returns a MemoryAdapter backed by a new, empty database that isn't shared with any
+// other instance, which makes it suitable for isolated tests
+func NewMemoryAdapter() (*MemoryAdapter, error) {
+	// Memory adapter simply uses the SQLAdapter without persistance to disk
+	// The SQLITE database will just be stored in memory and not written to a file
+	config := map[string]string{
+		"provider": "sqlite",
+		"path":     fmt.Sprintf("file:magic-memory-%d?mode=memory&cache=shared", memoryDatabaseCount.Add(1)),
+	}
+	db, err := NewSQLAdapter(config)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryAdapter{DB: db}, nil
+}
+
+// Stats returns the connection pool statistics of the underlying database
+func (m *MemoryAdapter) Stats() (sql.DBStats, error) {
+	return m.DB.Stats()
+}
+
+// Close closes the underlying database, discarding all of its data
+func (m *MemoryAdapter) Close() error {
+	return m.DB.Close()
 }
 
 func (m *MemoryAdapter) Execute(s string) error {
 	return m.DB.Execute(s)
 }
 
+func (m *MemoryAdapter) ExecuteContext(ctx context.Context, s string) error {
+	return m.DB.ExecuteContext(ctx, s)
+}
+
 func (m *MemoryAdapter) Ping() error {
 	return m.DB.Ping()
 }
 
+func (m *MemoryAdapter) PingContext(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
 func (m *MemoryAdapter) GetType() StorageAdapterType {
 	return MEMORY
 }
@@ -77,35 +124,105 @@ func (m *MemoryAdapter) GetLatestMigration() (int, error) {
 }
 
 func (m *MemoryAdapter) Create(item any, params ...map[string]any) error {
-	return m.DB.Create(item)
+	return m.DB.Create(item, params...)
+}
+
+func (m *MemoryAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return m.DB.CreateContext(ctx, item, params...)
 }
 
 func (m *MemoryAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
-	return m.DB.Get(dest, filter)
+	return m.DB.Get(dest, filter, params...)
+}
+
+func (m *MemoryAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return m.DB.GetContext(ctx, dest, filter, params...)
 }
 
 func (m *MemoryAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
-	return m.DB.Update(item, filter)
+	return m.DB.Update(item, filter, params...)
+}
+
+func (m *MemoryAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return m.DB.UpdateContext(ctx, item, filter, params...)
+}
+
+func (m *MemoryAdapter) storedFieldName(model any, goName string) (string, bool) {
+	return m.DB.storedFieldName(model, goName)
 }
 
 func (m *MemoryAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
-	return m.DB.Delete(item, filter)
+	return m.DB.Delete(item, filter, params...)
+}
+
+func (m *MemoryAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return m.DB.DeleteContext(ctx, item, filter, params...)
 }
 
 func (m *MemoryAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
-	return m.DB.List(dest, sortKey, filter, limit, cursor)
+	return m.DB.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (m *MemoryAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return m.DB.ListContext(ctx, dest, sortKey, filter, limit, cursor, params...)
 }
 
 func (m *MemoryAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return m.DB.Search(dest, sortKey, query, limit, cursor)
+	return m.DB.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (m *MemoryAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return m.DB.SearchContext(ctx, dest, sortKey, query, limit, cursor, params...)
 }
 
 func (m *MemoryAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
 	var total int64
-	total, err := m.DB.Count(dest, filter)
+	total, err := m.DB.Count(dest, filter, params...)
 	return total, err
 }
 
+func (m *MemoryAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return m.DB.CountContext(ctx, dest, filter, params...)
+}
+
 func (m *MemoryAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return m.DB.Query(dest, statement, limit, cursor)
+	return m.DB.Query(dest, statement, limit, cursor, params...)
+}
+
+func (m *MemoryAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return m.DB.QueryContext(ctx, dest, statement, limit, cursor, params...)
+}
+
+func (m *MemoryAdapter) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return m.DB.WithTransaction(ctx, func(tx StorageAdapter) error {
+		return fn(&MemoryAdapter{DB: tx.(*SQLAdapter)})
+	})
+}
+
+func (m *MemoryAdapter) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return m.DB.BatchCreate(ctx, items, params...)
+}
+
+func (m *MemoryAdapter) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return m.DB.BatchUpsert(ctx, items, params...)
+}
+
+func (m *MemoryAdapter) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	return m.DB.BatchDelete(ctx, item, filters, params...)
+}
+
+func (m *MemoryAdapter) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return m.DB.Restore(ctx, item, filter, params...)
+}
+
+func (m *MemoryAdapter) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return m.DB.Purge(ctx, item, filter, params...)
+}
+
+func (m *MemoryAdapter) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	return m.DB.Patch(ctx, item, filter, changes, params...)
+}
+
+func (m *MemoryAdapter) DeleteExpired(ctx context.Context, model any) (int64, error) {
+	return m.DB.DeleteExpired(ctx, model)
 }
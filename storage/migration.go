@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
@@ -24,6 +25,20 @@ type Migration struct {
 	Rollback string
 }
 
+// MigrationError is returned by Run when a statement of a migration fails, after the migration was rolled back
+type MigrationError struct {
+	Migration string
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %s failed and was rolled back: %v", e.Migration, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
 type DatabaseMigration struct {
 	storageType     StorageAdapterType
 	storageProvider StorageProviders
@@ -73,12 +88,11 @@ func (m *DatabaseMigration) rollbackMigration(migration MigrationFile) error {
 	return err
 }
 
-func (m *DatabaseMigration) runMigrations(migrations map[string]MigrationFile) {
+func (m *DatabaseMigration) runMigrations(migrations map[string]MigrationFile) error {
 	slog.Info("Getting last migration applied")
-	rollback := false
 	latestMigrationId, err := m.storage.GetLatestMigration()
 	if err != nil {
-		logger.Fatal("failed to get latest migration", slog.Any("error", err))
+		return fmt.Errorf("failed to get latest migration: %v", err)
 	}
 
 	//iterating over a map is randomized so we need to make sure we use the correct order of migrations
@@ -91,7 +105,7 @@ func (m *DatabaseMigration) runMigrations(migrations map[string]MigrationFile) {
 	for _, k := range keys {
 		migrationId, err := strconv.Atoi(strings.Split(k, "__")[0])
 		if err != nil {
-			logger.Fatal("failed to determine migration id", slog.Any("error", err))
+			return fmt.Errorf("failed to determine migration id: %v", err)
 		}
 		if migrationId > latestMigrationId {
 			mf := migrations[k]
@@ -100,47 +114,62 @@ func (m *DatabaseMigration) runMigrations(migrations map[string]MigrationFile) {
 				if err != nil {
 					slog.Error("failed to execute migration statement", slog.Any("error", err))
 					slog.Info("failed to execute migration statement", slog.String("key", k))
-					rollback = true
-					err = m.rollbackMigration(mf)
-					if err != nil {
-						logger.Fatal("failed to rollback migration", slog.Any("error", err))
+					if rollbackErr := m.rollbackMigration(mf); rollbackErr != nil {
+						return fmt.Errorf("failed to rollback migration %s: %v, after it failed with: %w", k, rollbackErr, err)
 					}
 					slog.Info("rollback successful")
-					break
+					return &MigrationError{Migration: k, Err: err}
 				}
 			}
-			if rollback {
-				break
-			}
 			slog.Info("updating migration table for", slog.String("key", k))
 			err = m.storage.UpdateMigrationTable(migrationId, k, mf.Description)
 			if err != nil {
-				logger.Fatal("failed to update migration table", slog.Any("error", err))
+				return fmt.Errorf("failed to update migration table: %v", err)
 			}
 		}
 	}
+	return nil
 }
 
+// Migrate runs the pending migrations, exiting the process if they can't be applied. A migration whose
+// statements fail is rolled back and only logged, as Migrate always did
 func (m *DatabaseMigration) Migrate() {
+	err := m.Run()
+	var migrationErr *MigrationError
+	if errors.As(err, &migrationErr) {
+		slog.Error("failed to run migrations", slog.Any("error", err))
+		return
+	}
+	if err != nil {
+		logger.Fatal("failed to run migrations", slog.Any("error", err))
+	}
+}
+
+// Run runs the pending migrations, returning an error if they can't be applied. A migration whose
+// statements fail is rolled back and stops the run with a *MigrationError
+func (m *DatabaseMigration) Run() error {
 	if m.storageType == DYNAMODB {
 		slog.Info(fmt.Sprintf(`using %s storage adapter, migrations are not supported`, m.storageType))
-	} else {
-		slog.Info(fmt.Sprintf(`using %s storage adapter, executing migrations`, m.storageType))
-		migrations, err := m.getMigrationFiles()
-		if err != nil {
-			logger.Fatal("failed to get migration files", slog.Any("error", err))
-		}
-		slog.Info("creating schema")
-		err = m.storage.CreateSchema()
-		if err != nil {
-			logger.Fatal("failed to create schema", slog.Any("error", err))
-		}
-		slog.Info("creating migration table")
-		err = m.storage.CreateMigrationTable()
-		if err != nil {
-			logger.Fatal("failed to create migration table", slog.Any("error", err))
-		}
-		m.runMigrations(migrations)
-		slog.Info("finished running migrations")
+		return nil
+	}
+	slog.Info(fmt.Sprintf(`using %s storage adapter, executing migrations`, m.storageType))
+	migrations, err := m.getMigrationFiles()
+	if err != nil {
+		return fmt.Errorf("failed to get migration files: %v", err)
+	}
+	slog.Info("creating schema")
+	err = m.storage.CreateSchema()
+	if err != nil {
+		return fmt.Errorf("failed to create schema: %v", err)
+	}
+	slog.Info("creating migration table")
+	err = m.storage.CreateMigrationTable()
+	if err != nil {
+		return fmt.Errorf("failed to create migration table: %v", err)
+	}
+	if err = m.runMigrations(migrations); err != nil {
+		return err
 	}
+	slog.Info("finished running migrations")
+	return nil
 }
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunMigrationsReportsFailures(t *testing.T) {
+	adapter := newTestAdapter(t)
+	if err := adapter.CreateMigrationTable(); err != nil {
+		t.Fatalf("CreateMigrationTable() error: %v", err)
+	}
+	m := NewDatabaseMigration(adapter)
+
+	migrations := map[string]MigrationFile{
+		"1__create_notes.yaml": {Description: "create notes", Migrations: []Migration{
+			{Migrate: "CREATE TABLE notes (id TEXT PRIMARY KEY)", Rollback: "DROP TABLE notes"},
+		}},
+		"2__broken.yaml": {Description: "broken", Migrations: []Migration{
+			{Migrate: "CREATE TABLE labels (id TEXT PRIMARY KEY)", Rollback: "DROP TABLE labels"},
+			{Migrate: "NOT A STATEMENT", Rollback: "SELECT 1"},
+		}},
+	}
+	err := m.runMigrations(migrations)
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.Migration != "2__broken.yaml" {
+		t.Fatalf("runMigrations() error = %v, want a MigrationError for 2__broken.yaml", err)
+	}
+
+	latest, err := adapter.GetLatestMigration()
+	if err != nil || latest != 1 {
+		t.Errorf("GetLatestMigration() = %d, %v, want 1", latest, err)
+	}
+	if adapter.DB.DB.Migrator().HasTable("labels") {
+		t.Errorf("the failed migration wasn't rolled back")
+	}
+}
@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Struct tag used to opt model fields into adapter features, e.g.
+//
+//	type Task struct {
+//	    ID      string `json:"id" gorm:"primaryKey"`
+//	    Version int64  `json:"version" magic:"version"`
+//	}
+//
+// The tag holds a comma separated list of options, each either a bare name or a name=value pair
+const MAGIC_TAG = "magic"
+
+// modelField is a struct field carrying a magic tag
+type modelField struct {
+	Name    string // Go field name
+	Key     string // json name
+	Index   []int
+	Type    reflect.Type
+	Options map[string][]string
+}
+
+// has reports whether the field's tag includes option
+func (f *modelField) has(option string) bool {
+	_, ok := f.Options[option]
+	return ok
+}
+
+// value returns the field of obj, which must be a struct or a pointer to one
+func (f *modelField) value(obj any) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(obj)).FieldByIndex(f.Index)
+}
+
+// modelMetadata holds the magic tagged fields of a model type
+type modelMetadata struct {
+	Fields []*modelField
+}
+
+// field returns the first field tagged with option, or nil if there is none
+func (m *modelMetadata) field(option string) *modelField {
+	for _, f := range m.Fields {
+		if f.has(option) {
+			return f
+		}
+	}
+	return nil
+}
+
+var modelMetadataCache sync.Map
+
+// getModelMetadata returns the metadata for the model type of obj. obj may be a struct, a slice of structs or
+// a pointer to either
+func getModelMetadata(obj any) *modelMetadata {
+	t := reflect.TypeOf(obj)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return &modelMetadata{}
+	}
+	if m, ok := modelMetadataCache.Load(t); ok {
+		return m.(*modelMetadata)
+	}
+
+	m := &modelMetadata{}
+	collectModelFields(m, t, nil)
+	modelMetadataCache.Store(t, m)
+	return m
+}
+
+// collectModelFields records the magic tagged fields of t, descending into embedded structs
+func collectModelFields(m *modelMetadata, t reflect.Type, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectModelFields(m, field.Type, fieldIndex)
+			continue
+		}
+		tag, ok := field.Tag.Lookup(MAGIC_TAG)
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		key := field.Name
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" && jsonName != "-" {
+			key = jsonName
+		}
+		m.Fields = append(m.Fields, &modelField{
+			Name:    field.Name,
+			Key:     key,
+			Index:   fieldIndex,
+			Type:    field.Type,
+			Options: parseMagicTag(tag),
+		})
+	}
+}
+
+// parseMagicTag splits a magic tag into its options
+func parseMagicTag(tag string) map[string][]string {
+	options := map[string][]string{}
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(option, "=")
+		options[name] = append(options[name], value)
+	}
+	return options
+}
+
+// getVersion returns the value of the version field of item, ok is false if item isn't versioned
+func getVersion(item any) (version int64, ok bool, err error) {
+	f := getModelMetadata(item).field("version")
+	if f == nil {
+		return 0, false, nil
+	}
+	v := f.value(item)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true, nil
+	default:
+		return 0, false, fmt.Errorf("version field %s must be an integer, got %s", f.Name, v.Kind())
+	}
+}
+
+// setVersion sets the version field of item, which must be a pointer
+func setVersion(item any, version int64) {
+	f := getModelMetadata(item).field("version")
+	if f == nil {
+		return
+	}
+	v := f.value(item)
+	if !v.CanSet() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(version)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(version))
+	}
+}
+
+// initVersion sets the version of a new item to 1 unless it was already set
+func initVersion(item any) error {
+	version, ok, err := getVersion(item)
+	if err != nil || !ok {
+		return err
+	}
+	if version == 0 {
+		setVersion(item, 1)
+	}
+	return nil
+}
+
+// softDeleteField returns the field tagged deleted_at of model, or nil if items of model are deleted for good
+func softDeleteField(model any) (*modelField, error) {
+	f := getModelMetadata(model).field("deleted_at")
+	if f == nil {
+		return nil, nil
+	}
+	if f.Type != reflect.TypeOf(&time.Time{}) {
+		return nil, fmt.Errorf("deleted_at field %s must be a *time.Time, got %s", f.Name, f.Type)
+	}
+	return f, nil
+}
+
+// requireSoftDeleteField is like softDeleteField but fails for models without soft deletes
+func requireSoftDeleteField(model any) (*modelField, error) {
+	f, err := softDeleteField(model)
+	if err == nil && f == nil {
+		err = fmt.Errorf("%T doesn't support soft deletes, tag a *time.Time field with magic:\"deleted_at\"", model)
+	}
+	return f, err
+}
+
+// liveItemsField returns the deleted_at field of model when soft deleted items must be left out of a read, that
+// is unless params include INCLUDE_DELETED
+func liveItemsField(model any, params ...map[string]any) (*modelField, error) {
+	for _, p := range params {
+		if include := p[INCLUDE_DELETED]; include == true || include == "true" {
+			return nil, nil
+		}
+	}
+	return softDeleteField(model)
+}
+
+// setDeletedAt sets the deleted_at field of item when it's a pointer to a struct
+func setDeletedAt(item any, f *modelField, deletedAt *time.Time) {
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if field := f.value(item); field.CanSet() {
+		field.Set(reflect.ValueOf(deletedAt))
+	}
+}
+
+// entityTypeField returns the field tagged type of model, or nil if model doesn't share its table with other models
+func entityTypeField(model any) (*modelField, error) {
+	f := getModelMetadata(model).field("type")
+	if f == nil {
+		return nil, nil
+	}
+	if f.Type.Kind() != reflect.String {
+		return nil, fmt.Errorf("type field %s must be a string, got %s", f.Name, f.Type)
+	}
+	return f, nil
+}
+
+// setEntityType sets the type field of item to its entity type when it's a pointer to a struct
+func setEntityType(item any, f *modelField) {
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if field := f.value(item); field.CanSet() {
+		field.SetString(entityType(item))
+	}
+}
+
+// expiryField returns the field tagged expires_at of model, or nil if items of model never expire
+func expiryField(model any) (*modelField, error) {
+	f := getModelMetadata(model).field("expires_at")
+	if f == nil {
+		return nil, nil
+	}
+	if f.Type != reflect.TypeOf(&time.Time{}) {
+		return nil, fmt.Errorf("expires_at field %s must be a *time.Time, got %s", f.Name, f.Type)
+	}
+	return f, nil
+}
+
+// requireExpiryField is like expiryField but fails for models whose items never expire
+func requireExpiryField(model any) (*modelField, error) {
+	f, err := expiryField(model)
+	if err == nil && f == nil {
+		err = fmt.Errorf("%T doesn't support expiry, tag a *time.Time field with magic:\"expires_at\"", model)
+	}
+	return f, err
+}
+
+// applyTTL sets the expires_at field of item to the current time plus the TTL param when it's given. Expiry times
+// are stored in UTC with a one second precision, the precision of DynamoDB TTL, so they compare the same way
+// across adapters
+func applyTTL(item any, params ...map[string]any) error {
+	f, err := expiryField(item)
+	if err != nil {
+		return err
+	}
+	ttl, ok, err := ttlParam(params...)
+	if err != nil {
+		return err
+	}
+	if ok && f == nil {
+		_, err = requireExpiryField(item)
+		return err
+	}
+	if f == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := f.value(item)
+	expiresAt := field.Interface().(*time.Time)
+	if ok {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+	if expiresAt != nil {
+		t := expiresAt.UTC().Truncate(time.Second)
+		field.Set(reflect.ValueOf(&t))
+	}
+	return nil
+}
+
+// ttlParam returns the value of the TTL param, which may be a time.Duration, a duration string or a number of seconds
+func ttlParam(params ...map[string]any) (time.Duration, bool, error) {
+	for _, p := range params {
+		value, ok := p[TTL]
+		if !ok {
+			continue
+		}
+		var ttl time.Duration
+		switch v := value.(type) {
+		case time.Duration:
+			ttl = v
+		case int:
+			ttl = time.Duration(v) * time.Second
+		case int64:
+			ttl = time.Duration(v) * time.Second
+		case float64:
+			ttl = time.Duration(v * float64(time.Second))
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				seconds, atoiErr := strconv.Atoi(v)
+				if atoiErr != nil {
+					return 0, false, fmt.Errorf("invalid ttl '%s': %v", v, err)
+				}
+				d = time.Duration(seconds) * time.Second
+			}
+			ttl = d
+		default:
+			return 0, false, fmt.Errorf("invalid ttl %v, expected a duration or a number of seconds", value)
+		}
+		if ttl <= 0 {
+			return 0, false, fmt.Errorf("invalid ttl %v, it must be positive", value)
+		}
+		return ttl, true, nil
+	}
+	return 0, false, nil
+}
+
+// getExpiresAt returns the expiry time of item, or nil if it doesn't expire
+func getExpiresAt(item any, f *modelField) *time.Time {
+	return f.value(item).Interface().(*time.Time)
+}
+
+// expiryNow returns the current time at the precision expiry times are stored with, items whose expiry time
+// isn't after it are expired
+func expiryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// jsonFieldName returns the json name of f, the name document and key-value adapters store fields under
+func jsonFieldName(f *modelField) (string, error) {
+	return f.Key, nil
+}
+
+// preparePatch validates changes and returns a copy in which expiry times of model are stored like applyTTL stores
+// them. Keys naming a field by its Go name are replaced with the name storedName returns for it, the name the adapter
+// stores the field under. It also reports whether applying changes twice gives the same result, which is not the
+// case with increments and appends
+func preparePatch(model any, changes map[string]any, storedName func(f *modelField) (string, error)) (map[string]any, bool, error) {
+	if len(changes) == 0 {
+		return nil, false, fmt.Errorf("at least one change is required to patch a resource")
+	}
+	expiryName := ""
+	expiry, err := expiryField(model)
+	if err != nil {
+		return nil, false, err
+	}
+	if expiry != nil {
+		if expiryName, err = storedName(expiry); err != nil {
+			return nil, false, err
+		}
+	}
+
+	result := make(map[string]any, len(changes))
+	idempotent := true
+	for key, change := range changes {
+		for _, f := range getModelMetadata(model).Fields {
+			if key == f.Name {
+				if key, err = storedName(f); err != nil {
+					return nil, false, err
+				}
+				break
+			}
+		}
+		if _, ok := result[key]; ok {
+			return nil, false, fmt.Errorf("%s is changed more than once", key)
+		}
+
+		switch c := change.(type) {
+		case PatchOperation:
+			idempotent = false
+			switch c.Op {
+			case PATCH_INCREMENT:
+				switch reflect.ValueOf(c.Value).Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+					reflect.Float32, reflect.Float64:
+				default:
+					return nil, false, fmt.Errorf("can't increment %s by %v, a number is required", key, c.Value)
+				}
+			case PATCH_APPEND:
+				if values, ok := c.Value.([]any); !ok || len(values) == 0 {
+					return nil, false, fmt.Errorf("at least one value is required to append to %s", key)
+				}
+			default:
+				return nil, false, fmt.Errorf("unsupported patch operation '%s' on %s", c.Op, key)
+			}
+		case time.Time:
+			if expiry != nil && key == expiryName {
+				t := c.UTC().Truncate(time.Second)
+				change = &t
+			}
+		case *time.Time:
+			if expiry != nil && key == expiryName && c != nil {
+				t := c.UTC().Truncate(time.Second)
+				change = &t
+			}
+		}
+		result[key] = change
+	}
+	return result, idempotent, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// TableNamer is implemented by models choosing the name of their DynamoDB table or CosmosDB container instead of
+// the pluralized snake case name of their type, e.g. to fix an irregular plural or to share a DynamoDB table with
+// other models. It has the same method as gorm's schema.Tabler, so SQL models implementing it use the same name.
+// The table_prefix and table_suffix (container_prefix and container_suffix on CosmosDB) config options are added
+// to the name either way
+//
+//	func (Category) TableName() string { return "categories" }
+type TableNamer interface {
+	TableName() string
+}
+
+// EntityTyper is implemented by models choosing the value stored in their field tagged magic:"type", which defaults
+// to the snake case name of their type. Models sharing a DynamoDB table tag a string field with magic:"type" so
+// each of them only reads its own items
+type EntityTyper interface {
+	EntityType() string
+}
+
+var matchFirstCap = regexp.MustCompile("(.)([A-Z][a-z]+)")
+var matchAllCap = regexp.MustCompile("([a-z0-9])([A-Z])")
+
+// snakeCaseTypeName returns the snake case name of the type of obj, without its package
+func snakeCaseTypeName(obj any) string {
+	name := reflect.TypeOf(obj).String()
+	name = name[strings.LastIndex(name, ".")+1:]
+	name = matchFirstCap.ReplaceAllString(name, "${1}_${2}")
+	name = matchAllCap.ReplaceAllString(name, "${1}_${2}")
+	return strings.ToLower(name)
+}
+
+// modelInstance returns a pointer to a new value of the model type of obj, which may be a struct, a slice of structs
+// or a pointer to either, so the interfaces it implements with value or pointer receivers can be checked
+func modelInstance(obj any) any {
+	t := reflect.TypeOf(obj)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	return reflect.New(t).Interface()
+}
+
+// modelTableName returns the table name of obj between prefix and suffix: the name returned by its TableName
+// method if it implements TableNamer, or else the snake case name of its type followed by an s
+func modelTableName(obj any, prefix string, suffix string) string {
+	name := ""
+	if namer, ok := modelInstance(obj).(TableNamer); ok {
+		name = namer.TableName()
+	}
+	if name == "" {
+		name = snakeCaseTypeName(obj) + "s"
+	}
+	return prefix + name + suffix
+}
+
+// entityType returns the entity type of the items of model, see EntityTyper
+func entityType(model any) string {
+	if typer, ok := modelInstance(model).(EntityTyper); ok {
+		if t := typer.EntityType(); t != "" {
+			return t
+		}
+	}
+	return snakeCaseTypeName(modelInstance(model))
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+const DEFAULT_PAGE_SIZE = 100
+
+// Page holds a single page of results returned by a Repository
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// ListOptions controls filtering, sorting and pagination of Repository List and Search calls.
+// SortKey is a sort spec such as "-created_at,id", see StorageAdapter.List. When it's empty the adapter
+// applies its own default order
+type ListOptions struct {
+	SortKey string
+	Filter  map[string]any
+	Limit   int
+	Cursor  string
+	Params  map[string]any
+}
+
+// Repository is a typed wrapper around a StorageAdapter for a single model type T.
+// Filter and sort keys are validated against T's fields so mistakes surface as
+// BadRequest errors instead of runtime reflection or database failures.
+//
+// Example:
+//
+//	type Task struct {
+//	    ID   string `json:"id" gorm:"primaryKey"`
+//	    Name string `json:"name"`
+//	}
+//
+//	tasks, err := storage.NewRepository[Task](adapter)
+//	task, err := tasks.Get(ctx, "1234")
+//	page, err := tasks.List(ctx, storage.ListOptions{SortKey: "name", Limit: 10})
+type Repository[T any] struct {
+	storage ContextStorageAdapter
+	idKey   string
+	fields  map[string]string // addressable field names mapped to the name the adapter stores the field under
+}
+
+// fieldMapper is implemented by adapters that don't store fields under their json name, e.g. SQL adapters, whose
+// filters, sort specs and patches name columns
+type fieldMapper interface {
+	// storedFieldName returns the name the Go field goName of model is stored under, false if it isn't stored
+	storedFieldName(model any, goName string) (string, bool)
+}
+
+// NewRepository creates a Repository for T by introspecting its struct fields.
+// Fields are addressable by their json tag name or their Go field name and are passed to the adapter under the
+// name it stores them under, the column name on SQL adapters. The id field is the one
+// tagged with gorm:"primaryKey", or otherwise the field named id
+func NewRepository[T any](adapter StorageAdapter) (*Repository[T], error) {
+	if adapter == nil {
+		return nil, fmt.Errorf("a storage adapter is required to create a repository")
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %s", t.Kind())
+	}
+
+	r := &Repository[T]{
+		storage: asContextStorageAdapter(adapter),
+		fields:  map[string]string{},
+	}
+	mapper, _ := adapter.(fieldMapper)
+	r.collectFields(t, mapper)
+
+	if r.idKey == "" {
+		return nil, fmt.Errorf("%s has no id field, tag one with gorm:\"primaryKey\" or name it id", t.Name())
+	}
+	return r, nil
+}
+
+// collectFields records the addressable field names of t and the name mapper stores them under, descending into
+// embedded structs. Without a mapper fields are stored under their json name
+func (r *Repository[T]) collectFields(t reflect.Type, mapper fieldMapper) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			r.collectFields(field.Type, mapper)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+		if jsonName := strings.Split(jsonTag, ",")[0]; jsonName != "" {
+			name = jsonName
+		}
+		stored := name
+		if mapper != nil {
+			var ok bool
+			if stored, ok = mapper.storedFieldName(new(T), field.Name); !ok {
+				continue
+			}
+		}
+		r.fields[name] = stored
+		r.fields[field.Name] = stored
+
+		isPrimaryKey := strings.Contains(strings.ToLower(field.Tag.Get("gorm")), "primarykey")
+		if isPrimaryKey || (r.idKey == "" && strings.EqualFold(name, "id")) {
+			r.idKey = stored
+		}
+	}
+}
+
+// validateField returns the name the field name is stored under, or a BadRequest if T has no such field
+func (r *Repository[T]) validateField(kind string, name string) (string, error) {
+	if stored, ok := r.fields[name]; ok {
+		return stored, nil
+	}
+	validFields := make([]string, 0, len(r.fields))
+	for f := range r.fields {
+		validFields = append(validFields, f)
+	}
+	sort.Strings(validFields)
+	return "", &serviceErrors.BadRequest{
+		Message: fmt.Sprintf("invalid %s field '%s'; valid fields are: %s", kind, name, strings.Join(validFields, ", ")),
+	}
+}
+
+// validateKeys returns a copy of m keyed by the names its keys are stored under, see validateField
+func (r *Repository[T]) validateKeys(kind string, m map[string]any) (map[string]any, error) {
+	if m == nil {
+		return nil, nil
+	}
+	stored := make(map[string]any, len(m))
+	for key, value := range m {
+		name, err := r.validateField(kind, key)
+		if err != nil {
+			return nil, err
+		}
+		stored[name] = value
+	}
+	return stored, nil
+}
+
+func (r *Repository[T]) validateListOptions(opts *ListOptions) error {
+	sortSpec := []string{}
+	for _, f := range parseSortSpec(opts.SortKey) {
+		name, err := r.validateField("sort", f.Name)
+		if err != nil {
+			return err
+		}
+		if f.Desc {
+			name = "-" + name
+		}
+		sortSpec = append(sortSpec, name)
+	}
+	opts.SortKey = strings.Join(sortSpec, ",")
+	if opts.Limit <= 0 {
+		opts.Limit = DEFAULT_PAGE_SIZE
+	}
+	filter, err := r.validateKeys("filter", opts.Filter)
+	if err != nil {
+		return err
+	}
+	opts.Filter = filter
+	return nil
+}
+
+// Get returns the item with the given id
+func (r *Repository[T]) Get(ctx context.Context, id any, params ...map[string]any) (T, error) {
+	var item T
+	err := r.storage.GetContext(ctx, &item, map[string]any{r.idKey: id}, params...)
+	return item, err
+}
+
+// List returns a page of items matching opts.Filter ordered by opts.SortKey
+func (r *Repository[T]) List(ctx context.Context, opts ListOptions) (Page[T], error) {
+	if err := r.validateListOptions(&opts); err != nil {
+		return Page[T]{}, err
+	}
+	items := []T{}
+	next, err := r.storage.ListContext(ctx, &items, opts.SortKey, opts.Filter, opts.Limit, opts.Cursor, opts.Params)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return Page[T]{Items: items, NextCursor: next}, nil
+}
+
+// Search returns a page of items matching the given Lucene query ordered by opts.SortKey.
+// opts.Filter is not used by Search
+func (r *Repository[T]) Search(ctx context.Context, query string, opts ListOptions) (Page[T], error) {
+	opts.Filter = nil
+	if err := r.validateListOptions(&opts); err != nil {
+		return Page[T]{}, err
+	}
+	items := []T{}
+	next, err := r.storage.SearchContext(ctx, &items, opts.SortKey, query, opts.Limit, opts.Cursor, opts.Params)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return Page[T]{Items: items, NextCursor: next}, nil
+}
+
+// Count returns the number of items matching filter
+func (r *Repository[T]) Count(ctx context.Context, filter map[string]any, params ...map[string]any) (int64, error) {
+	filter, err := r.validateKeys("filter", filter)
+	if err != nil {
+		return 0, err
+	}
+	return r.storage.CountContext(ctx, new(T), filter, params...)
+}
+
+// Create stores a new item
+func (r *Repository[T]) Create(ctx context.Context, item *T, params ...map[string]any) error {
+	return r.storage.CreateContext(ctx, item, params...)
+}
+
+// Update replaces the item with the given id
+func (r *Repository[T]) Update(ctx context.Context, id any, item *T, params ...map[string]any) error {
+	return r.storage.UpdateContext(ctx, item, map[string]any{r.idKey: id}, params...)
+}
+
+// Patch changes the fields in changes of the item with the given id and returns the patched item, see
+// PatchStorageAdapter
+func (r *Repository[T]) Patch(ctx context.Context, id any, changes map[string]any, params ...map[string]any) (T, error) {
+	var item T
+	changes, err := r.validateKeys("patch", changes)
+	if err != nil {
+		return item, err
+	}
+	err = Patch(ctx, r.storage, &item, map[string]any{r.idKey: id}, changes, params...)
+	return item, err
+}
+
+// Delete removes the item with the given id, or marks it as deleted if T supports soft deletes
+func (r *Repository[T]) Delete(ctx context.Context, id any, params ...map[string]any) error {
+	return r.storage.DeleteContext(ctx, new(T), map[string]any{r.idKey: id}, params...)
+}
+
+// Restore undeletes the soft deleted item with the given id
+func (r *Repository[T]) Restore(ctx context.Context, id any, params ...map[string]any) error {
+	return Restore(ctx, r.storage, new(T), map[string]any{r.idKey: id}, params...)
+}
+
+// Purge removes the item with the given id for good, even if T supports soft deletes
+func (r *Repository[T]) Purge(ctx context.Context, id any, params ...map[string]any) error {
+	return Purge(ctx, r.storage, new(T), map[string]any{r.idKey: id}, params...)
+}
+
+// asContextStorageAdapter returns adapter as a ContextStorageAdapter, wrapping adapters that
+// don't support contexts so that the context is simply ignored
+func asContextStorageAdapter(adapter StorageAdapter) ContextStorageAdapter {
+	if c, ok := adapter.(ContextStorageAdapter); ok {
+		return c
+	}
+	return contextlessAdapter{adapter}
+}
+
+type contextlessAdapter struct {
+	StorageAdapter
+}
+
+func (a contextlessAdapter) ExecuteContext(ctx context.Context, statement string) error {
+	return a.Execute(statement)
+}
+
+func (a contextlessAdapter) PingContext(ctx context.Context) error {
+	return a.Ping()
+}
+
+func (a contextlessAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return a.Create(item, params...)
+}
+
+func (a contextlessAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return a.Get(dest, filter, params...)
+}
+
+func (a contextlessAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.Update(item, filter, params...)
+}
+
+func (a contextlessAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return a.Delete(item, filter, params...)
+}
+
+func (a contextlessAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.List(dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (a contextlessAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.Search(dest, sortKey, query, limit, cursor, params...)
+}
+
+func (a contextlessAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return a.Count(dest, filter, params...)
+}
+
+func (a contextlessAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return a.Query(dest, statement, limit, cursor, params...)
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+)
+
+type repositoryRow struct {
+	ID          string `json:"id" gorm:"primaryKey"`
+	DisplayName string `json:"name" gorm:"column:display_name"`
+	Rank        int    `json:"rank"`
+	Note        string `json:"note" gorm:"-"`
+}
+
+func (repositoryRow) TableName() string { return "repository_rows" }
+
+func TestRepositoryMapsFieldsToColumns(t *testing.T) {
+	ctx := context.Background()
+	adapter := newTestAdapter(t, &repositoryRow{})
+	rows, err := NewRepository[repositoryRow](adapter)
+	if err != nil {
+		t.Fatalf("NewRepository() error: %v", err)
+	}
+	for _, row := range []repositoryRow{{ID: "1", DisplayName: "b", Rank: 2}, {ID: "2", DisplayName: "a", Rank: 1}, {ID: "3", DisplayName: "b", Rank: 3}} {
+		if err := rows.Create(ctx, &row); err != nil {
+			t.Fatalf("Create() error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    ListOptions
+		want    []string
+		wantErr bool
+	}{
+		{name: "filter by json name", opts: ListOptions{Filter: map[string]any{"name": "b"}}, want: []string{"1", "3"}},
+		{name: "filter by Go name", opts: ListOptions{Filter: map[string]any{"DisplayName": "a"}}, want: []string{"2"}},
+		{name: "sort by json name", opts: ListOptions{SortKey: "name,-rank"}, want: []string{"2", "3", "1"}},
+		{name: "sort by Go name", opts: ListOptions{SortKey: "-DisplayName,Rank"}, want: []string{"1", "3", "2"}},
+		{name: "unknown field", opts: ListOptions{Filter: map[string]any{"display": "b"}}, wantErr: true},
+		{name: "field that isn't stored", opts: ListOptions{SortKey: "note"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := rows.List(ctx, tt.opts)
+			if tt.wantErr {
+				var badRequest *serviceErrors.BadRequest
+				if !errors.As(err, &badRequest) {
+					t.Fatalf("List() error = %v, want a BadRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("List() error: %v", err)
+			}
+			got := []string{}
+			for _, item := range page.Items {
+				got = append(got, item.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("List() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("List() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	count, err := rows.Count(ctx, map[string]any{"DisplayName": "b"})
+	if err != nil || count != 2 {
+		t.Errorf("Count() = %d, %v, want 2", count, err)
+	}
+	patched, err := rows.Patch(ctx, "2", map[string]any{"name": "c"})
+	if err != nil || patched.DisplayName != "c" {
+		t.Errorf("Patch() = %+v, %v, want the name changed to c", patched, err)
+	}
+}
+
+func TestRepositoryLeavesTheDefaultOrderToTheAdapter(t *testing.T) {
+	ctx := context.Background()
+	adapter, requests := newTestDynamoDBAdapter(t, func(string) string {
+		return `{"Items": [{"tenant": {"S": "acme"}, "id": {"S": "1"}, "name": {"S": "paint"}, "size": {"N": "2"}}]}`
+	})
+	tasks, err := NewRepository[dynamoTask](adapter)
+	if err != nil {
+		t.Fatalf("NewRepository() error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		list        func() (Page[dynamoTask], error)
+		wantOp      string
+		wantForward any
+		wantErr     bool
+	}{
+		{
+			name: "list without a sort key",
+			list: func() (Page[dynamoTask], error) {
+				return tasks.List(ctx, ListOptions{Filter: map[string]any{"tenant": "acme"}})
+			},
+			wantOp:      "Query",
+			wantForward: true,
+		},
+		{
+			name: "list sorted by the sort key",
+			list: func() (Page[dynamoTask], error) {
+				return tasks.List(ctx, ListOptions{Filter: map[string]any{"tenant": "acme"}, SortKey: "-id"})
+			},
+			wantOp:      "Query",
+			wantForward: false,
+		},
+		{
+			name: "search scanning without a sort key",
+			list: func() (Page[dynamoTask], error) {
+				return tasks.Search(ctx, "name:paint", ListOptions{Params: map[string]any{DYNAMODB_ALLOW_SCAN: true}})
+			},
+			wantOp: "Scan",
+		},
+		{
+			name: "list sorted by a field the table can't sort by",
+			list: func() (Page[dynamoTask], error) {
+				return tasks.List(ctx, ListOptions{Filter: map[string]any{"tenant": "acme"}, SortKey: "name,-size"})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := len(*requests)
+			page, err := tt.list()
+			if tt.wantErr {
+				var badRequest *serviceErrors.BadRequest
+				if !errors.As(err, &badRequest) || len(*requests) != sent {
+					t.Fatalf("error = %v, want a BadRequest without sending a request", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if len(page.Items) != 1 || page.Items[0].Name != "paint" {
+				t.Errorf("Items = %+v, want the item returned by DynamoDB", page.Items)
+			}
+			request := (*requests)[len(*requests)-1]
+			if request.Operation != tt.wantOp || request.Input["ScanIndexForward"] != tt.wantForward {
+				t.Errorf("sent %s %v, want a %s with ScanIndexForward %v", request.Operation, request.Input, tt.wantOp, tt.wantForward)
+			}
+		})
+	}
+}
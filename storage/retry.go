@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/tink3rlabs/magic/config"
+)
+
+// RetryPolicy configures how adapters retry operations failing with transient errors, such as throttling,
+// serialization failures or dropped connections. Each adapter decides which of its errors are transient.
+// Operations that aren't idempotent (Create, Execute, BatchCreate and WithTransaction) are only retried when
+// RetryNonIdempotent is set, since a failed attempt may still have been applied
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one, 0 or 1 disables retries
+	MaxAttempts    int           `yaml:"max_attempts" env:"RETRY_MAX_ATTEMPTS"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"RETRY_INITIAL_BACKOFF" default:"100ms"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"RETRY_MAX_BACKOFF" default:"5s"`
+	// Multiplier grows the backoff after each attempt, values below 1 are treated as 2
+	Multiplier float64 `yaml:"multiplier" env:"RETRY_MULTIPLIER" default:"2"`
+	// Jitter randomizes each backoff by up to this fraction of it, between 0 and 1
+	Jitter             float64 `yaml:"jitter" env:"RETRY_JITTER" default:"0.2"`
+	RetryNonIdempotent bool    `yaml:"retry_non_idempotent" env:"RETRY_NON_IDEMPOTENT"`
+}
+
+// Config keys holding the retry policy of an adapter
+var retryOptions = []string{
+	"retry_max_attempts", "retry_initial_backoff", "retry_max_backoff", "retry_multiplier", "retry_jitter", "retry_non_idempotent",
+}
+
+// backoff returns the delay before the retry following attempt, which starts at 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// validate records the invalid fields of p in errs
+func (p RetryPolicy) validate(errs *config.ValidationError) {
+	if p.MaxAttempts < 0 {
+		errs.Add("retry.max_attempts", "can't be negative")
+	}
+	if p.InitialBackoff < 0 {
+		errs.Add("retry.initial_backoff", "can't be negative")
+	}
+	if p.MaxBackoff < 0 {
+		errs.Add("retry.max_backoff", "can't be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		errs.Add("retry.jitter", "must be between 0 and 1, got %g", p.Jitter)
+	}
+}
+
+func (p RetryPolicy) toMap(m map[string]string) {
+	if p.MaxAttempts <= 1 {
+		return
+	}
+	m["retry_max_attempts"] = strconv.Itoa(p.MaxAttempts)
+	if p.InitialBackoff > 0 {
+		m["retry_initial_backoff"] = p.InitialBackoff.String()
+	}
+	if p.MaxBackoff > 0 {
+		m["retry_max_backoff"] = p.MaxBackoff.String()
+	}
+	if p.Multiplier > 0 {
+		m["retry_multiplier"] = strconv.FormatFloat(p.Multiplier, 'f', -1, 64)
+	}
+	if p.Jitter > 0 {
+		m["retry_jitter"] = strconv.FormatFloat(p.Jitter, 'f', -1, 64)
+	}
+	if p.RetryNonIdempotent {
+		m["retry_non_idempotent"] = "true"
+	}
+}
+
+// parseRetryPolicy reads the retry policy from the retry_* keys of an adapter's config
+func parseRetryPolicy(config map[string]string) (RetryPolicy, error) {
+	p := RetryPolicy{}
+	var err error
+	parse := func(key string, fn func(v string) error) {
+		if v := config[key]; v != "" && err == nil {
+			if parseErr := fn(v); parseErr != nil {
+				err = fmt.Errorf("invalid %s '%s': %v", key, v, parseErr)
+			}
+		}
+	}
+	parse("retry_max_attempts", func(v string) (e error) { p.MaxAttempts, e = strconv.Atoi(v); return })
+	parse("retry_initial_backoff", func(v string) (e error) { p.InitialBackoff, e = time.ParseDuration(v); return })
+	parse("retry_max_backoff", func(v string) (e error) { p.MaxBackoff, e = time.ParseDuration(v); return })
+	parse("retry_multiplier", func(v string) (e error) { p.Multiplier, e = strconv.ParseFloat(v, 64); return })
+	parse("retry_jitter", func(v string) (e error) { p.Jitter, e = strconv.ParseFloat(v, 64); return })
+	parse("retry_non_idempotent", func(v string) (e error) { p.RetryNonIdempotent, e = strconv.ParseBool(v); return })
+	return p, err
+}
+
+// errorClassifier maps a backend error to the storage error it stands for, such as ErrAlreadyExists or
+// ErrThrottled, or nil if there's none. It also reports whether the error is transient and worth retrying,
+// along with the delay requested by the server before retrying, if any
+type errorClassifier func(err error) (kind error, transient bool, after time.Duration)
+
+// Storage errors the adapters translate backend errors into
+var storageErrors = []error{ErrNotFound, ErrConflict, ErrAlreadyExists, ErrPreconditionFailed, ErrThrottled, ErrUnavailable}
+
+// backendError is a backend error matching one of the storage errors with errors.Is, while keeping its
+// message and the original error available to errors.As
+type backendError struct {
+	kind error
+	err  error
+}
+
+func (e *backendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *backendError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// retrier retries operations according to policy, using classify to tell transient errors apart, and
+// translates the errors they end up failing with into storage errors. A nil retrier runs operations once
+// and returns their errors as is
+type retrier struct {
+	policy   RetryPolicy
+	classify errorClassifier
+}
+
+// newRetrier returns a retrier for the retry policy in config, operations are run once if retries aren't
+// enabled
+func newRetrier(config map[string]string, classify errorClassifier) (*retrier, error) {
+	policy, err := parseRetryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	return &retrier{policy: policy, classify: classify}, nil
+}
+
+// withoutRetries returns a retrier translating errors like r but never retrying, e.g. for operations
+// inside a transaction which can't be retried on their own
+func (r *retrier) withoutRetries() *retrier {
+	if r == nil {
+		return nil
+	}
+	return &retrier{classify: r.classify}
+}
+
+// translate returns err matching the storage error it stands for, if any
+func (r *retrier) translate(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	for _, kind := range storageErrors {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+	if kind, _, _ := r.classify(err); kind != nil {
+		return &backendError{kind: kind, err: err}
+	}
+	return err
+}
+
+// do runs op until it succeeds, fails with an error that isn't transient, runs out of attempts or ctx is done
+func (r *retrier) do(ctx context.Context, idempotent bool, op func() error) error {
+	_, err := retryResult(ctx, r, idempotent, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+// retryResult is like retrier.do for operations returning a value
+func retryResult[T any](ctx context.Context, r *retrier, idempotent bool, op func() (T, error)) (T, error) {
+	result, err := op()
+	if r == nil {
+		return result, err
+	}
+	if !idempotent && !r.policy.RetryNonIdempotent {
+		return result, r.translate(err)
+	}
+	for attempt := 1; err != nil && attempt < r.policy.MaxAttempts; attempt++ {
+		_, transient, after := r.classify(err)
+		if !transient || !r.wait(ctx, attempt, after) {
+			break
+		}
+		result, err = op()
+	}
+	return result, r.translate(err)
+}
+
+// wait sleeps before the retry following attempt, or the delay requested by the server if any, and
+// reports whether ctx is still active afterwards
+func (r *retrier) wait(ctx context.Context, attempt int, after time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	delay := r.policy.backoff(attempt)
+	if after > 0 {
+		delay = after
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -2,8 +2,11 @@ package lucene
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/grindlemire/go-lucene/pkg/driver"
 	"github.com/grindlemire/go-lucene/pkg/lucene/expr"
@@ -579,3 +582,539 @@ func convertToPostgresPlaceholders(query string) string {
 	}
 	return result.String()
 }
+
+// CosmosSQLDriver converts Lucene queries to parameterized Azure Cosmos DB SQL conditions
+// over the container alias c (as in SELECT * FROM c WHERE ...).
+type CosmosSQLDriver struct {
+	fields map[string]FieldInfo
+}
+
+func NewCosmosSQLDriver(fields []FieldInfo) *CosmosSQLDriver {
+	fieldMap := make(map[string]FieldInfo)
+	for _, f := range fields {
+		fieldMap[f.Name] = f
+	}
+	return &CosmosSQLDriver{fields: fieldMap}
+}
+
+// cosmosRender accumulates the query parameters of a single rendering.
+type cosmosRender struct {
+	params []azcosmos.QueryParameter
+}
+
+// param registers value as a query parameter and returns its placeholder.
+func (r *cosmosRender) param(value any) string {
+	name := fmt.Sprintf("@search%d", len(r.params))
+	r.params = append(r.params, azcosmos.QueryParameter{Name: name, Value: value})
+	return name
+}
+
+// RenderCosmosSQL renders the expression to a Cosmos DB SQL condition with @searchN parameters.
+// Wildcard matches are case-insensitive like their PostgreSQL ILIKE counterpart.
+func (d *CosmosSQLDriver) RenderCosmosSQL(e *expr.Expression) (string, []azcosmos.QueryParameter, error) {
+	if e == nil {
+		return "", nil, nil
+	}
+	r := &cosmosRender{}
+	str, err := d.render(r, e)
+	if err != nil {
+		return "", nil, err
+	}
+	return str, r.params, nil
+}
+
+// render dispatches to specialized renderers based on operator type.
+func (d *CosmosSQLDriver) render(r *cosmosRender, e *expr.Expression) (string, error) {
+	switch e.Op {
+	case expr.Like:
+		return d.renderLike(r, e)
+	case expr.Fuzzy:
+		return "", fmt.Errorf("fuzzy search (~) is not supported by Cosmos DB")
+	case expr.Boost:
+		return "", fmt.Errorf("boost operator (^) is not supported in SQL filtering; it only affects ranking/scoring")
+	case expr.Range:
+		return d.renderRange(r, e)
+	case expr.Equals, expr.Greater, expr.Less, expr.GreaterEq, expr.LessEq:
+		return d.renderComparison(r, e)
+	case expr.And, expr.Or:
+		left, err := d.renderOperand(r, e.Left, e.Op)
+		if err != nil {
+			return "", err
+		}
+		right, err := d.renderOperand(r, e.Right, e.Op)
+		if err != nil {
+			return "", err
+		}
+		if e.Op == expr.And {
+			return fmt.Sprintf("(%s) AND (%s)", left, right), nil
+		}
+		return fmt.Sprintf("(%s) OR (%s)", left, right), nil
+	case expr.Not, expr.MustNot:
+		left, err := d.renderOperand(r, e.Left, e.Op)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT (%s)", left), nil
+	case expr.Must:
+		return d.renderOperand(r, e.Left, e.Op)
+	default:
+		return "", fmt.Errorf("unsupported operator in Cosmos DB query: %v", e.Op)
+	}
+}
+
+func (d *CosmosSQLDriver) renderOperand(r *cosmosRender, operand any, op expr.Operator) (string, error) {
+	e, ok := operand.(*expr.Expression)
+	if !ok || e == nil {
+		return "", fmt.Errorf("%s operator requires expression operands", op)
+	}
+	return d.render(r, e)
+}
+
+// renderComparison handles comparison operators, treating null as a missing or null property.
+func (d *CosmosSQLDriver) renderComparison(r *cosmosRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	if isNullValue(e.Right) {
+		if e.Op == expr.Equals {
+			return fmt.Sprintf("(NOT IS_DEFINED(%s) OR IS_NULL(%s))", col, col), nil
+		}
+		return "", fmt.Errorf("cannot use comparison operators (>, <, >=, <=) with null value")
+	}
+
+	var opSymbol string
+	switch e.Op {
+	case expr.Equals:
+		opSymbol = "="
+	case expr.Greater:
+		opSymbol = ">"
+	case expr.Less:
+		opSymbol = "<"
+	case expr.GreaterEq:
+		opSymbol = ">="
+	case expr.LessEq:
+		opSymbol = "<="
+	}
+	return fmt.Sprintf("%s %s %s", col, opSymbol, r.param(literalValue(e.Right))), nil
+}
+
+// renderLike converts wildcard patterns to STARTSWITH, ENDSWITH or CONTAINS when possible and to
+// RegexMatch otherwise. Lucene regular expressions (/.../) are passed to RegexMatch as is.
+func (d *CosmosSQLDriver) renderLike(r *cosmosRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	pattern := extractLiteralValue(e.Right)
+	if right, ok := e.Right.(*expr.Expression); ok && right.Op == expr.Regexp {
+		return fmt.Sprintf("RegexMatch(%s, %s)", col, r.param(strings.Trim(pattern, "/"))), nil
+	}
+
+	inner := strings.Trim(pattern, "*")
+	if inner != "" && !strings.ContainsAny(inner, "*?") {
+		hasPrefix := strings.HasPrefix(pattern, "*")
+		hasSuffix := strings.HasSuffix(pattern, "*")
+		switch {
+		case hasPrefix && hasSuffix:
+			return fmt.Sprintf("CONTAINS(%s, %s, true)", col, r.param(inner)), nil
+		case hasSuffix:
+			return fmt.Sprintf("STARTSWITH(%s, %s, true)", col, r.param(inner)), nil
+		case hasPrefix:
+			return fmt.Sprintf("ENDSWITH(%s, %s, true)", col, r.param(inner)), nil
+		}
+	}
+
+	return fmt.Sprintf("RegexMatch(%s, %s, 'i')", col, r.param(wildcardToRegex(pattern))), nil
+}
+
+// renderRange handles range queries including open-ended ranges with wildcards (*).
+func (d *CosmosSQLDriver) renderRange(r *cosmosRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	rangeBoundary, ok := e.Right.(*expr.RangeBoundary)
+	if !ok {
+		return "", fmt.Errorf("invalid range expression structure: expected *expr.RangeBoundary, got %T", e.Right)
+	}
+
+	minOpen := rangeBoundary.Min == nil || extractLiteralValue(rangeBoundary.Min) == "*"
+	maxOpen := rangeBoundary.Max == nil || extractLiteralValue(rangeBoundary.Max) == "*"
+	if minOpen && maxOpen {
+		return "", fmt.Errorf("both range bounds cannot be wildcards")
+	}
+
+	greater, less := ">", "<"
+	if rangeBoundary.Inclusive {
+		greater, less = ">=", "<="
+	}
+
+	if minOpen {
+		return fmt.Sprintf("%s %s %s", col, less, r.param(literalValue(rangeBoundary.Max))), nil
+	}
+	if maxOpen {
+		return fmt.Sprintf("%s %s %s", col, greater, r.param(literalValue(rangeBoundary.Min))), nil
+	}
+	minParam := r.param(literalValue(rangeBoundary.Min))
+	maxParam := r.param(literalValue(rangeBoundary.Max))
+	return fmt.Sprintf("(%s %s %s AND %s %s %s)", col, greater, minParam, col, less, maxParam), nil
+}
+
+// serializeColumn renders a column as a property path of c, so field.subfield becomes c["field"]["subfield"].
+func (d *CosmosSQLDriver) serializeColumn(in any) (string, error) {
+	var name string
+	switch v := in.(type) {
+	case expr.Column:
+		name = string(v)
+	case *expr.Expression:
+		col, ok := v.Left.(expr.Column)
+		if v.Op != expr.Literal || !ok {
+			return "", fmt.Errorf("unexpected column expression: %v", v)
+		}
+		name = string(col)
+	default:
+		return "", fmt.Errorf("unexpected column type: %T", v)
+	}
+
+	path := strings.Builder{}
+	path.WriteString("c")
+	for _, part := range strings.Split(name, ".") {
+		fmt.Fprintf(&path, `["%s"]`, strings.ReplaceAll(part, `"`, `\"`))
+	}
+	return path.String(), nil
+}
+
+// literalValue returns the value of a literal keeping its type, so numbers compare as numbers.
+func literalValue(v any) any {
+	if ex, ok := v.(*expr.Expression); ok && ex.Left != nil {
+		return ex.Left
+	}
+	return v
+}
+
+// wildcardToRegex converts a Lucene wildcard pattern to an anchored regular expression.
+func wildcardToRegex(pattern string) string {
+	var result strings.Builder
+	result.WriteByte('^')
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			result.WriteString(".*")
+		case '?':
+			result.WriteByte('.')
+		default:
+			result.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	result.WriteByte('$')
+	return result.String()
+}
+
+// DynamoDBExpressionDriver converts Lucene queries to DynamoDB condition expressions, the syntax of the
+// FilterExpression of Query and Scan requests.
+type DynamoDBExpressionDriver struct {
+	fields map[string]FieldInfo
+}
+
+func NewDynamoDBExpressionDriver(fields []FieldInfo) *DynamoDBExpressionDriver {
+	fieldMap := make(map[string]FieldInfo)
+	for _, f := range fields {
+		fieldMap[f.Name] = f
+	}
+	return &DynamoDBExpressionDriver{fields: fieldMap}
+}
+
+// DynamoDBExpression is a Lucene query rendered as a DynamoDB condition expression with #sN attribute names
+// and :sN attribute values.
+type DynamoDBExpression struct {
+	// Keys holds the value the query requires for each key field it was parsed with, the terms comparing
+	// them are left out of Condition so they can form the key condition of a Query instead.
+	Keys      map[string]any
+	Condition string
+	Names     map[string]string
+	Values    map[string]types.AttributeValue
+}
+
+// dynamoDBRender accumulates the attribute names and values of a single rendering.
+type dynamoDBRender struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+// name registers each part of a field.subfield path as an attribute name and returns the path of placeholders.
+func (r *dynamoDBRender) name(path string) string {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		placeholder := ""
+		for p, n := range r.names {
+			if n == part {
+				placeholder = p
+				break
+			}
+		}
+		if placeholder == "" {
+			placeholder = fmt.Sprintf("#s%d", len(r.names))
+			r.names[placeholder] = part
+		}
+		parts[i] = placeholder
+	}
+	return strings.Join(parts, ".")
+}
+
+// value registers value as an attribute value and returns its placeholder.
+func (r *dynamoDBRender) value(value any) (string, error) {
+	av, err := attributevalue.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal search value %v: %w", value, err)
+	}
+	placeholder := fmt.Sprintf(":s%d", len(r.values))
+	r.values[placeholder] = av
+	return placeholder, nil
+}
+
+// RenderDynamoDBExpression takes the terms comparing keyFields for equality out of the AND chain of the
+// expression, as RequiredFields finds them, and renders the rest to a condition expression. Literal values keep
+// their type, so numbers are matched against number attributes.
+func (d *DynamoDBExpressionDriver) RenderDynamoDBExpression(e *expr.Expression, keyFields ...string) (*DynamoDBExpression, error) {
+	result := &DynamoDBExpression{Keys: map[string]any{}}
+	for _, field := range keyFields {
+		var value any
+		var found bool
+		e, value, found = extractEquality(e, field)
+		if found {
+			result.Keys[field] = value
+		}
+	}
+	if e == nil {
+		return result, nil
+	}
+
+	r := &dynamoDBRender{names: map[string]string{}, values: map[string]types.AttributeValue{}}
+	condition, err := d.render(r, e)
+	if err != nil {
+		return nil, err
+	}
+	result.Condition, result.Names, result.Values = condition, r.names, r.values
+	return result, nil
+}
+
+// extractEquality removes the first term of the AND chain of e comparing field for equality with a value, and
+// returns what is left of e and that value. Null values can't be matched with a key condition and are left in e.
+func extractEquality(e *expr.Expression, field string) (*expr.Expression, any, bool) {
+	if e == nil {
+		return nil, nil, false
+	}
+	switch e.Op {
+	case expr.And:
+		for i, operand := range []any{e.Left, e.Right} {
+			child, ok := operand.(*expr.Expression)
+			if !ok {
+				continue
+			}
+			rest, value, found := extractEquality(child, field)
+			if !found {
+				continue
+			}
+			other := e.Right
+			if i == 1 {
+				other = e.Left
+			}
+			if rest == nil {
+				otherExpr, _ := other.(*expr.Expression)
+				return otherExpr, value, true
+			}
+			if i == 0 {
+				return &expr.Expression{Op: expr.And, Left: rest, Right: e.Right}, value, true
+			}
+			return &expr.Expression{Op: expr.And, Left: e.Left, Right: rest}, value, true
+		}
+	case expr.Equals:
+		left := e.Left
+		if literal, ok := left.(*expr.Expression); ok && literal.Op == expr.Literal {
+			left = literal.Left
+		}
+		if col, ok := left.(expr.Column); ok && string(col) == field && !isNullValue(e.Right) {
+			return nil, literalValue(e.Right), true
+		}
+	}
+	return e, nil, false
+}
+
+// render dispatches to specialized renderers based on operator type.
+func (d *DynamoDBExpressionDriver) render(r *dynamoDBRender, e *expr.Expression) (string, error) {
+	switch e.Op {
+	case expr.Like:
+		return d.renderLike(r, e)
+	case expr.Fuzzy:
+		return "", fmt.Errorf("fuzzy search (~) is not supported by DynamoDB")
+	case expr.Boost:
+		return "", fmt.Errorf("boost operator (^) is not supported in DynamoDB filtering; it only affects ranking/scoring")
+	case expr.Range:
+		return d.renderRange(r, e)
+	case expr.Equals, expr.Greater, expr.Less, expr.GreaterEq, expr.LessEq:
+		return d.renderComparison(r, e)
+	case expr.And, expr.Or:
+		left, err := d.renderOperand(r, e.Left, e.Op)
+		if err != nil {
+			return "", err
+		}
+		right, err := d.renderOperand(r, e.Right, e.Op)
+		if err != nil {
+			return "", err
+		}
+		if e.Op == expr.And {
+			return fmt.Sprintf("(%s) AND (%s)", left, right), nil
+		}
+		return fmt.Sprintf("(%s) OR (%s)", left, right), nil
+	case expr.Not, expr.MustNot:
+		left, err := d.renderOperand(r, e.Left, e.Op)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT (%s)", left), nil
+	case expr.Must:
+		return d.renderOperand(r, e.Left, e.Op)
+	default:
+		return "", fmt.Errorf("unsupported operator in DynamoDB query: %v", e.Op)
+	}
+}
+
+func (d *DynamoDBExpressionDriver) renderOperand(r *dynamoDBRender, operand any, op expr.Operator) (string, error) {
+	e, ok := operand.(*expr.Expression)
+	if !ok || e == nil {
+		return "", fmt.Errorf("%s operator requires expression operands", op)
+	}
+	return d.render(r, e)
+}
+
+// renderComparison handles comparison operators, treating null as a missing or null attribute.
+func (d *DynamoDBExpressionDriver) renderComparison(r *dynamoDBRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(r, e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	if isNullValue(e.Right) {
+		if e.Op == expr.Equals {
+			null, err := r.value("NULL")
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("(attribute_not_exists(%s) OR attribute_type(%s, %s))", col, col, null), nil
+		}
+		return "", fmt.Errorf("cannot use comparison operators (>, <, >=, <=) with null value")
+	}
+
+	var opSymbol string
+	switch e.Op {
+	case expr.Equals:
+		opSymbol = "="
+	case expr.Greater:
+		opSymbol = ">"
+	case expr.Less:
+		opSymbol = "<"
+	case expr.GreaterEq:
+		opSymbol = ">="
+	case expr.LessEq:
+		opSymbol = "<="
+	}
+	value, err := r.value(literalValue(e.Right))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", col, opSymbol, value), nil
+}
+
+// renderLike converts prefix* patterns to begins_with and *infix* and *suffix patterns to contains, which is case
+// sensitive. DynamoDB has no pattern matching so other wildcards and regular expressions aren't supported.
+func (d *DynamoDBExpressionDriver) renderLike(r *dynamoDBRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(r, e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	pattern := extractLiteralValue(e.Right)
+	if right, ok := e.Right.(*expr.Expression); ok && right.Op == expr.Regexp {
+		return "", fmt.Errorf("regular expressions are not supported by DynamoDB")
+	}
+
+	inner := strings.Trim(pattern, "*")
+	if inner == "" || strings.ContainsAny(inner, "*?") {
+		return "", fmt.Errorf("wildcard pattern %q is not supported by DynamoDB, only prefix*, *suffix and *infix* are", pattern)
+	}
+	value, err := r.value(inner)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(pattern, "*") {
+		return fmt.Sprintf("contains(%s, %s)", col, value), nil
+	}
+	return fmt.Sprintf("begins_with(%s, %s)", col, value), nil
+}
+
+// renderRange handles range queries including open-ended ranges with wildcards (*).
+func (d *DynamoDBExpressionDriver) renderRange(r *dynamoDBRender, e *expr.Expression) (string, error) {
+	col, err := d.serializeColumn(r, e.Left)
+	if err != nil {
+		return "", err
+	}
+
+	rangeBoundary, ok := e.Right.(*expr.RangeBoundary)
+	if !ok {
+		return "", fmt.Errorf("invalid range expression structure: expected *expr.RangeBoundary, got %T", e.Right)
+	}
+
+	minOpen := rangeBoundary.Min == nil || extractLiteralValue(rangeBoundary.Min) == "*"
+	maxOpen := rangeBoundary.Max == nil || extractLiteralValue(rangeBoundary.Max) == "*"
+	if minOpen && maxOpen {
+		return "", fmt.Errorf("both range bounds cannot be wildcards")
+	}
+
+	greater, less := ">", "<"
+	if rangeBoundary.Inclusive {
+		greater, less = ">=", "<="
+	}
+
+	if minOpen {
+		max, err := r.value(literalValue(rangeBoundary.Max))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", col, less, max), nil
+	}
+	min, err := r.value(literalValue(rangeBoundary.Min))
+	if err != nil {
+		return "", err
+	}
+	if maxOpen {
+		return fmt.Sprintf("%s %s %s", col, greater, min), nil
+	}
+	max, err := r.value(literalValue(rangeBoundary.Max))
+	if err != nil {
+		return "", err
+	}
+	if rangeBoundary.Inclusive {
+		return fmt.Sprintf("%s BETWEEN %s AND %s", col, min, max), nil
+	}
+	return fmt.Sprintf("(%s > %s AND %s < %s)", col, min, col, max), nil
+}
+
+// serializeColumn renders a column as a document path of attribute names, so field.subfield becomes #s0.#s1.
+func (d *DynamoDBExpressionDriver) serializeColumn(r *dynamoDBRender, in any) (string, error) {
+	switch v := in.(type) {
+	case expr.Column:
+		return r.name(string(v)), nil
+	case *expr.Expression:
+		col, ok := v.Left.(expr.Column)
+		if v.Op != expr.Literal || !ok {
+			return "", fmt.Errorf("unexpected column expression: %v", v)
+		}
+		return r.name(string(col)), nil
+	default:
+		return "", fmt.Errorf("unexpected column type: %T", v)
+	}
+}
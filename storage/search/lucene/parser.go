@@ -6,7 +6,9 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	lucene "github.com/grindlemire/go-lucene"
 	"github.com/grindlemire/go-lucene/pkg/lucene/expr"
@@ -23,6 +25,7 @@ const (
 type FieldInfo struct {
 	Name           string
 	IsJSONB        bool
+	IsNested       bool // Whether this field holds an object whose sub-fields can be queried on document stores (Cosmos DB)
 	ImplicitSearch bool // Whether this field is included in unfielded/implicit queries
 }
 
@@ -36,12 +39,15 @@ type Parser struct {
 	MaxTerms       int // Maximum number of terms (default: 100)
 
 	// Field lookup maps for O(1) validation
-	fieldMap    map[string]FieldInfo // All fields by name
-	jsonbFields map[string]bool      // JSONB field names for sub-field validation
+	fieldMap     map[string]FieldInfo // All fields by name
+	jsonbFields  map[string]bool      // JSONB field names for sub-field validation
+	nestedFields map[string]bool      // JSONB and nested object field names for document store sub-field validation
 
 	// Custom drivers for different backends
-	postgresDriver *PostgresJSONBDriver
-	dynamoDriver   *DynamoDBPartiQLDriver
+	postgresDriver   *PostgresJSONBDriver
+	dynamoDriver     *DynamoDBPartiQLDriver
+	dynamoExprDriver *DynamoDBExpressionDriver
+	cosmosDriver     *CosmosSQLDriver
 }
 
 // NewParserFromType creates a parser by introspecting a struct's fields.
@@ -69,6 +75,7 @@ type Parser struct {
 // - lucene:"implicit" - Force ImplicitSearch=true (include in unfielded queries)
 // - lucene:"explicit" - Force ImplicitSearch=false (require field:value syntax)
 // - gorm:"type:jsonb" - Auto-detected as JSONB field
+// - struct and map fields - Auto-detected as nested, their sub-fields are searchable on Cosmos DB
 //
 // Auto-detection rules (when no lucene tag):
 // - String fields: ImplicitSearch=true (included in unfielded queries)
@@ -85,22 +92,29 @@ func NewParserFromType(model any) (*Parser, error) {
 func NewParser(fields []FieldInfo) *Parser {
 	fieldMap := make(map[string]FieldInfo, len(fields))
 	jsonbFields := make(map[string]bool)
+	nestedFields := make(map[string]bool)
 	for _, f := range fields {
 		fieldMap[f.Name] = f
 		if f.IsJSONB {
 			jsonbFields[f.Name] = true
 		}
+		if f.IsJSONB || f.IsNested {
+			nestedFields[f.Name] = true
+		}
 	}
 
 	return &Parser{
-		Fields:         fields,
-		MaxQueryLength: DefaultMaxQueryLength,
-		MaxDepth:       DefaultMaxDepth,
-		MaxTerms:       DefaultMaxTerms,
-		fieldMap:       fieldMap,
-		jsonbFields:    jsonbFields,
-		postgresDriver: NewPostgresJSONBDriver(fields),
-		dynamoDriver:   NewDynamoDBPartiQLDriver(fields),
+		Fields:           fields,
+		MaxQueryLength:   DefaultMaxQueryLength,
+		MaxDepth:         DefaultMaxDepth,
+		MaxTerms:         DefaultMaxTerms,
+		fieldMap:         fieldMap,
+		jsonbFields:      jsonbFields,
+		nestedFields:     nestedFields,
+		postgresDriver:   NewPostgresJSONBDriver(fields),
+		dynamoDriver:     NewDynamoDBPartiQLDriver(fields),
+		dynamoExprDriver: NewDynamoDBExpressionDriver(fields),
+		cosmosDriver:     NewCosmosSQLDriver(fields),
 	}
 }
 
@@ -161,9 +175,17 @@ func getStructFields(model any) ([]FieldInfo, error) {
 			implicitSearch = field.Type.Kind() == reflect.String && !isJSONB
 		}
 
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		isNested := fieldType.Kind() == reflect.Map ||
+			(fieldType.Kind() == reflect.Struct && fieldType != reflect.TypeOf(time.Time{}))
+
 		fields = append(fields, FieldInfo{
 			Name:           jsonTag,
 			IsJSONB:        isJSONB,
+			IsNested:       isNested,
 			ImplicitSearch: implicitSearch,
 		})
 	}
@@ -250,6 +272,106 @@ func (p *Parser) ParseToDynamoDBPartiQL(query string) (string, []types.Attribute
 	return partiql, attrs, nil
 }
 
+// ParseToDynamoDBExpression parses a Lucene query and converts it to a DynamoDB condition expression.
+// The values the query requires for keyFields are returned in Keys rather than in the condition, see
+// RenderDynamoDBExpression.
+func (p *Parser) ParseToDynamoDBExpression(query string, keyFields ...string) (*DynamoDBExpression, error) {
+	slog.Debug(fmt.Sprintf(`Parsing query to DynamoDB condition expression: %s`, query))
+
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+
+	// Expand implicit terms first (for validation of the full query)
+	expandedQuery := p.expandImplicitTerms(query)
+
+	// Validate all field references exist in the model, allowing sub-fields of nested objects
+	if err := p.validateFields(expandedQuery, p.nestedFields); err != nil {
+		return nil, err
+	}
+
+	// Parse using the library
+	e, err := p.parseWithImplicitSearch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render using custom DynamoDB condition expression driver
+	return p.dynamoExprDriver.RenderDynamoDBExpression(e, keyFields...)
+}
+
+// ParseToCosmosSQL parses a Lucene query and converts it to a Cosmos DB SQL condition over the container alias c.
+// Sub-fields of nested objects can be queried with field.subfield syntax.
+func (p *Parser) ParseToCosmosSQL(query string) (string, []azcosmos.QueryParameter, error) {
+	slog.Debug(fmt.Sprintf(`Parsing query to Cosmos DB SQL: %s`, query))
+
+	if err := p.validateQuery(query); err != nil {
+		return "", nil, err
+	}
+
+	// Expand implicit terms first (for validation of the full query)
+	expandedQuery := p.expandImplicitTerms(query)
+
+	// Validate all field references exist in the model, allowing sub-fields of nested objects
+	if err := p.validateFields(expandedQuery, p.nestedFields); err != nil {
+		return "", nil, err
+	}
+
+	// Parse using the library
+	e, err := p.parseWithImplicitSearch(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Render using custom Cosmos DB driver
+	sql, params, err := p.cosmosDriver.RenderCosmosSQL(e)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sql, params, nil
+}
+
+// RequiredFields returns the fields the query compares for equality in terms joined to the rest of the query
+// by AND, which every match has to satisfy. Storage backends use them to narrow a search down to a key lookup.
+func (p *Parser) RequiredFields(query string) ([]string, error) {
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+
+	e, err := p.parseWithImplicitSearch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+	var collect func(e *expr.Expression)
+	collect = func(e *expr.Expression) {
+		if e == nil {
+			return
+		}
+		switch e.Op {
+		case expr.And:
+			if left, ok := e.Left.(*expr.Expression); ok {
+				collect(left)
+			}
+			if right, ok := e.Right.(*expr.Expression); ok {
+				collect(right)
+			}
+		case expr.Equals:
+			left := e.Left
+			if literal, ok := left.(*expr.Expression); ok && literal.Op == expr.Literal {
+				left = literal.Left
+			}
+			if col, ok := left.(expr.Column); ok {
+				fields = append(fields, string(col))
+			}
+		}
+	}
+	collect(e)
+	return fields, nil
+}
+
 func (p *Parser) validateQuery(query string) error {
 	if len(query) > p.MaxQueryLength {
 		return fmt.Errorf("query too long: %d bytes exceeds maximum of %d bytes", len(query), p.MaxQueryLength)
@@ -405,6 +527,11 @@ func countTerms(query string) int {
 
 // ValidateFields returns InvalidFieldError if the query references non-existent fields.
 func (p *Parser) ValidateFields(query string) error {
+	return p.validateFields(query, p.jsonbFields)
+}
+
+// validateFields validates the field references of query, only fields in subFieldParents accept sub-field notation.
+func (p *Parser) validateFields(query string, subFieldParents map[string]bool) error {
 	matches := fieldExtractPattern.FindAllStringSubmatchIndex(query, -1)
 	if len(matches) == 0 {
 		return nil
@@ -425,7 +552,7 @@ func (p *Parser) ValidateFields(query string) error {
 
 		fieldName := query[fieldStart:fieldEnd]
 
-		if err := p.validateFieldName(fieldName); err != nil {
+		if err := p.validateFieldName(fieldName, subFieldParents); err != nil {
 			return &InvalidFieldError{
 				Field:       fieldName,
 				ValidFields: validFields,
@@ -452,7 +579,7 @@ func isInsideQuotes(query string, pos int) bool {
 }
 
 // validateFieldName validates both simple fields (name) and JSONB sub-fields (labels.category).
-func (p *Parser) validateFieldName(fieldName string) error {
+func (p *Parser) validateFieldName(fieldName string, subFieldParents map[string]bool) error {
 	if strings.Contains(fieldName, ".") {
 		parts := strings.SplitN(fieldName, ".", 2)
 		if len(parts) != 2 {
@@ -461,7 +588,7 @@ func (p *Parser) validateFieldName(fieldName string) error {
 
 		baseField := parts[0]
 
-		if !p.jsonbFields[baseField] {
+		if !subFieldParents[baseField] {
 			if _, exists := p.fieldMap[baseField]; !exists {
 				return fmt.Errorf("field '%s' does not exist", baseField)
 			}
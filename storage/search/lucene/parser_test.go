@@ -2,8 +2,11 @@ package lucene
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // TestBasicFieldSearch tests basic field:value queries
@@ -878,3 +881,295 @@ func TestBoostOperatorError(t *testing.T) {
 		})
 	}
 }
+
+// TestCosmosSQL tests conversion of Lucene queries to Cosmos DB SQL conditions
+func TestCosmosSQL(t *testing.T) {
+	fields := []FieldInfo{
+		{Name: "name", IsJSONB: false, ImplicitSearch: true},
+		{Name: "age", IsJSONB: false},
+		{Name: "deleted_at", IsJSONB: false},
+		{Name: "address", IsNested: true},
+	}
+	parser := NewParser(fields)
+
+	tests := []struct {
+		name     string
+		query    string
+		wantSQL  string
+		wantVals []any
+		wantErr  string
+	}{
+		{
+			name:     "simple field query",
+			query:    "name:john",
+			wantSQL:  `c["name"] = @search0`,
+			wantVals: []any{"john"},
+		},
+		{
+			name:     "wildcard prefix",
+			query:    "name:john*",
+			wantSQL:  `STARTSWITH(c["name"], @search0, true)`,
+			wantVals: []any{"john"},
+		},
+		{
+			name:     "wildcard suffix",
+			query:    "name:*john",
+			wantSQL:  `ENDSWITH(c["name"], @search0, true)`,
+			wantVals: []any{"john"},
+		},
+		{
+			name:     "wildcard contains",
+			query:    "name:*john*",
+			wantSQL:  `CONTAINS(c["name"], @search0, true)`,
+			wantVals: []any{"john"},
+		},
+		{
+			name:     "single character wildcard",
+			query:    "name:j?hn*",
+			wantSQL:  `RegexMatch(c["name"], @search0, 'i')`,
+			wantVals: []any{"^j.hn.*$"},
+		},
+		{
+			name:     "implicit search",
+			query:    "john",
+			wantSQL:  `CONTAINS(c["name"], @search0, true)`,
+			wantVals: []any{"john"},
+		},
+		{
+			name:     "inclusive range keeps numbers",
+			query:    "age:[18 TO 65]",
+			wantSQL:  `(c["age"] >= @search0 AND c["age"] <= @search1)`,
+			wantVals: []any{18, 65},
+		},
+		{
+			name:     "exclusive open-ended range",
+			query:    "age:{18 TO *}",
+			wantSQL:  `c["age"] > @search0`,
+			wantVals: []any{18},
+		},
+		{
+			name:    "null check",
+			query:   "deleted_at:null",
+			wantSQL: `(NOT IS_DEFINED(c["deleted_at"]) OR IS_NULL(c["deleted_at"]))`,
+		},
+		{
+			name:     "nested field",
+			query:    "address.city:london",
+			wantSQL:  `c["address"]["city"] = @search0`,
+			wantVals: []any{"london"},
+		},
+		{
+			name:     "boolean operators",
+			query:    "name:john AND NOT age:30",
+			wantSQL:  `(c["name"] = @search0) AND (NOT (c["age"] = @search1))`,
+			wantVals: []any{"john", 30},
+		},
+		{
+			name:    "sub-field of a non nested field",
+			query:   "name.first:john",
+			wantErr: "invalid field",
+		},
+		{
+			name:    "fuzzy search",
+			query:   "name:jon~1",
+			wantErr: "not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, params, err := parser.ParseToCosmosSQL(tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseToCosmosSQL(%q) error = %v, want to contain %v", tt.query, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseToCosmosSQL(%q) error = %v", tt.query, err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("ParseToCosmosSQL(%q) sql = %v, want %v", tt.query, sql, tt.wantSQL)
+			}
+			if len(params) != len(tt.wantVals) {
+				t.Fatalf("ParseToCosmosSQL(%q) params = %v, want %v", tt.query, params, tt.wantVals)
+			}
+			for i, want := range tt.wantVals {
+				if params[i].Name != fmt.Sprintf("@search%d", i) || params[i].Value != want {
+					t.Errorf("ParseToCosmosSQL(%q) param %d = %v, want %v", tt.query, i, params[i], want)
+				}
+			}
+		})
+	}
+}
+
+// TestDynamoDBExpression tests rendering queries to DynamoDB condition expressions with their key terms taken out
+func TestDynamoDBExpression(t *testing.T) {
+	fields := []FieldInfo{
+		{Name: "tenant", IsJSONB: false},
+		{Name: "name", IsJSONB: false, ImplicitSearch: true},
+		{Name: "age", IsJSONB: false},
+		{Name: "deleted_at", IsJSONB: false},
+		{Name: "address", IsNested: true},
+	}
+	parser := NewParser(fields)
+
+	tests := []struct {
+		name          string
+		query         string
+		keyFields     []string
+		wantKeys      map[string]any
+		wantCondition string
+		wantNames     map[string]string
+		wantValues    map[string]types.AttributeValue
+		wantErr       string
+	}{
+		{
+			name:          "key term only",
+			query:         "tenant:acme",
+			keyFields:     []string{"tenant"},
+			wantKeys:      map[string]any{"tenant": "acme"},
+			wantCondition: "",
+		},
+		{
+			name:          "key terms and filter",
+			query:         "tenant:acme AND age:30 AND name:jo*",
+			keyFields:     []string{"tenant", "age"},
+			wantKeys:      map[string]any{"tenant": "acme", "age": 30},
+			wantCondition: "begins_with(#s0, :s0)",
+			wantNames:     map[string]string{"#s0": "name"},
+			wantValues:    map[string]types.AttributeValue{":s0": &types.AttributeValueMemberS{Value: "jo"}},
+		},
+		{
+			name:          "key term under a disjunction stays in the condition",
+			query:         "tenant:acme OR name:john",
+			keyFields:     []string{"tenant"},
+			wantKeys:      map[string]any{},
+			wantCondition: "(#s0 = :s0) OR (#s1 = :s1)",
+			wantNames:     map[string]string{"#s0": "tenant", "#s1": "name"},
+			wantValues: map[string]types.AttributeValue{
+				":s0": &types.AttributeValueMemberS{Value: "acme"},
+				":s1": &types.AttributeValueMemberS{Value: "john"},
+			},
+		},
+		{
+			name:          "inclusive range keeps numbers",
+			query:         "tenant:acme AND age:[18 TO 65]",
+			keyFields:     []string{"tenant"},
+			wantKeys:      map[string]any{"tenant": "acme"},
+			wantCondition: "#s0 BETWEEN :s0 AND :s1",
+			wantNames:     map[string]string{"#s0": "age"},
+			wantValues: map[string]types.AttributeValue{
+				":s0": &types.AttributeValueMemberN{Value: "18"},
+				":s1": &types.AttributeValueMemberN{Value: "65"},
+			},
+		},
+		{
+			name:          "null check and nested field",
+			query:         "deleted_at:null AND address.city:london",
+			wantKeys:      map[string]any{},
+			wantCondition: "((attribute_not_exists(#s0) OR attribute_type(#s0, :s0))) AND (#s1.#s2 = :s1)",
+			wantNames:     map[string]string{"#s0": "deleted_at", "#s1": "address", "#s2": "city"},
+			wantValues: map[string]types.AttributeValue{
+				":s0": &types.AttributeValueMemberS{Value: "NULL"},
+				":s1": &types.AttributeValueMemberS{Value: "london"},
+			},
+		},
+		{
+			name:    "single character wildcard",
+			query:   "name:j?hn*",
+			wantErr: "not supported",
+		},
+		{
+			name:    "fuzzy search",
+			query:   "name:jon~1",
+			wantErr: "not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.ParseToDynamoDBExpression(tt.query, tt.keyFields...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseToDynamoDBExpression(%q) error = %v, want to contain %v", tt.query, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseToDynamoDBExpression(%q) error = %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got.Keys, tt.wantKeys) {
+				t.Errorf("ParseToDynamoDBExpression(%q) keys = %v, want %v", tt.query, got.Keys, tt.wantKeys)
+			}
+			if got.Condition != tt.wantCondition {
+				t.Errorf("ParseToDynamoDBExpression(%q) condition = %v, want %v", tt.query, got.Condition, tt.wantCondition)
+			}
+			if len(got.Names) != len(tt.wantNames) || (len(tt.wantNames) > 0 && !reflect.DeepEqual(got.Names, tt.wantNames)) {
+				t.Errorf("ParseToDynamoDBExpression(%q) names = %v, want %v", tt.query, got.Names, tt.wantNames)
+			}
+			if len(got.Values) != len(tt.wantValues) || (len(tt.wantValues) > 0 && !reflect.DeepEqual(got.Values, tt.wantValues)) {
+				t.Errorf("ParseToDynamoDBExpression(%q) values = %v, want %v", tt.query, got.Values, tt.wantValues)
+			}
+		})
+	}
+}
+
+// TestRequiredFields tests finding the fields every match of a query is equal to
+func TestRequiredFields(t *testing.T) {
+	fields := []FieldInfo{
+		{Name: "tenant", IsJSONB: false},
+		{Name: "name", IsJSONB: false, ImplicitSearch: true},
+		{Name: "age", IsJSONB: false},
+	}
+	parser := NewParser(fields)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantFields []string
+	}{
+		{
+			name:       "single term",
+			query:      "tenant:acme",
+			wantFields: []string{"tenant"},
+		},
+		{
+			name:       "conjunction",
+			query:      "tenant:acme AND name:john AND age:[18 TO 30]",
+			wantFields: []string{"tenant", "name"},
+		},
+		{
+			name:       "disjunction",
+			query:      "tenant:acme OR name:john",
+			wantFields: []string{},
+		},
+		{
+			name:       "grouped disjunction",
+			query:      "tenant:acme AND (name:john OR name:jane)",
+			wantFields: []string{"tenant"},
+		},
+		{
+			name:       "negation and wildcard",
+			query:      "NOT tenant:acme AND name:jo*",
+			wantFields: []string{},
+		},
+		{
+			name:       "implicit term",
+			query:      "john",
+			wantFields: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.RequiredFields(tt.query)
+			if err != nil {
+				t.Fatalf("RequiredFields(%q) error = %v", tt.query, err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("RequiredFields(%q) = %v, want %v", tt.query, got, tt.wantFields)
+			}
+		})
+	}
+}
@@ -2,19 +2,34 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"os"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 
@@ -25,31 +40,91 @@ import (
 
 type queryBuilder func(*gorm.DB) *gorm.DB
 
+const SQL_BATCH_SIZE = 100
+
+// Config keys used by the SQLAdapter itself, they aren't passed on to the database in the connection string
+var sqlAdapterOptions = []string{
+	"provider", "schema", "path",
+	"max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time", "query_timeout",
+	"tls", "tls_ca", "tls_cert", "tls_key",
+	"replicas", "replica_policy", "replica_check_interval", "ttl_sweep_interval",
+}
+
+var mysqlTLSConfigCount atomic.Int64
+
 type SQLAdapter struct {
 	DB       *gorm.DB
 	config   map[string]string
 	provider StorageProviders
+	replicas *sqlReplicaSet
+	retrier  *retrier
+	sweeper  *sqlExpirySweeper
 }
 
 var sqlAdapterLock = &sync.Mutex{}
 var sqlAdapterInstance *SQLAdapter
 
+// GetSQLAdapterInstance returns the process-wide SQLAdapter, exiting the process if it can't connect
 func GetSQLAdapterInstance(config map[string]string) *SQLAdapter {
+	s, err := getSQLAdapterInstance(config)
+	if err != nil {
+		slogger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+	return s
+}
+
+func getSQLAdapterInstance(config map[string]string) (*SQLAdapter, error) {
+	sqlAdapterLock.Lock()
+	defer sqlAdapterLock.Unlock()
 	if sqlAdapterInstance == nil {
-		sqlAdapterLock.Lock()
-		defer sqlAdapterLock.Unlock()
-		if sqlAdapterInstance == nil {
-			sqlAdapterInstance = &SQLAdapter{config: config}
-			sqlAdapterInstance.OpenConnection()
+		s, err := NewSQLAdapter(config)
+		if err != nil {
+			return nil, err
 		}
+		sqlAdapterInstance = s
 	}
-	return sqlAdapterInstance
+	return sqlAdapterInstance, nil
 }
 
+// NewSQLAdapter returns a new SQLAdapter with its own connection pool, unlike GetSQLAdapterInstance
+// which returns a process-wide instance
+func NewSQLAdapter(config map[string]string) (*SQLAdapter, error) {
+	s := &SQLAdapter{config: copyConfig(config)}
+	if err := s.Connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the adapter's connection pools, including those of its replicas
+func (s *SQLAdapter) Close() error {
+	db, err := s.DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection pool: %v", err)
+	}
+	s.sweeper.close()
+	return errors.Join(s.replicas.close(), db.Close())
+}
+
+// OpenConnection connects to the database, exiting the process if it can't
 func (s *SQLAdapter) OpenConnection() {
+	if err := s.Connect(); err != nil {
+		slogger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+	}
+}
+
+// Connect opens the adapter's connection pool
+func (s *SQLAdapter) Connect() error {
 	var err error
 	s.provider = StorageProviders(s.config["provider"])
-	delete(s.config, "provider")
+	if s.retrier, err = newRetrier(s.config, classifySQLError); err != nil {
+		return err
+	}
+	// The sweeper only starts once a model with an expires_at field is used, creating it first validates its
+	// settings before any connection is opened
+	if err = s.newExpirySweeper(); err != nil {
+		return err
+	}
 
 	gormConf := gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
@@ -64,15 +139,17 @@ func (s *SQLAdapter) OpenConnection() {
 		dsn := new(bytes.Buffer)
 
 		for key, value := range s.config {
-			if key != "schema" {
+			if !slices.Contains(sqlAdapterOptions, key) && !slices.Contains(retryOptions, key) {
 				fmt.Fprintf(dsn, "%s=%s ", key, value)
 			}
 		}
-		s.DB, err = gorm.Open(postgres.New(postgres.Config{DSN: dsn.String(), PreferSimpleProtocol: true}), &gormConf)
+		s.DB, err = gorm.Open(s.dialector(dsn.String()), &gormConf)
 	case MYSQL:
-		dsn := new(bytes.Buffer)
-		fmt.Fprintf(dsn, "%s:%s@tcp(%s:%s)/%s", s.config["user"], s.config["password"], s.config["host"], s.config["port"], s.config["dbname"])
-		s.DB, err = gorm.Open(mysql.New(mysql.Config{DSN: dsn.String()}), &gormConf)
+		dsn, dsnErr := s.mysqlDSN()
+		if dsnErr != nil {
+			return dsnErr
+		}
+		s.DB, err = gorm.Open(s.dialector(dsn), &gormConf)
 	case SQLITE:
 		path := "file::memory:?cache=shared"
 		if s.config["path"] != "" {
@@ -80,29 +157,259 @@ func (s *SQLAdapter) OpenConnection() {
 		}
 		s.DB, err = gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
 	default:
-		slogger.Fatal("this SQL provider is not supported, supported providers are: postgresql, mysql, and sqlite")
+		return fmt.Errorf("the SQL provider '%s' is not supported, supported providers are: postgresql, mysql, and sqlite", s.provider)
+	}
 
+	if err != nil {
+		return fmt.Errorf("failed to open a database connection: %v", err)
+	}
+	if err = s.configureDB(s.DB); err == nil {
+		err = s.openReplicas(gormConf)
 	}
+	if err != nil {
+		s.replicas.close()
+		if db, dbErr := s.DB.DB(); dbErr == nil {
+			db.Close()
+		}
+		return err
+	}
+	return nil
+}
 
+// dialector returns the gorm dialector connecting to dsn with the adapter's provider
+func (s *SQLAdapter) dialector(dsn string) gorm.Dialector {
+	if s.provider == MYSQL {
+		return mysql.New(mysql.Config{DSN: dsn})
+	}
+	return postgres.New(postgres.Config{DSN: dsn, PreferSimpleProtocol: true})
+}
+
+// configureDB applies the connection pool and query timeout settings to db
+func (s *SQLAdapter) configureDB(db *gorm.DB) error {
+	if err := s.configurePool(db); err != nil {
+		return err
+	}
+	return s.registerQueryTimeout(db)
+}
+
+// mysqlDSN returns the MySQL connection string, see mysqlTLSConfig for its TLS settings
+func (s *SQLAdapter) mysqlDSN() (string, error) {
+	cfg := mysqldriver.NewConfig()
+	cfg.User = s.config["user"]
+	cfg.Passwd = s.config["password"]
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(s.config["host"], s.config["port"])
+	cfg.DBName = s.config["dbname"]
+	tlsConfig, err := s.mysqlTLSConfig(s.config["host"])
 	if err != nil {
-		slogger.Fatal("failed to open a database connection", slog.Any("error", err.Error()))
+		return "", err
 	}
+	cfg.TLSConfig = tlsConfig
+	return cfg.FormatDSN(), nil
 }
 
-func (s *SQLAdapter) Execute(statement string) error {
-	result := s.DB.Exec(statement)
-	if result.Error != nil {
-		return fmt.Errorf("failed to execute statement %s: %v", statement, result.Error)
+// mysqlTLSConfig returns the tls parameter of a MySQL connection to host. The tls option takes the values supported
+// by the driver (true, false, skip-verify or preferred), when tls_ca or tls_cert and tls_key are set a custom TLS
+// configuration using these files and verifying host is registered instead
+func (s *SQLAdapter) mysqlTLSConfig(host string) (string, error) {
+	if s.config["tls_ca"] == "" && s.config["tls_cert"] == "" {
+		return s.config["tls"], nil
+	}
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: s.config["tls"] == "skip-verify"}
+	if s.config["tls_ca"] != "" {
+		ca, err := os.ReadFile(s.config["tls_ca"])
+		if err != nil {
+			return "", fmt.Errorf("failed to read tls_ca: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return "", fmt.Errorf("failed to parse tls_ca %s", s.config["tls_ca"])
+		}
+	}
+	if s.config["tls_cert"] != "" {
+		cert, err := tls.LoadX509KeyPair(s.config["tls_cert"], s.config["tls_key"])
+		if err != nil {
+			return "", fmt.Errorf("failed to load tls_cert and tls_key: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	name := fmt.Sprintf("magic-%d", mysqlTLSConfigCount.Add(1))
+	if err := mysqldriver.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("failed to register TLS config: %v", err)
+	}
+	return name, nil
+}
+
+// configurePool applies the connection pool settings, settings that aren't configured keep the driver defaults
+func (s *SQLAdapter) configurePool(gormDB *gorm.DB) error {
+	db, err := gormDB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection pool: %v", err)
+	}
+	for key, apply := range map[string]func(string) error{
+		"max_open_conns": func(v string) error {
+			n, err := strconv.Atoi(v)
+			db.SetMaxOpenConns(n)
+			return err
+		},
+		"max_idle_conns": func(v string) error {
+			n, err := strconv.Atoi(v)
+			db.SetMaxIdleConns(n)
+			return err
+		},
+		"conn_max_lifetime": func(v string) error {
+			d, err := time.ParseDuration(v)
+			db.SetConnMaxLifetime(d)
+			return err
+		},
+		"conn_max_idle_time": func(v string) error {
+			d, err := time.ParseDuration(v)
+			db.SetConnMaxIdleTime(d)
+			return err
+		},
+	} {
+		if v := s.config[key]; v != "" {
+			if err := apply(v); err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", key, v, err)
+			}
+		}
 	}
 	return nil
 }
 
+// registerQueryTimeout makes every statement fail once it runs longer than the query_timeout option.
+// Contexts with an earlier deadline keep it. Row and Rows aren't covered since their results are read after the
+// callbacks return, leaving nothing to release the context, see rowsContext
+func (s *SQLAdapter) registerQueryTimeout(db *gorm.DB) error {
+	v := s.config["query_timeout"]
+	if v == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid query_timeout '%s': %v", v, err)
+	}
+
+	const cancelKey = "magic:query_timeout_cancel"
+	before := func(db *gorm.DB) {
+		ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(cancelKey, cancel)
+	}
+	after := func(db *gorm.DB) {
+		if cancel, ok := db.InstanceGet(cancelKey); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}
+
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("magic:query_timeout", before),
+		callbacks.Create().After("*").Register("magic:query_timeout_cancel", after),
+		callbacks.Query().Before("*").Register("magic:query_timeout", before),
+		callbacks.Query().After("*").Register("magic:query_timeout_cancel", after),
+		callbacks.Update().Before("*").Register("magic:query_timeout", before),
+		callbacks.Update().After("*").Register("magic:query_timeout_cancel", after),
+		callbacks.Delete().Before("*").Register("magic:query_timeout", before),
+		callbacks.Delete().After("*").Register("magic:query_timeout_cancel", after),
+		callbacks.Raw().Before("*").Register("magic:query_timeout", before),
+		callbacks.Raw().After("*").Register("magic:query_timeout_cancel", after),
+	)
+}
+
+// rowsContext bounds ctx by the query_timeout option for statements whose results are read through Row or Rows,
+// such as Scan. The returned cancel func must be called once the results are read
+func (s *SQLAdapter) rowsContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, err := time.ParseDuration(s.config["query_timeout"])
+	if err != nil || timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// classifySQLError maps unique constraint violations to ErrAlreadyExists, serialization failures and
+// deadlocks to ErrConflict, exhausted connection limits to ErrThrottled and connection failures, lock
+// timeouts and busy SQLite databases to ErrUnavailable. All but unique constraint violations are transient
+func classifySQLError(err error) (error, bool, time.Duration) {
+	if errors.Is(err, driver.ErrBadConn) {
+		return ErrUnavailable, true, 0
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAlreadyExists, false, 0
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505": // unique_violation
+			return ErrAlreadyExists, false, 0
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return ErrConflict, true, 0
+		case pgErr.Code == "53300": // too_many_connections
+			return ErrThrottled, true, 0
+		case pgErr.Code == "57P03", strings.HasPrefix(pgErr.Code, "08"): // cannot_connect_now, connection exceptions
+			return ErrUnavailable, true, 0
+		}
+		return nil, false, 0
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1062: // duplicate entry
+			return ErrAlreadyExists, false, 0
+		case 1213: // deadlock
+			return ErrConflict, true, 0
+		case 1040: // too many connections
+			return ErrThrottled, true, 0
+		case 1205: // lock wait timeout
+			return ErrUnavailable, true, 0
+		}
+		return nil, false, 0
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique, sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+			return ErrAlreadyExists, false, 0
+		case sqliteErr.Code == sqlite3.ErrBusy, sqliteErr.Code == sqlite3.ErrLocked:
+			return ErrUnavailable, true, 0
+		}
+	}
+	return nil, false, 0
+}
+
+// Stats returns the connection pool statistics of the primary database, e.g. for health checks and metrics
+func (s *SQLAdapter) Stats() (sql.DBStats, error) {
+	db, err := s.DB.DB()
+	if err != nil {
+		return sql.DBStats{}, fmt.Errorf("failed to get database connection pool: %v", err)
+	}
+	return db.Stats(), nil
+}
+
+func (s *SQLAdapter) Execute(statement string) error {
+	return s.ExecuteContext(context.Background(), statement)
+}
+
+func (s *SQLAdapter) ExecuteContext(ctx context.Context, statement string) error {
+	return s.retrier.do(ctx, false, func() error {
+		result := s.DB.WithContext(ctx).Exec(statement)
+		if result.Error != nil {
+			return fmt.Errorf("failed to execute statement %s: %w", statement, result.Error)
+		}
+		return nil
+	})
+}
+
 func (s *SQLAdapter) Ping() error {
+	return s.PingContext(context.Background())
+}
+
+func (s *SQLAdapter) PingContext(ctx context.Context) error {
 	db, err := s.DB.DB()
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
-	return db.Ping()
+	return db.PingContext(ctx)
 }
 
 func (s *SQLAdapter) GetType() StorageAdapterType {
@@ -168,61 +475,387 @@ func (s *SQLAdapter) GetLatestMigration() (int, error) {
 }
 
 func (s *SQLAdapter) Create(item any, params ...map[string]any) error {
-	result := s.DB.Create(reflect.ValueOf(item).Interface())
-	return result.Error
+	return s.CreateContext(context.Background(), item, params...)
+}
+
+func (s *SQLAdapter) CreateContext(ctx context.Context, item any, params ...map[string]any) error {
+	return s.retrier.do(ctx, false, func() error {
+		if err := initVersion(item); err != nil {
+			return err
+		}
+		if err := applyTTL(item, params...); err != nil {
+			return err
+		}
+		s.sweeper.track(item)
+		result := s.DB.WithContext(ctx).Create(reflect.ValueOf(item).Interface())
+		return result.Error
+	})
 }
 
 func (s *SQLAdapter) Get(dest any, filter map[string]any, params ...map[string]any) error {
-	if len(filter) == 0 {
-		return errors.New("filtering is required when getting a resource")
-	}
-	query, bindings := s.buildQuery(filter)
-	result := s.DB.Where(query, bindings).Find(dest)
-	if result.RowsAffected == 0 {
-		return ErrNotFound
-	}
-	return result.Error
+	return s.GetContext(context.Background(), dest, filter, params...)
+}
+
+func (s *SQLAdapter) GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when getting a resource")
+		}
+		live, err := s.liveItems(dest, params...)
+		if err != nil {
+			return err
+		}
+		query, bindings := s.buildQuery(filter)
+		result := s.reader(params...).WithContext(ctx).Scopes(live).Where(query, bindings).Find(dest)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
 }
 
 func (s *SQLAdapter) Update(item any, filter map[string]any, params ...map[string]any) error {
-	if len(filter) == 0 {
-		return errors.New("filtering is required when updating a resource")
+	return s.UpdateContext(context.Background(), item, filter, params...)
+}
+
+func (s *SQLAdapter) UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when updating a resource")
+		}
+		query, bindings := s.buildQuery(filter)
+		if err := applyTTL(item, params...); err != nil {
+			return err
+		}
+		s.sweeper.track(item)
+
+		version, versioned, err := getVersion(item)
+		if err != nil {
+			return err
+		}
+		if !versioned {
+			result := s.DB.WithContext(ctx).Where(query, bindings).Save(item)
+			return result.Error
+		}
+
+		// Save falls back to an upsert when no row matches, which would defeat the version check, so
+		// versioned items are updated with a conditional UPDATE instead
+		column, err := s.columnName(item, getModelMetadata(item).field("version").Name)
+		if err != nil {
+			return err
+		}
+		setVersion(item, version+1)
+		result := s.DB.WithContext(ctx).Model(item).
+			Where(query, bindings).
+			Where(fmt.Sprintf("%s = ?", column), version).
+			Select("*").
+			Updates(item)
+		if result.Error != nil || result.RowsAffected == 0 {
+			setVersion(item, version)
+			if result.Error != nil {
+				return result.Error
+			}
+			return ErrConflict
+		}
+		return nil
+	})
+}
+
+// modelSchema returns the gorm schema of model, which may be a struct, a slice of structs or a pointer to either
+func (s *SQLAdapter) modelSchema(model any) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: s.DB}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("failed to parse model: %v", err)
 	}
-	query, bindings := s.buildQuery(filter)
-	result := s.DB.Where(query, bindings).Save(item)
-	return result.Error
+	return stmt.Schema, nil
+}
+
+// columnName returns the database column gorm maps the Go field fieldName of model to
+func (s *SQLAdapter) columnName(model any, fieldName string) (string, error) {
+	modelSchema, err := s.modelSchema(model)
+	if err != nil {
+		return "", err
+	}
+	field := modelSchema.LookUpField(fieldName)
+	if field == nil {
+		return "", fmt.Errorf("field %s not found in model", fieldName)
+	}
+	return field.DBName, nil
+}
+
+// storedFieldName returns the column of the Go field goName of model, see fieldMapper
+func (s *SQLAdapter) storedFieldName(model any, goName string) (string, bool) {
+	column, err := s.columnName(model, goName)
+	return column, err == nil && column != ""
 }
 
 func (s *SQLAdapter) Delete(item any, filter map[string]any, params ...map[string]any) error {
-	if len(filter) == 0 {
-		return errors.New("filtering is required when deleting a resource")
+	return s.DeleteContext(context.Background(), item, filter, params...)
+}
+
+func (s *SQLAdapter) DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when deleting a resource")
+		}
+		f, err := softDeleteField(item)
+		if err != nil {
+			return err
+		}
+		query, bindings := s.buildQuery(filter)
+		if f == nil {
+			return s.DB.WithContext(ctx).Where(query, bindings).Delete(item).Error
+		}
+
+		column, err := s.columnName(item, f.Name)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		result := s.DB.WithContext(ctx).Model(item).
+			Where(query, bindings).
+			Where(fmt.Sprintf("%s IS NULL", column)).
+			Update(column, now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			setDeletedAt(item, f, &now)
+		}
+		return nil
+	})
+}
+
+// Restore undeletes the soft deleted row matching filter, returning ErrNotFound if there's none
+func (s *SQLAdapter) Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when restoring a resource")
+		}
+		f, err := requireSoftDeleteField(item)
+		if err != nil {
+			return err
+		}
+		column, err := s.columnName(item, f.Name)
+		if err != nil {
+			return err
+		}
+		query, bindings := s.buildQuery(filter)
+		result := s.DB.WithContext(ctx).Model(item).
+			Where(query, bindings).
+			Where(fmt.Sprintf("%s IS NOT NULL", column)).
+			Update(column, nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		setDeletedAt(item, f, nil)
+		return nil
+	})
+}
+
+// Purge deletes the row matching filter for good, whether it was soft deleted or not
+func (s *SQLAdapter) Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error {
+	return s.retrier.do(ctx, true, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when purging a resource")
+		}
+		query, bindings := s.buildQuery(filter)
+		return s.DB.WithContext(ctx).Where(query, bindings).Delete(item).Error
+	})
+}
+
+// Patch updates the columns in changes of the row matching filter with a single UPDATE. postgresql and sqlite fill
+// item with the patched row using RETURNING while mysql reads it back. Appends expect JSON array columns, such as
+// fields tagged with gorm:"serializer:json"
+func (s *SQLAdapter) Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	changes, idempotent, err := preparePatch(item, changes, func(f *modelField) (string, error) {
+		return s.columnName(item, f.Name)
+	})
+	if err != nil {
+		return err
+	}
+	return s.retrier.do(ctx, idempotent, func() error {
+		if len(filter) == 0 {
+			return errors.New("filtering is required when patching a resource")
+		}
+		live, err := s.liveItems(item, params...)
+		if err != nil {
+			return err
+		}
+
+		updates := make(map[string]any, len(changes)+1)
+		for column, change := range changes {
+			op, ok := change.(PatchOperation)
+			switch {
+			case !ok:
+				updates[column] = change
+			case op.Op == PATCH_INCREMENT:
+				updates[column] = gorm.Expr(fmt.Sprintf("COALESCE(%s, 0) + ?", column), op.Value)
+			case op.Op == PATCH_APPEND:
+				if updates[column], err = s.jsonAppend(column, op.Value.([]any)); err != nil {
+					return err
+				}
+			}
+		}
+		if f := getModelMetadata(item).field("version"); f != nil {
+			column, err := s.columnName(item, f.Name)
+			if err != nil {
+				return err
+			}
+			updates[column] = gorm.Expr(fmt.Sprintf("%s + 1", column))
+		}
+
+		query, bindings := s.buildQuery(filter)
+		q := s.DB.WithContext(ctx).Model(item).Scopes(live).Where(query, bindings)
+		if s.provider == MYSQL {
+			// mysql has no RETURNING and only counts the rows whose values changed as affected, so the row is read back
+			if err := q.Updates(updates).Error; err != nil {
+				return err
+			}
+			result := s.DB.WithContext(ctx).Scopes(live).Where(query, bindings).Limit(1).Find(item)
+			if result.Error == nil && result.RowsAffected == 0 {
+				return ErrNotFound
+			}
+			return result.Error
+		}
+
+		result := q.Clauses(clause.Returning{}).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// jsonAppend returns the expression appending values to the JSON array held by column
+func (s *SQLAdapter) jsonAppend(column string, values []any) (clause.Expr, error) {
+	switch s.provider {
+	case POSTGRESQL:
+		array, err := json.Marshal(values)
+		if err != nil {
+			return clause.Expr{}, fmt.Errorf("failed to marshal values to append to %s: %v", column, err)
+		}
+		return gorm.Expr(fmt.Sprintf("COALESCE(%s::jsonb, '[]'::jsonb) || ?::jsonb", column), string(array)), nil
+	case MYSQL:
+		array, err := json.Marshal(values)
+		if err != nil {
+			return clause.Expr{}, fmt.Errorf("failed to marshal values to append to %s: %v", column, err)
+		}
+		return gorm.Expr(fmt.Sprintf("JSON_MERGE_PRESERVE(COALESCE(%s, JSON_ARRAY()), CAST(? AS JSON))", column), string(array)), nil
+	default:
+		paths := make([]string, len(values))
+		args := make([]any, len(values))
+		for i, value := range values {
+			element, err := json.Marshal(value)
+			if err != nil {
+				return clause.Expr{}, fmt.Errorf("failed to marshal values to append to %s: %v", column, err)
+			}
+			paths[i] = "'$[#]', json(?)"
+			args[i] = string(element)
+		}
+		return gorm.Expr(fmt.Sprintf("json_insert(COALESCE(%s, '[]'), %s)", column, strings.Join(paths, ", ")), args...), nil
+	}
+}
+
+// liveItems returns a scope leaving the expired rows of model out of a read, as well as its soft deleted rows
+// unless params include INCLUDE_DELETED
+func (s *SQLAdapter) liveItems(model any, params ...map[string]any) (queryBuilder, error) {
+	conditions := []clause.Expression{}
+	f, err := liveItemsField(model, params...)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		column, err := s.columnName(model, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, clause.Expr{SQL: fmt.Sprintf("%s IS NULL", column)})
+	}
+
+	f, err = expiryField(model)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		column, err := s.columnName(model, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		s.sweeper.track(model)
+		conditions = append(conditions, clause.Expr{SQL: fmt.Sprintf("(%s IS NULL OR %s > ?)", column, column), Vars: []any{expiryNow()}})
 	}
-	query, bindings := s.buildQuery(filter)
-	result := s.DB.Where(query, bindings).Delete(item)
-	return result.Error
+
+	return func(q *gorm.DB) *gorm.DB {
+		for _, condition := range conditions {
+			q = q.Where(condition)
+		}
+		return q
+	}, nil
 }
 
+// executePaginatedQuery runs the query built by builder on db ordered by the sort spec sortKey (e.g. "-created_at,id") and
+// returns at most limit rows starting after cursor. The primary key is appended to the sort as a tiebreaker so
+// pagination stays stable when sort values repeat, and the returned cursor encodes the full keyset of the last row
 func (s *SQLAdapter) executePaginatedQuery(
+	ctx context.Context,
+	db *gorm.DB,
 	dest any,
 	sortKey string,
 	limit int,
 	cursor string,
 	builder queryBuilder,
 ) (string, error) {
-	var cursorValue string
-	if cursor != "" {
-		bytes, err := base64.StdEncoding.DecodeString(cursor)
-		if err != nil {
-			return "", fmt.Errorf("invalid cursor: %w", err)
-		}
-		cursorValue = string(bytes)
+	sortFields, err := s.resolveSort(dest, sortKey)
+	if err != nil {
+		return "", err
 	}
-	q := s.DB.Model(dest).Scopes(builder)
 
-	q = q.Limit(limit + 1).Order(fmt.Sprintf("%s ASC", sortKey))
+	q := db.WithContext(ctx).Model(dest).Scopes(builder)
+	for _, f := range sortFields {
+		if f.nullable() {
+			// NULLs sort as the greatest value on every provider, last in ascending and first in descending order
+			nullsFirst := 1
+			if f.Desc {
+				nullsFirst = 0
+			}
+			q = q.Order(fmt.Sprintf("CASE WHEN %s IS NULL THEN %d ELSE %d END", q.Statement.Quote(f.DBName), nullsFirst, 1-nullsFirst))
+		}
+		q = q.Order(clause.OrderByColumn{Column: clause.Column{Name: f.DBName}, Desc: f.Desc})
+	}
+	q = q.Limit(limit + 1)
 
-	if cursorValue != "" {
-		q = q.Where(fmt.Sprintf("%s > ?", sortKey), cursorValue)
+	if cursor != "" {
+		values, ok, err := decodeCursor(cursor, sortKey)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			if len(values) != len(sortFields) {
+				return "", &serviceErrors.BadRequest{Message: "invalid cursor: it doesn't match the sort fields"}
+			}
+			condition, args := keysetCondition(sortFields, values)
+			q = q.Where(condition, args...)
+		} else {
+			// Cursors issued before keyset pagination hold the raw value of the sort column, they only exist for
+			// sorts on a single ascending column
+			spec := parseSortSpec(sortKey)
+			legacyValue, err := base64.StdEncoding.DecodeString(cursor)
+			if err != nil || len(spec) != 1 || spec[0].Desc {
+				return "", &serviceErrors.BadRequest{Message: "invalid cursor"}
+			}
+			condition, args := keysetAfter(sortFields[0], string(legacyValue))
+			q = q.Where(condition, args...)
+		}
 	}
 
 	if result := q.Find(dest); result.Error != nil {
@@ -231,89 +864,392 @@ func (s *SQLAdapter) executePaginatedQuery(
 	}
 
 	destSlice := reflect.ValueOf(dest).Elem()
-	if destSlice.Len() == 0 {
+	if destSlice.Len() <= limit {
 		return "", nil
 	}
 
-	nextCursor := ""
-	if destSlice.Len() > limit {
-		lastItem := destSlice.Index(limit - 1)
-		field := reflect.Indirect(lastItem).FieldByName(sortKey)
-		if field.IsValid() && field.Kind() == reflect.String {
-			nextCursor = base64.StdEncoding.EncodeToString([]byte(field.String()))
+	lastItem := reflect.Indirect(destSlice.Index(limit - 1))
+	values := make([]any, len(sortFields))
+	for i, f := range sortFields {
+		values[i], _ = f.ValueOf(ctx, lastItem)
+	}
+	destSlice.Set(destSlice.Slice(0, limit))
+
+	return encodeCursor(sortKey, values)
+}
+
+// sqlSortField is a sort spec column resolved against the model schema
+type sqlSortField struct {
+	*schema.Field
+	Desc bool
+}
+
+// nullable reports whether the column can hold NULL
+func (f sqlSortField) nullable() bool {
+	return !f.NotNull && !f.PrimaryKey
+}
+
+// resolveSort resolves the columns of the sort spec sortKey against the schema of model, appending the primary
+// key as a tiebreaker unless it is already part of the sort. Columns can be given by name or Go field name
+func (s *SQLAdapter) resolveSort(model any, sortKey string) ([]sqlSortField, error) {
+	modelSchema, err := s.modelSchema(model)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []sqlSortField{}
+	seen := map[string]bool{}
+	for _, f := range parseSortSpec(sortKey) {
+		field := modelSchema.LookUpField(f.Name)
+		if field == nil || field.DBName == "" {
+			return nil, &serviceErrors.BadRequest{Message: fmt.Sprintf("invalid sort field '%s'", f.Name)}
 		}
-		destSlice.Set(destSlice.Slice(0, limit))
-	} else {
-		nextCursor = ""
+		if !seen[field.DBName] {
+			fields = append(fields, sqlSortField{Field: field, Desc: f.Desc})
+			seen[field.DBName] = true
+		}
+	}
+
+	for _, pk := range modelSchema.PrimaryFields {
+		if !seen[pk.DBName] {
+			fields = append(fields, sqlSortField{Field: pk})
+			seen[pk.DBName] = true
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("a sort key is required for models without a primary key")
 	}
+	return fields, nil
+}
+
+// keysetCondition returns the condition selecting the rows that sort after values, e.g. for "-created_at,id":
+// (created_at < ?) OR (created_at = ? AND id > ?). Columns are passed as clause.Column args so they are quoted.
+// NULLs sort as the greatest value, so nothing sorts after a NULL in ascending order and every other value does in
+// descending order
+func keysetCondition(fields []sqlSortField, values []any) (string, []any) {
+	clauses := []string{}
+	args := []any{}
+	for i, f := range fields {
+		after, afterArgs := keysetAfter(f, values[i])
+		if after == "" {
+			continue
+		}
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			column := clause.Column{Name: fields[j].DBName}
+			if values[j] == nil {
+				parts = append(parts, "? IS NULL")
+				args = append(args, column)
+				continue
+			}
+			parts = append(parts, "? = ?")
+			args = append(args, column, values[j])
+		}
+		parts = append(parts, after)
+		args = append(args, afterArgs...)
+		clauses = append(clauses, fmt.Sprintf("(%s)", strings.Join(parts, " AND ")))
+	}
+	if len(clauses) == 0 {
+		// The cursor points at the last row
+		return "1 = 0", nil
+	}
+	return strings.Join(clauses, " OR "), args
+}
 
-	return nextCursor, nil
+// keysetAfter returns the condition selecting the values of f that sort after value, or "" if none does
+func keysetAfter(f sqlSortField, value any) (string, []any) {
+	column := clause.Column{Name: f.DBName}
+	switch {
+	case value == nil && f.Desc:
+		return "? IS NOT NULL", []any{column}
+	case value == nil:
+		return "", nil
+	case f.Desc:
+		return "? < ?", []any{column, value}
+	case f.nullable():
+		return "(? > ? OR ? IS NULL)", []any{column, value, column}
+	}
+	return "? > ?", []any{column, value}
 }
 
 func (s *SQLAdapter) List(dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
-	return s.executePaginatedQuery(dest, sortKey, limit, cursor, func(q *gorm.DB) *gorm.DB {
-		if len(filter) > 0 {
-			query, bindings := s.buildQuery(filter)
-			return q.Where(query, bindings)
+	return s.ListContext(context.Background(), dest, sortKey, filter, limit, cursor, params...)
+}
+
+func (s *SQLAdapter) ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		live, err := s.liveItems(dest, params...)
+		if err != nil {
+			return "", err
 		}
-		return q
+		return s.executePaginatedQuery(ctx, s.reader(params...), dest, sortKey, limit, cursor, func(q *gorm.DB) *gorm.DB {
+			q = q.Scopes(live)
+			if len(filter) > 0 {
+				query, bindings := s.buildQuery(filter)
+				return q.Where(query, bindings)
+			}
+			return q
+		})
 	})
 }
 
 func (s *SQLAdapter) Search(dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
-	if query == "" {
-		return s.executePaginatedQuery(dest, sortKey, limit, cursor, func(q *gorm.DB) *gorm.DB {
+	return s.SearchContext(context.Background(), dest, sortKey, query, limit, cursor, params...)
+}
+
+func (s *SQLAdapter) SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		live, err := s.liveItems(dest, params...)
+		if err != nil {
+			return "", err
+		}
+		if query == "" {
+			return s.executePaginatedQuery(ctx, s.reader(params...), dest, sortKey, limit, cursor, live)
+		}
+
+		destType := reflect.TypeOf(dest).Elem().Elem()
+		model := reflect.New(destType).Elem().Interface()
+
+		parser, err := lucene.NewParserFromType(model)
+		if err != nil {
+			slog.Error("Parser creation failed", "error", err)
+			return "", err
+		}
+
+		whereClause, queryParams, err := parser.ParseToSQL(query)
+		if err != nil {
+			slog.Error("Filter parsing failed", "error", err)
+			// Wrap InvalidFieldError as BadRequest for proper HTTP 400 response
+			if _, ok := err.(*lucene.InvalidFieldError); ok {
+				return "", &serviceErrors.BadRequest{Message: err.Error()}
+			}
+			return "", err
+		}
+
+		slog.Debug(fmt.Sprintf(`Where clause: %s, with params %s`, whereClause, queryParams))
+
+		return s.executePaginatedQuery(ctx, s.reader(params...), dest, sortKey, limit, cursor, func(q *gorm.DB) *gorm.DB {
+			q = q.Scopes(live)
+			if whereClause != "" {
+				return q.Where(whereClause, queryParams...)
+			}
 			return q
 		})
-	}
+	})
+}
 
-	destType := reflect.TypeOf(dest).Elem().Elem()
-	model := reflect.New(destType).Elem().Interface()
+func (s *SQLAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return s.CountContext(context.Background(), dest, filter, params...)
+}
 
-	parser, err := lucene.NewParserFromType(model)
-	if err != nil {
-		slog.Error("Parser creation failed", "error", err)
-		return "", err
-	}
+func (s *SQLAdapter) CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error) {
+	return retryResult(ctx, s.retrier, true, func() (int64, error) {
+		live, err := s.liveItems(dest, params...)
+		if err != nil {
+			return 0, err
+		}
+		q := s.reader(params...).WithContext(ctx).Model(dest).Scopes(live)
 
-	whereClause, queryParams, err := parser.ParseToSQL(query)
-	if err != nil {
-		slog.Error("Filter parsing failed", "error", err)
-		// Wrap InvalidFieldError as BadRequest for proper HTTP 400 response
-		if _, ok := err.(*lucene.InvalidFieldError); ok {
-			return "", &serviceErrors.BadRequest{Message: err.Error()}
+		if len(filter) > 0 {
+			query, bindings := s.buildQuery(filter)
+			q = q.Where(query, bindings)
 		}
-		return "", err
-	}
 
-	slog.Debug(fmt.Sprintf(`Where clause: %s, with params %s`, whereClause, queryParams))
+		var total int64
+		if err := q.Count(&total).Error; err != nil {
+			slog.Error("Error finding count")
+			return 0, err
+		}
+		return total, nil
+	})
+}
+
+func (s *SQLAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return s.QueryContext(context.Background(), dest, statement, limit, cursor, params...)
+}
 
-	return s.executePaginatedQuery(dest, sortKey, limit, cursor, func(q *gorm.DB) *gorm.DB {
-		if whereClause != "" {
-			return q.Where(whereClause, queryParams...)
+// QueryContext runs a raw SELECT statement, binding @name placeholders to the values of the params maps.
+// Results are paginated by appending LIMIT and OFFSET to the statement, so it should have a stable ORDER BY and
+// no LIMIT of its own. The cursor encodes the offset of the next page, a limit of 0 or less returns all rows
+func (s *SQLAdapter) QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
+	return retryResult(ctx, s.retrier, true, func() (string, error) {
+		offset := 0
+		if cursor != "" {
+			bytes, err := base64.StdEncoding.DecodeString(cursor)
+			if err != nil {
+				return "", fmt.Errorf("invalid cursor: %w", err)
+			}
+			offset, err = strconv.Atoi(string(bytes))
+			if err != nil || offset < 0 {
+				return "", fmt.Errorf("invalid cursor: %s", cursor)
+			}
 		}
-		return q
+
+		bindings := map[string]any{}
+		for _, p := range params {
+			for key, value := range p {
+				if key != SQL_READ_PRIMARY {
+					bindings[key] = value
+				}
+			}
+		}
+
+		query := strings.TrimSuffix(strings.TrimSpace(statement), ";")
+		if limit > 0 {
+			query = fmt.Sprintf("%s LIMIT %d OFFSET %d", query, limit+1, offset)
+		}
+
+		queryCtx, cancel := s.rowsContext(ctx)
+		defer cancel()
+		q := s.reader(params...).WithContext(queryCtx)
+		if len(bindings) > 0 {
+			q = q.Raw(query, bindings)
+		} else {
+			q = q.Raw(query)
+		}
+		if result := q.Scan(dest); result.Error != nil {
+			slog.Error("Query execution failed", "error", result.Error)
+			return "", result.Error
+		}
+
+		destSlice := reflect.ValueOf(dest).Elem()
+		if limit <= 0 || destSlice.Kind() != reflect.Slice || destSlice.Len() <= limit {
+			return "", nil
+		}
+		destSlice.Set(destSlice.Slice(0, limit))
+		return base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset + limit))), nil
 	})
 }
 
-func (s *SQLAdapter) Count(dest any, filter map[string]any, params ...map[string]any) (int64, error) {
-	q := s.DB.Model(dest)
+// WithTransaction runs fn inside a database transaction which is committed if fn returns nil
+// and rolled back otherwise
+func (s *SQLAdapter) WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error {
+	return s.retrier.do(ctx, false, func() error {
+		return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(&SQLAdapter{DB: tx, config: s.config, provider: s.provider, retrier: s.retrier.withoutRetries(), sweeper: s.sweeper})
+		})
+	})
+}
 
-	if len(filter) > 0 {
-		query, bindings := s.buildQuery(filter)
-		q = q.Where(query, bindings)
+// BatchCreate inserts items SQL_BATCH_SIZE rows per statement
+func (s *SQLAdapter) BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return retryResult(ctx, s.retrier, false, func() ([]BatchResult, error) {
+		return s.executeBatchWrite(ctx, items, func(db *gorm.DB) *gorm.DB {
+			return db
+		}, params...)
+	})
+}
+
+// BatchUpsert inserts items SQL_BATCH_SIZE rows per statement, replacing rows whose primary key already exists
+func (s *SQLAdapter) BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error) {
+	return retryResult(ctx, s.retrier, true, func() ([]BatchResult, error) {
+		return s.executeBatchWrite(ctx, items, func(db *gorm.DB) *gorm.DB {
+			return db.Clauses(clause.OnConflict{UpdateAll: true})
+		}, params...)
+	})
+}
+
+// BatchDelete deletes the rows matching each of filters. When every filter is on the same single column
+// the rows are deleted SQL_BATCH_SIZE at a time with an IN clause, otherwise they are deleted one by one
+func (s *SQLAdapter) BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	return retryResult(ctx, s.retrier, true, func() ([]BatchResult, error) {
+		results := make([]BatchResult, len(filters))
+		for i := range results {
+			results[i].Index = i
+		}
+
+		key := singleFilterKey(filters)
+		if key == "" {
+			for i, filter := range filters {
+				results[i].Err = s.DeleteContext(ctx, item, filter, params...)
+			}
+			return results, nil
+		}
+
+		for start := 0; start < len(filters); start += SQL_BATCH_SIZE {
+			end := min(start+SQL_BATCH_SIZE, len(filters))
+			values := make([]any, 0, end-start)
+			for _, filter := range filters[start:end] {
+				values = append(values, filter[key])
+			}
+			if err := s.deleteIn(ctx, item, key, values); err != nil {
+				for i := start; i < end; i++ {
+					results[i].Err = s.retrier.translate(err)
+				}
+			}
+		}
+		return results, nil
+	})
+}
+
+// deleteIn deletes, or soft deletes, the rows whose column key holds one of values
+func (s *SQLAdapter) deleteIn(ctx context.Context, item any, key string, values []any) error {
+	f, err := softDeleteField(item)
+	if err != nil {
+		return err
+	}
+	q := s.DB.WithContext(ctx).Where(fmt.Sprintf("%s IN ?", key), values)
+	if f == nil {
+		return q.Delete(item).Error
+	}
+	column, err := s.columnName(item, f.Name)
+	if err != nil {
+		return err
 	}
+	return q.Model(item).Where(fmt.Sprintf("%s IS NULL", column)).Update(column, time.Now().UTC()).Error
+}
 
-	var total int64
-	if err := q.Count(&total).Error; err != nil {
-		slog.Error("Error finding count")
-		return 0, err
+// singleFilterKey returns the column name if all filters match on that same single column, or "" otherwise
+func singleFilterKey(filters []map[string]any) string {
+	key := ""
+	for _, filter := range filters {
+		if len(filter) != 1 {
+			return ""
+		}
+		for k := range filter {
+			if key != "" && k != key {
+				return ""
+			}
+			key = k
+		}
 	}
-	return total, nil
+	return key
 }
 
-func (s *SQLAdapter) Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error) {
-	return "", fmt.Errorf("not implemented yet")
+// executeBatchWrite writes items in chunks of SQL_BATCH_SIZE. If a chunk fails its items are retried
+// one at a time so the failure can be attributed to the offending items
+func (s *SQLAdapter) executeBatchWrite(ctx context.Context, items any, builder queryBuilder, params ...map[string]any) ([]BatchResult, error) {
+	elements, err := batchItems(items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range elements {
+		if err := initVersion(item); err != nil {
+			return nil, err
+		}
+		if err := applyTTL(item, params...); err != nil {
+			return nil, err
+		}
+	}
+	s.sweeper.track(items)
+
+	v := reflect.Indirect(reflect.ValueOf(items))
+	results := make([]BatchResult, len(elements))
+	for start := 0; start < len(elements); start += SQL_BATCH_SIZE {
+		end := min(start+SQL_BATCH_SIZE, len(elements))
+		for i := start; i < end; i++ {
+			results[i].Index = i
+		}
+
+		chunk := v.Slice(start, end).Interface()
+		if err := builder(s.DB.WithContext(ctx)).Create(chunk).Error; err == nil {
+			continue
+		}
+		for i := start; i < end; i++ {
+			results[i].Err = s.retrier.translate(builder(s.DB.WithContext(ctx)).Create(elements[i]).Error)
+		}
+	}
+	return results, nil
 }
 
 func (s *SQLAdapter) buildQuery(filter map[string]any) (string, map[string]any) {
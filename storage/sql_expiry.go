@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const SQL_TTL_SWEEP_INTERVAL = time.Minute
+
+// sqlExpirySweeper deletes the expired rows of every model with an expires_at field that was read or written
+// through the adapter, every interval. SQL databases have no native TTL, until rows are swept reads leave them out
+type sqlExpirySweeper struct {
+	adapter  *SQLAdapter
+	interval time.Duration
+	models   sync.Map
+	start    sync.Once
+	stop     chan struct{}
+}
+
+// newExpirySweeper creates the sweeper of the adapter, it starts once the first model with an expires_at field
+// is tracked
+func (s *SQLAdapter) newExpirySweeper() error {
+	interval := SQL_TTL_SWEEP_INTERVAL
+	if v := s.config["ttl_sweep_interval"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid ttl_sweep_interval '%s'", v)
+		}
+		interval = d
+	}
+	s.sweeper = &sqlExpirySweeper{adapter: s, interval: interval, stop: make(chan struct{})}
+	return nil
+}
+
+// DeleteExpired deletes the rows of model whose expiry time has passed and returns how many were deleted. The
+// sweeper calls it periodically, it can also be called directly, e.g. from a scheduled job
+func (s *SQLAdapter) DeleteExpired(ctx context.Context, model any) (int64, error) {
+	return retryResult(ctx, s.retrier, true, func() (int64, error) {
+		f, err := requireExpiryField(model)
+		if err != nil {
+			return 0, err
+		}
+		column, err := s.columnName(model, f.Name)
+		if err != nil {
+			return 0, err
+		}
+		result := s.DB.WithContext(ctx).Where(fmt.Sprintf("%s <= ?", column), expiryNow()).Delete(model)
+		return result.RowsAffected, result.Error
+	})
+}
+
+// track adds the model of obj to the swept models if it has an expires_at field, starting the sweeper if needed
+func (w *sqlExpirySweeper) track(obj any) {
+	if w == nil {
+		return
+	}
+	if f, err := expiryField(obj); err != nil || f == nil {
+		return
+	}
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if _, loaded := w.models.LoadOrStore(t, reflect.New(t).Interface()); !loaded {
+		w.start.Do(func() { go w.run() })
+	}
+}
+
+// run sweeps the tracked models every interval until the sweeper is closed
+func (w *sqlExpirySweeper) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		w.sweep()
+	}
+}
+
+// sweep deletes the expired rows of every tracked model
+func (w *sqlExpirySweeper) sweep() {
+	w.models.Range(func(_, model any) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), w.interval)
+		defer cancel()
+		if _, err := w.adapter.DeleteExpired(ctx, model); err != nil {
+			slog.Error("failed to delete expired items", "model", fmt.Sprintf("%T", model), "error", err)
+		}
+		return true
+	})
+}
+
+// close stops the sweeper
+func (w *sqlExpirySweeper) close() {
+	if w == nil {
+		return
+	}
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+}
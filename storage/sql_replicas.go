@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Read replica selection policies, set with the replica_policy option
+const (
+	SQL_REPLICA_ROUND_ROBIN   = "round_robin"
+	SQL_REPLICA_LEAST_LATENCY = "least_latency"
+)
+
+// SQL_READ_PRIMARY is the params key forcing a read to go to the primary database, e.g. to read an item
+// right after writing it: adapter.Get(&item, filter, map[string]any{storage.SQL_READ_PRIMARY: true})
+const SQL_READ_PRIMARY = "read_primary"
+
+const SQL_REPLICA_CHECK_INTERVAL = 10 * time.Second
+
+// sqlReplica is a read replica along with the result of its last health check
+type sqlReplica struct {
+	db      *gorm.DB
+	healthy atomic.Bool
+	latency atomic.Int64
+}
+
+// sqlReplicaSet routes reads to the healthy replicas according to policy, replicas are pinged every
+// interval to track their health and latency
+type sqlReplicaSet struct {
+	replicas []*sqlReplica
+	policy   string
+	next     atomic.Uint64
+	stop     chan struct{}
+}
+
+// openReplicas connects to the comma separated replica DSNs of the replicas option
+func (s *SQLAdapter) openReplicas(gormConf gorm.Config) error {
+	if s.config["replicas"] == "" {
+		return nil
+	}
+	if s.provider != POSTGRESQL && s.provider != MYSQL {
+		return fmt.Errorf("read replicas are only supported by postgresql and mysql")
+	}
+
+	policy := s.config["replica_policy"]
+	switch policy {
+	case "":
+		policy = SQL_REPLICA_ROUND_ROBIN
+	case SQL_REPLICA_ROUND_ROBIN, SQL_REPLICA_LEAST_LATENCY:
+	default:
+		return fmt.Errorf("invalid replica_policy '%s', supported policies are: %s and %s", policy, SQL_REPLICA_ROUND_ROBIN, SQL_REPLICA_LEAST_LATENCY)
+	}
+	interval := SQL_REPLICA_CHECK_INTERVAL
+	if v := s.config["replica_check_interval"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid replica_check_interval '%s'", v)
+		}
+		interval = d
+	}
+
+	set := &sqlReplicaSet{policy: policy, stop: make(chan struct{})}
+	for i, dsn := range strings.Split(s.config["replicas"], ",") {
+		if dsn = strings.TrimSpace(dsn); dsn == "" {
+			continue
+		}
+		dsn, err := s.replicaDSN(dsn)
+		var db *gorm.DB
+		if err == nil {
+			db, err = gorm.Open(s.dialector(dsn), &gormConf)
+		}
+		if err == nil {
+			err = s.configureDB(db)
+		}
+		if err != nil {
+			set.close()
+			return fmt.Errorf("failed to open a connection to replica %d: %v", i, err)
+		}
+		replica := &sqlReplica{db: db}
+		replica.healthy.Store(true)
+		set.replicas = append(set.replicas, replica)
+	}
+
+	s.replicas = set
+	go set.monitor(interval)
+	return nil
+}
+
+// replicaDSN returns the connection string of the replica dsn. MySQL replicas get the TLS settings of the primary
+// unless dsn has a tls parameter of its own, custom TLS configurations verify the replica's host
+func (s *SQLAdapter) replicaDSN(dsn string) (string, error) {
+	if s.provider != MYSQL {
+		return dsn, nil
+	}
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid replica connection string: %v", err)
+	}
+	if cfg.TLSConfig == "" {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			host = cfg.Addr
+		}
+		if cfg.TLSConfig, err = s.mysqlTLSConfig(host); err != nil {
+			return "", err
+		}
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// reader returns the database to read from: a replica when replicas are configured, unless params set
+// SQL_READ_PRIMARY or no replica is healthy
+func (s *SQLAdapter) reader(params ...map[string]any) *gorm.DB {
+	if s.replicas == nil || readPrimary(params) {
+		return s.DB
+	}
+	if db := s.replicas.pick(); db != nil {
+		return db
+	}
+	return s.DB
+}
+
+func readPrimary(params []map[string]any) bool {
+	for _, p := range params {
+		switch v := p[SQL_READ_PRIMARY].(type) {
+		case bool:
+			return v
+		case string:
+			return v == "true"
+		}
+	}
+	return false
+}
+
+// pick returns a healthy replica chosen according to the policy, or nil if none is healthy
+func (r *sqlReplicaSet) pick() *gorm.DB {
+	healthy := make([]*sqlReplica, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		if replica.healthy.Load() {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if r.policy == SQL_REPLICA_LEAST_LATENCY {
+		best := healthy[0]
+		for _, replica := range healthy[1:] {
+			if replica.latency.Load() < best.latency.Load() {
+				best = replica
+			}
+		}
+		return best.db
+	}
+	return healthy[r.next.Add(1)%uint64(len(healthy))].db
+}
+
+// monitor pings the replicas every interval until the set is closed
+func (r *sqlReplicaSet) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.check(interval)
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check pings every replica, recording whether it responded within timeout and how long it took
+func (r *sqlReplicaSet) check(timeout time.Duration) {
+	for _, replica := range r.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		db, err := replica.db.DB()
+		if err == nil {
+			err = db.PingContext(ctx)
+		}
+		cancel()
+		replica.healthy.Store(err == nil)
+		replica.latency.Store(int64(time.Since(start)))
+	}
+}
+
+// close stops the health checks and closes the replicas' connection pools
+func (r *sqlReplicaSet) close() error {
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	errs := []error{}
+	for _, replica := range r.replicas {
+		if db, err := replica.db.DB(); err == nil {
+			errs = append(errs, db.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestReplicaSetPick(t *testing.T) {
+	newReplica := func(healthy bool, latency time.Duration) *sqlReplica {
+		replica := &sqlReplica{db: &gorm.DB{}}
+		replica.healthy.Store(healthy)
+		replica.latency.Store(int64(latency))
+		return replica
+	}
+	a, b, c := newReplica(true, 30*time.Millisecond), newReplica(false, time.Millisecond), newReplica(true, 20*time.Millisecond)
+
+	tests := []struct {
+		name     string
+		policy   string
+		replicas []*sqlReplica
+		want     []*gorm.DB
+	}{
+		{name: "round robin over the healthy replicas", policy: SQL_REPLICA_ROUND_ROBIN, replicas: []*sqlReplica{a, b, c}, want: []*gorm.DB{c.db, a.db, c.db, a.db}},
+		{name: "least latency among the healthy replicas", policy: SQL_REPLICA_LEAST_LATENCY, replicas: []*sqlReplica{a, b, c}, want: []*gorm.DB{c.db, c.db}},
+		{name: "no healthy replica", policy: SQL_REPLICA_ROUND_ROBIN, replicas: []*sqlReplica{b}, want: []*gorm.DB{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := &sqlReplicaSet{replicas: tt.replicas, policy: tt.policy}
+			for i, want := range tt.want {
+				if got := set.pick(); got != want {
+					t.Fatalf("pick() %d returned replica %p, want %p", i, got, want)
+				}
+			}
+		})
+	}
+
+	// Reads go to the primary when no replica is healthy
+	primary := &gorm.DB{}
+	s := &SQLAdapter{DB: primary, replicas: &sqlReplicaSet{replicas: []*sqlReplica{b}}}
+	if s.reader() != primary {
+		t.Errorf("reader() didn't fall back to the primary")
+	}
+}
+
+func TestReadPrimary(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []map[string]any
+		want   bool
+	}{
+		{name: "no params", want: false},
+		{name: "true", params: []map[string]any{{SQL_READ_PRIMARY: true}}, want: true},
+		{name: "string", params: []map[string]any{{SQL_READ_PRIMARY: "true"}}, want: true},
+		{name: "false", params: []map[string]any{{SQL_READ_PRIMARY: false}}, want: false},
+		{name: "other params", params: []map[string]any{{INCLUDE_DELETED: true}}, want: false},
+		{name: "first map setting it wins", params: []map[string]any{{}, {SQL_READ_PRIMARY: true}, {SQL_READ_PRIMARY: false}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readPrimary(tt.params); got != tt.want {
+				t.Errorf("readPrimary(%v) = %v, want %v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicaDSNUsesThePrimaryTLSSettings(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		config     map[string]string
+		dsn        string
+		wantTLS    string
+		wantCustom bool
+	}{
+		{name: "driver tls value", config: map[string]string{"tls": "skip-verify"}, dsn: "app:secret@tcp(replica-1:3306)/app", wantTLS: "skip-verify"},
+		{name: "custom certificates", config: map[string]string{"tls_ca": ca}, dsn: "app:secret@tcp(replica-1:3306)/app", wantCustom: true},
+		{name: "tls of the replica", config: map[string]string{"tls_ca": ca}, dsn: "app:secret@tcp(replica-1:3306)/app?tls=false", wantTLS: "false"},
+		{name: "no tls", config: map[string]string{}, dsn: "app:secret@tcp(replica-1:3306)/app", wantTLS: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SQLAdapter{config: tt.config, provider: MYSQL}
+			dsn, err := s.replicaDSN(tt.dsn)
+			if err != nil {
+				t.Fatalf("replicaDSN() error: %v", err)
+			}
+			cfg, err := mysqldriver.ParseDSN(dsn)
+			if err != nil {
+				t.Fatalf("replicaDSN() = %q, an invalid connection string: %v", dsn, err)
+			}
+			if cfg.Addr != "replica-1:3306" || cfg.User != "app" || cfg.DBName != "app" {
+				t.Errorf("replicaDSN() = %q, want the replica's address, user and database", dsn)
+			}
+			if tt.wantCustom {
+				if cfg.TLS == nil || cfg.TLS.ServerName != "replica-1" || cfg.TLS.RootCAs == nil {
+					t.Errorf("replicaDSN() = %q, want a TLS config trusting tls_ca and verifying replica-1", dsn)
+				}
+			} else if cfg.TLSConfig != tt.wantTLS {
+				t.Errorf("replicaDSN() tls = %q, want %q", cfg.TLSConfig, tt.wantTLS)
+			}
+		})
+	}
+
+	// Other providers take replica connection strings as they are
+	s := &SQLAdapter{config: map[string]string{"tls_ca": ca}, provider: POSTGRESQL}
+	if dsn, err := s.replicaDSN("host=replica-1 dbname=app"); err != nil || dsn != "host=replica-1 dbname=app" {
+		t.Errorf("replicaDSN() = %q, %v, want the postgresql connection string unchanged", dsn, err)
+	}
+}
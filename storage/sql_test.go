@@ -0,0 +1,543 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	serviceErrors "github.com/tink3rlabs/magic/errors"
+	"gorm.io/gorm"
+)
+
+// newTestAdapter returns a memory adapter with tables for models, closed when the test ends
+func newTestAdapter(t *testing.T, models ...any) *MemoryAdapter {
+	t.Helper()
+	adapter, err := NewMemoryAdapter()
+	if err != nil {
+		t.Fatalf("NewMemoryAdapter() error: %v", err)
+	}
+	t.Cleanup(func() { adapter.Close() })
+	if err := adapter.DB.DB.AutoMigrate(models...); err != nil {
+		t.Fatalf("AutoMigrate() error: %v", err)
+	}
+	return adapter
+}
+
+type queryRow struct {
+	ID   string
+	Name string
+}
+
+func (queryRow) TableName() string { return "query_rows" }
+
+func TestQueryRetriesTransientErrors(t *testing.T) {
+	adapter := newTestAdapter(t, &queryRow{})
+	adapter.DB.retrier = &retrier{
+		policy:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		classify: classifySQLError,
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := adapter.DB.DB.Create(&queryRow{ID: id, Name: "row " + id}).Error; err != nil {
+			t.Fatalf("Create() error: %v", err)
+		}
+	}
+
+	// Fail the first attempt with a dropped connection, which is transient
+	attempts := 0
+	statements := []string{}
+	err := adapter.DB.DB.Callback().Row().Before("gorm:row").Register("test:fail_once", func(db *gorm.DB) {
+		attempts++
+		statements = append(statements, db.Statement.SQL.String())
+		if attempts == 1 {
+			db.AddError(driver.ErrBadConn)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Register() error: %v", err)
+	}
+
+	var rows []queryRow
+	cursor, err := adapter.DB.QueryContext(context.Background(), &rows, "SELECT * FROM query_rows ORDER BY id;", 2, "")
+	if err != nil {
+		t.Fatalf("QueryContext() error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if len(statements) == 2 && statements[0] != statements[1] {
+		t.Errorf("the retry ran %q, want the statement of the first attempt %q", statements[1], statements[0])
+	}
+	if len(rows) != 2 || rows[0].ID != "1" || rows[1].ID != "2" || cursor == "" {
+		t.Errorf("QueryContext() = %+v, %q, want the first two rows and a cursor", rows, cursor)
+	}
+}
+
+type cursorRow struct {
+	ID    string `gorm:"primaryKey"`
+	Rank  int
+	Group *string
+	At    time.Time
+	Score *int
+}
+
+func (cursorRow) TableName() string { return "cursor_rows" }
+
+func TestListPaginatesWithKeysetCursors(t *testing.T) {
+	adapter := newTestAdapter(t, &cursorRow{})
+	x, y := "x", "y"
+	one, three, five := 1, 3, 5
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1, t2 := t0.Add(time.Hour), t0.Add(2*time.Hour)
+	rows := []cursorRow{
+		{ID: "a", Rank: 1, Group: &x, At: t0, Score: &five},
+		{ID: "b", Rank: 2, At: t1},
+		{ID: "c", Rank: 1, Group: &y, At: t2, Score: &five},
+		{ID: "d", Rank: 3, Group: &x, At: t0},
+		{ID: "e", Rank: 2, Group: &y, At: t1, Score: &one},
+		{ID: "f", Rank: 1, At: t2, Score: &three},
+	}
+	if err := adapter.DB.DB.Create(&rows).Error; err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		sortKey string
+		want    string
+	}{
+		{name: "duplicate ints broken by the primary key", sortKey: "rank", want: "acfbed"},
+		{name: "multiple columns mixing directions", sortKey: "-rank,id", want: "dbeacf"},
+		{name: "descending times", sortKey: "-at,rank", want: "cfbead"},
+		{name: "nulls last in ascending order", sortKey: "score", want: "efacbd"},
+		{name: "nulls first in descending order", sortKey: "-score", want: "bdacfe"},
+		{name: "nullable column then descending int", sortKey: "group,-rank", want: "daecbf"},
+	}
+	for _, tt := range tests {
+		for limit := 1; limit <= len(rows); limit++ {
+			t.Run(fmt.Sprintf("%s/limit %d", tt.name, limit), func(t *testing.T) {
+				got := ""
+				cursor := ""
+				for page := 0; page <= len(rows); page++ {
+					var items []cursorRow
+					next, err := adapter.ListContext(context.Background(), &items, tt.sortKey, map[string]any{}, limit, cursor)
+					if err != nil {
+						t.Fatalf("ListContext() error: %v", err)
+					}
+					for _, item := range items {
+						got += item.ID
+					}
+					if next == "" {
+						break
+					}
+					cursor = next
+				}
+				if got != tt.want {
+					t.Errorf("ListContext(%q) pages = %s, want %s", tt.sortKey, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestListAcceptsLegacyCursors(t *testing.T) {
+	adapter := newTestAdapter(t, &cursorRow{})
+	rows := []cursorRow{{ID: "a", Rank: 1}, {ID: "b", Rank: 2}, {ID: "c", Rank: 3}}
+	if err := adapter.DB.DB.Create(&rows).Error; err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	legacy := base64.StdEncoding.EncodeToString([]byte("a"))
+
+	tests := []struct {
+		name    string
+		sortKey string
+		cursor  string
+		want    string
+		wantErr bool
+	}{
+		{name: "single ascending column", sortKey: "id", cursor: legacy, want: "bc"},
+		{name: "descending column", sortKey: "-id", cursor: legacy, wantErr: true},
+		{name: "multiple columns", sortKey: "rank,id", cursor: legacy, wantErr: true},
+		{name: "not base64", sortKey: "id", cursor: "not a cursor!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []cursorRow
+			_, err := adapter.ListContext(context.Background(), &items, tt.sortKey, map[string]any{}, 10, tt.cursor)
+			if tt.wantErr {
+				var badRequest *serviceErrors.BadRequest
+				if !errors.As(err, &badRequest) {
+					t.Fatalf("ListContext(%q) error = %v, want a BadRequest", tt.sortKey, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ListContext() error: %v", err)
+			}
+			got := ""
+			for _, item := range items {
+				got += item.ID
+			}
+			if got != tt.want {
+				t.Errorf("ListContext(%q) = %s, want %s", tt.sortKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryReleasesTimeoutContext(t *testing.T) {
+	adapter := newTestAdapter(t, &queryRow{})
+	adapter.DB.config["query_timeout"] = "1h"
+
+	var statementCtx context.Context
+	err := adapter.DB.DB.Callback().Row().Before("gorm:row").Register("test:capture_context", func(db *gorm.DB) {
+		statementCtx = db.Statement.Context
+	})
+	if err != nil {
+		t.Fatalf("Register() error: %v", err)
+	}
+
+	var rows []queryRow
+	if _, err := adapter.DB.QueryContext(context.Background(), &rows, "SELECT * FROM query_rows", 10, ""); err != nil {
+		t.Fatalf("QueryContext() error: %v", err)
+	}
+	if _, ok := statementCtx.Deadline(); !ok {
+		t.Errorf("the statement ran without the query_timeout deadline")
+	}
+	if statementCtx.Err() == nil {
+		t.Errorf("the statement context is still alive after QueryContext returned")
+	}
+}
+
+type versionedRow struct {
+	ID      string `json:"id" gorm:"primaryKey"`
+	Name    string `json:"name"`
+	Version int64  `json:"version" magic:"version"`
+}
+
+func (versionedRow) TableName() string { return "versioned_rows" }
+
+func TestUpdateRejectsStaleVersions(t *testing.T) {
+	ctx := context.Background()
+	adapter := newTestAdapter(t, &versionedRow{})
+	if err := adapter.CreateContext(ctx, &versionedRow{ID: "1", Name: "created"}); err != nil {
+		t.Fatalf("CreateContext() error: %v", err)
+	}
+	var first, second versionedRow
+	for _, row := range []*versionedRow{&first, &second} {
+		if err := adapter.GetContext(ctx, row, map[string]any{"id": "1"}); err != nil {
+			t.Fatalf("GetContext() error: %v", err)
+		}
+	}
+
+	first.Name = "first"
+	if err := adapter.UpdateContext(ctx, &first, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("UpdateContext() error: %v", err)
+	}
+	if first.Version != second.Version+1 {
+		t.Errorf("version after UpdateContext() = %d, want %d", first.Version, second.Version+1)
+	}
+
+	// second was read before the first update, so its version is stale
+	second.Name = "second"
+	if err := adapter.UpdateContext(ctx, &second, map[string]any{"id": "1"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateContext() with a stale version error = %v, want ErrConflict", err)
+	}
+	if second.Version != first.Version-1 {
+		t.Errorf("version after the conflict = %d, want it left at %d", second.Version, first.Version-1)
+	}
+	var stored versionedRow
+	if err := adapter.GetContext(ctx, &stored, map[string]any{"id": "1"}); err != nil {
+		t.Fatalf("GetContext() error: %v", err)
+	}
+	if stored != first {
+		t.Errorf("stored row = %+v, want the first update %+v", stored, first)
+	}
+}
+
+type softDeleteRow struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	Name      string     `json:"name"`
+	DeletedAt *time.Time `json:"deleted_at" magic:"deleted_at"`
+}
+
+func (softDeleteRow) TableName() string { return "soft_delete_rows" }
+
+func TestSoftDelete(t *testing.T) {
+	adapters := []struct {
+		name    string
+		adapter func(*MemoryAdapter) ContextStorageAdapter
+	}{
+		{name: "memory", adapter: func(m *MemoryAdapter) ContextStorageAdapter { return m }},
+		{name: "sql", adapter: func(m *MemoryAdapter) ContextStorageAdapter { return m.DB }},
+	}
+	tests := []struct {
+		name        string
+		change      func(ctx context.Context, s ContextStorageAdapter) error
+		live        string
+		withDeleted string
+	}{
+		{
+			name: "delete hides the item",
+			change: func(ctx context.Context, s ContextStorageAdapter) error {
+				return s.DeleteContext(ctx, &softDeleteRow{}, map[string]any{"id": "1"})
+			},
+			live:        "2",
+			withDeleted: "12",
+		},
+		{
+			name: "delete of a missing item does nothing",
+			change: func(ctx context.Context, s ContextStorageAdapter) error {
+				return s.DeleteContext(ctx, &softDeleteRow{}, map[string]any{"id": "3"})
+			},
+			live:        "12",
+			withDeleted: "12",
+		},
+		{
+			name: "restore brings the item back",
+			change: func(ctx context.Context, s ContextStorageAdapter) error {
+				if err := s.DeleteContext(ctx, &softDeleteRow{}, map[string]any{"id": "1"}); err != nil {
+					return err
+				}
+				return Restore(ctx, s, &softDeleteRow{}, map[string]any{"id": "1"})
+			},
+			live:        "12",
+			withDeleted: "12",
+		},
+		{
+			name: "purge of a deleted item removes it for good",
+			change: func(ctx context.Context, s ContextStorageAdapter) error {
+				if err := s.DeleteContext(ctx, &softDeleteRow{}, map[string]any{"id": "1"}); err != nil {
+					return err
+				}
+				return Purge(ctx, s, &softDeleteRow{}, map[string]any{"id": "1"})
+			},
+			live:        "2",
+			withDeleted: "2",
+		},
+		{
+			name: "purge of a live item removes it for good",
+			change: func(ctx context.Context, s ContextStorageAdapter) error {
+				return Purge(ctx, s, &softDeleteRow{}, map[string]any{"id": "2"})
+			},
+			live:        "1",
+			withDeleted: "1",
+		},
+	}
+	for _, a := range adapters {
+		for _, tt := range tests {
+			t.Run(a.name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				s := a.adapter(newTestAdapter(t, &softDeleteRow{}))
+				for _, id := range []string{"1", "2"} {
+					if err := s.CreateContext(ctx, &softDeleteRow{ID: id, Name: "row"}); err != nil {
+						t.Fatalf("CreateContext() error: %v", err)
+					}
+				}
+				if err := tt.change(ctx, s); err != nil {
+					t.Fatalf("change error: %v", err)
+				}
+
+				for _, read := range []struct {
+					params []map[string]any
+					want   string
+				}{
+					{want: tt.live},
+					{params: []map[string]any{{INCLUDE_DELETED: true}}, want: tt.withDeleted},
+				} {
+					var listed []softDeleteRow
+					if _, err := s.ListContext(ctx, &listed, "id", map[string]any{}, 10, "", read.params...); err != nil {
+						t.Fatalf("ListContext() error: %v", err)
+					}
+					var searched []softDeleteRow
+					if _, err := s.SearchContext(ctx, &searched, "id", "name:row", 10, "", read.params...); err != nil {
+						t.Fatalf("SearchContext() error: %v", err)
+					}
+					gotListed, gotSearched := "", ""
+					for _, item := range listed {
+						gotListed += item.ID
+					}
+					for _, item := range searched {
+						gotSearched += item.ID
+					}
+					if gotListed != read.want || gotSearched != read.want {
+						t.Errorf("ListContext(%v) = %s and SearchContext() = %s, want %s", read.params, gotListed, gotSearched, read.want)
+					}
+					count, err := s.CountContext(ctx, &softDeleteRow{}, map[string]any{}, read.params...)
+					if err != nil || count != int64(len(read.want)) {
+						t.Errorf("CountContext(%v) = %d, %v, want %d", read.params, count, err, len(read.want))
+					}
+					for _, id := range []string{"1", "2"} {
+						err := s.GetContext(ctx, &softDeleteRow{}, map[string]any{"id": id}, read.params...)
+						if visible := strings.Contains(read.want, id); visible != (err == nil) || (!visible && !errors.Is(err, ErrNotFound)) {
+							t.Errorf("GetContext(%s, %v) error = %v, want the item only if it's in %s", id, read.params, err, read.want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+type expiringRow struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	ExpiresAt *time.Time `json:"expires_at" magic:"expires_at"`
+}
+
+func (expiringRow) TableName() string { return "expiring_rows" }
+
+func TestExpiredRowsAreHiddenUntilSwept(t *testing.T) {
+	ctx := context.Background()
+	adapter := newTestAdapter(t, &expiringRow{})
+	expired := time.Now().Add(-time.Minute)
+	if err := adapter.CreateContext(ctx, &expiringRow{ID: "expired", ExpiresAt: &expired}); err != nil {
+		t.Fatalf("CreateContext() error: %v", err)
+	}
+	if err := adapter.CreateContext(ctx, &expiringRow{ID: "live"}, map[string]any{TTL: "1h"}); err != nil {
+		t.Fatalf("CreateContext() with ttl error: %v", err)
+	}
+	stored := func() int64 {
+		var total int64
+		if err := adapter.DB.DB.Model(&expiringRow{}).Count(&total).Error; err != nil {
+			t.Fatalf("Count() error: %v", err)
+		}
+		return total
+	}
+
+	if err := adapter.GetContext(ctx, &expiringRow{}, map[string]any{"id": "expired"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetContext() of the expired row error = %v, want ErrNotFound", err)
+	}
+	var live expiringRow
+	if err := adapter.GetContext(ctx, &live, map[string]any{"id": "live"}); err != nil || live.ExpiresAt == nil {
+		t.Errorf("GetContext() of the live row = %+v, %v, want it with an expiry time", live, err)
+	}
+	var rows []expiringRow
+	if _, err := adapter.ListContext(ctx, &rows, "id", map[string]any{}, 10, ""); err != nil || len(rows) != 1 || rows[0].ID != "live" {
+		t.Errorf("ListContext() = %+v, %v, want only the live row", rows, err)
+	}
+	if count, err := adapter.CountContext(ctx, &expiringRow{}, map[string]any{}); err != nil || count != 1 {
+		t.Errorf("CountContext() = %d, %v, want 1", count, err)
+	}
+	if total := stored(); total != 2 {
+		t.Fatalf("%d rows stored before the sweep, want the expired row kept until then", total)
+	}
+
+	// The reads above registered the model with the sweeper
+	adapter.DB.sweeper.sweep()
+	if total := stored(); total != 1 {
+		t.Errorf("%d rows stored after the sweep, want the expired row removed", total)
+	}
+	if deleted, err := adapter.DeleteExpired(ctx, &expiringRow{}); err != nil || deleted != 0 {
+		t.Errorf("DeleteExpired() = %d, %v, want nothing left to delete", deleted, err)
+	}
+}
+
+type patchRow struct {
+	ID      string   `json:"id" gorm:"primaryKey"`
+	Name    string   `json:"name"`
+	Views   int      `json:"views"`
+	Tags    []string `json:"tags" gorm:"serializer:json"`
+	Version int64    `json:"version" magic:"version"`
+}
+
+func (patchRow) TableName() string { return "patch_rows" }
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		changes map[string]any
+		want    patchRow
+		wantErr error
+	}{
+		{
+			name:    "set a field",
+			id:      "tagged",
+			changes: map[string]any{"name": "renamed"},
+			want:    patchRow{ID: "tagged", Name: "renamed", Views: 2, Tags: []string{"a"}, Version: 2},
+		},
+		{
+			name:    "increment a field",
+			id:      "tagged",
+			changes: map[string]any{"views": Increment(3)},
+			want:    patchRow{ID: "tagged", Name: "row", Views: 5, Tags: []string{"a"}, Version: 2},
+		},
+		{
+			name:    "increment a field by its Go name",
+			id:      "tagged",
+			changes: map[string]any{"Views": Increment(3)},
+			want:    patchRow{ID: "tagged", Name: "row", Views: 5, Tags: []string{"a"}, Version: 2},
+		},
+		{
+			name:    "append to an existing list",
+			id:      "tagged",
+			changes: map[string]any{"tags": Append("b", "c")},
+			want:    patchRow{ID: "tagged", Name: "row", Views: 2, Tags: []string{"a", "b", "c"}, Version: 2},
+		},
+		{
+			name:    "append to a missing list",
+			id:      "untagged",
+			changes: map[string]any{"tags": Append("b")},
+			want:    patchRow{ID: "untagged", Name: "row", Tags: []string{"b"}, Version: 2},
+		},
+		{
+			name:    "several changes at once",
+			id:      "untagged",
+			changes: map[string]any{"name": "renamed", "views": Increment(1), "tags": Append("b")},
+			want:    patchRow{ID: "untagged", Name: "renamed", Views: 1, Tags: []string{"b"}, Version: 2},
+		},
+		{
+			name:    "missing item",
+			id:      "missing",
+			changes: map[string]any{"name": "renamed"},
+			wantErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			adapter := newTestAdapter(t, &patchRow{})
+			if err := adapter.DB.DB.Create([]patchRow{
+				{ID: "tagged", Name: "row", Views: 2, Tags: []string{"a"}, Version: 1},
+				{ID: "untagged", Name: "row", Version: 1},
+			}).Error; err != nil {
+				t.Fatalf("Create() error: %v", err)
+			}
+
+			var patched patchRow
+			err := adapter.Patch(ctx, &patched, map[string]any{"id": tt.id}, tt.changes)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Patch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Patch() error: %v", err)
+			}
+			var stored patchRow
+			if err := adapter.GetContext(ctx, &stored, map[string]any{"id": tt.id}); err != nil {
+				t.Fatalf("GetContext() error: %v", err)
+			}
+			for _, got := range []patchRow{patched, stored} {
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("Patch() = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectValidatesTheSweepIntervalFirst(t *testing.T) {
+	s := &SQLAdapter{config: map[string]string{"provider": "sqlite", "ttl_sweep_interval": "soon"}}
+	if err := s.Connect(); err == nil {
+		t.Fatalf("Connect() with an invalid ttl_sweep_interval succeeded")
+	}
+	if s.DB != nil || s.replicas != nil {
+		t.Errorf("Connect() opened connections before failing on ttl_sweep_interval")
+	}
+}
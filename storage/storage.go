@@ -1,12 +1,22 @@
 package storage
 
 import (
+	"context"
 	"embed"
 	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
 )
 
 var ConfigFs embed.FS
 var ErrNotFound = errors.New("the requested resource was not found")
+var ErrConflict = errors.New("the resource was modified by another request, reload it and try again")
+var ErrAlreadyExists = errors.New("the resource already exists")
+var ErrPreconditionFailed = errors.New("a precondition of the request was not met")
+var ErrThrottled = errors.New("too many requests, slow down and try again later")
+var ErrUnavailable = errors.New("the storage backend is temporarily unavailable, try again later")
 
 type StorageAdapter interface {
 	Execute(statement string) error
@@ -28,6 +38,223 @@ type StorageAdapter interface {
 	Query(dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error)
 }
 
+// ContextStorageAdapter is a StorageAdapter whose data access methods also accept a context.Context,
+// allowing request cancellation and deadlines to propagate down to the database.
+// The methods without a context are equivalent to calling these with context.Background()
+type ContextStorageAdapter interface {
+	StorageAdapter
+	ExecuteContext(ctx context.Context, statement string) error
+	PingContext(ctx context.Context) error
+	CreateContext(ctx context.Context, item any, params ...map[string]any) error
+	GetContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) error
+	UpdateContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error
+	DeleteContext(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error
+	ListContext(ctx context.Context, dest any, sortKey string, filter map[string]any, limit int, cursor string, params ...map[string]any) (string, error)
+	SearchContext(ctx context.Context, dest any, sortKey string, query string, limit int, cursor string, params ...map[string]any) (string, error)
+	CountContext(ctx context.Context, dest any, filter map[string]any, params ...map[string]any) (int64, error)
+	QueryContext(ctx context.Context, dest any, statement string, limit int, cursor string, params ...map[string]any) (string, error)
+}
+
+// TransactionalStorageAdapter is a StorageAdapter that can group several write operations so they
+// are applied atomically. fn receives a StorageAdapter bound to the transaction; if fn returns an error
+// (or panics) nothing is applied, otherwise all operations performed through tx are committed together
+type TransactionalStorageAdapter interface {
+	StorageAdapter
+	WithTransaction(ctx context.Context, fn func(tx StorageAdapter) error) error
+}
+
+// NotSupportedError is returned when an adapter doesn't support the requested operation
+type NotSupportedError struct {
+	Adapter   StorageAdapterType
+	Operation string
+}
+
+func (e *NotSupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by the %s storage adapter", e.Operation, e.Adapter)
+}
+
+// WithTransaction runs fn inside a transaction on s, returning a NotSupportedError if s
+// doesn't implement TransactionalStorageAdapter
+func WithTransaction(ctx context.Context, s StorageAdapter, fn func(tx StorageAdapter) error) error {
+	t, ok := s.(TransactionalStorageAdapter)
+	if !ok {
+		return &NotSupportedError{Adapter: s.GetType(), Operation: "transactions"}
+	}
+	return t.WithTransaction(ctx, fn)
+}
+
+// BatchResult reports the outcome of a single item of a batch operation, Index is the position of the
+// item in the batch and Err is nil if the item was processed successfully
+type BatchResult struct {
+	Index int
+	Err   error
+}
+
+// BatchStorageAdapter is a StorageAdapter that can write many items with as few round trips as possible.
+// items must be a slice (or a pointer to one) of the model type. Each call returns one BatchResult per
+// item, in input order, so partial failures are visible; the returned error is only set when the batch
+// couldn't be processed at all. Batches are not atomic, use WithTransaction for that
+type BatchStorageAdapter interface {
+	StorageAdapter
+	BatchCreate(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error)
+	BatchUpsert(ctx context.Context, items any, params ...map[string]any) ([]BatchResult, error)
+	BatchDelete(ctx context.Context, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error)
+}
+
+// BatchCreate creates items using s.BatchCreate when s is a BatchStorageAdapter, and one item at a time otherwise
+func BatchCreate(ctx context.Context, s StorageAdapter, items any, params ...map[string]any) ([]BatchResult, error) {
+	if b, ok := s.(BatchStorageAdapter); ok {
+		return b.BatchCreate(ctx, items, params...)
+	}
+	c := asContextStorageAdapter(s)
+	return eachBatchItem(items, func(item any) error {
+		return c.CreateContext(ctx, item, params...)
+	})
+}
+
+// BatchUpsert creates or replaces items using s.BatchUpsert, returning a NotSupportedError if s isn't a BatchStorageAdapter
+func BatchUpsert(ctx context.Context, s StorageAdapter, items any, params ...map[string]any) ([]BatchResult, error) {
+	if b, ok := s.(BatchStorageAdapter); ok {
+		return b.BatchUpsert(ctx, items, params...)
+	}
+	return nil, &NotSupportedError{Adapter: s.GetType(), Operation: "BatchUpsert"}
+}
+
+// BatchDelete deletes the items matching each of filters using s.BatchDelete when s is a BatchStorageAdapter,
+// and one item at a time otherwise
+func BatchDelete(ctx context.Context, s StorageAdapter, item any, filters []map[string]any, params ...map[string]any) ([]BatchResult, error) {
+	if b, ok := s.(BatchStorageAdapter); ok {
+		return b.BatchDelete(ctx, item, filters, params...)
+	}
+	c := asContextStorageAdapter(s)
+	results := make([]BatchResult, len(filters))
+	for i, filter := range filters {
+		results[i] = BatchResult{Index: i, Err: c.DeleteContext(ctx, item, filter, params...)}
+	}
+	return results, nil
+}
+
+// BatchErrors joins the errors of all failed items in results, it returns nil if every item succeeded
+func BatchErrors(results []BatchResult) error {
+	errs := []error{}
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", r.Index, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// INCLUDE_DELETED is the param that makes Get, List, Search and Count also return soft deleted items
+const INCLUDE_DELETED = "include_deleted"
+
+// TTL is the param setting how long the items written by Create, Update, BatchCreate and BatchUpsert live, as a
+// time.Duration, a duration string such as "24h" or a number of seconds. It sets the *time.Time field of the
+// model tagged magic:"expires_at", which can also be set directly. Reads never return expired items, adapters
+// physically remove them in the background
+const TTL = "ttl"
+
+// SoftDeleteStorageAdapter is a StorageAdapter supporting soft deletes. Models opt in with a *time.Time field
+// tagged magic:"deleted_at", Delete then only sets that field to the deletion time and Get, List, Search and
+// Count skip the items where it's set unless the INCLUDE_DELETED param is true. Restore clears the field of a
+// soft deleted item and Purge deletes an item for good. Models without the tag are always deleted for good
+type SoftDeleteStorageAdapter interface {
+	StorageAdapter
+	Restore(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error
+	Purge(ctx context.Context, item any, filter map[string]any, params ...map[string]any) error
+}
+
+// Restore undeletes the soft deleted item matching filter using s.Restore, returning a NotSupportedError if s
+// isn't a SoftDeleteStorageAdapter
+func Restore(ctx context.Context, s StorageAdapter, item any, filter map[string]any, params ...map[string]any) error {
+	if d, ok := s.(SoftDeleteStorageAdapter); ok {
+		return d.Restore(ctx, item, filter, params...)
+	}
+	return &NotSupportedError{Adapter: s.GetType(), Operation: "Restore"}
+}
+
+// Purge deletes the item matching filter for good using s.Purge. When s isn't a SoftDeleteStorageAdapter items
+// of models without soft deletes are deleted with Delete, and a NotSupportedError is returned otherwise
+func Purge(ctx context.Context, s StorageAdapter, item any, filter map[string]any, params ...map[string]any) error {
+	if d, ok := s.(SoftDeleteStorageAdapter); ok {
+		return d.Purge(ctx, item, filter, params...)
+	}
+	if getModelMetadata(item).field("deleted_at") == nil {
+		return asContextStorageAdapter(s).DeleteContext(ctx, item, filter, params...)
+	}
+	return &NotSupportedError{Adapter: s.GetType(), Operation: "Purge"}
+}
+
+// Patch operations other than setting a field to a value
+const (
+	PATCH_INCREMENT = "increment"
+	PATCH_APPEND    = "append"
+)
+
+// PatchOperation is a change of Patch computed from the stored value of the field, see Increment and Append
+type PatchOperation struct {
+	Op    string
+	Value any
+}
+
+// Increment returns the change adding delta to a numeric field, a missing field counts as 0
+func Increment(delta any) PatchOperation {
+	return PatchOperation{Op: PATCH_INCREMENT, Value: delta}
+}
+
+// Append returns the change appending values to a list field
+func Append(values ...any) PatchOperation {
+	return PatchOperation{Op: PATCH_APPEND, Value: values}
+}
+
+// PatchStorageAdapter is a StorageAdapter that can change some fields of an item in a single atomic write, without
+// reading it first. changes maps the names of the fields, as used in filters, to their new value or to a
+// PatchOperation. item selects the model and is filled with the patched item. Patch returns ErrNotFound if no item
+// matches filter and increments the version of versioned items
+type PatchStorageAdapter interface {
+	StorageAdapter
+	Patch(ctx context.Context, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error
+}
+
+// Patch changes some fields of the item matching filter using s.Patch, returning a NotSupportedError if s isn't a
+// PatchStorageAdapter
+func Patch(ctx context.Context, s StorageAdapter, item any, filter map[string]any, changes map[string]any, params ...map[string]any) error {
+	if p, ok := s.(PatchStorageAdapter); ok {
+		return p.Patch(ctx, item, filter, changes, params...)
+	}
+	return &NotSupportedError{Adapter: s.GetType(), Operation: "Patch"}
+}
+
+// batchItems returns pointers to each of the elements of the slice items
+func batchItems(items any) ([]any, error) {
+	v := reflect.Indirect(reflect.ValueOf(items))
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a slice of items, got %T", items)
+	}
+	result := make([]any, v.Len())
+	for i := range result {
+		e := v.Index(i)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		}
+		result[i] = e.Interface()
+	}
+	return result, nil
+}
+
+// eachBatchItem calls fn for every element of the slice items and collects the results
+func eachBatchItem(items any, fn func(item any) error) ([]BatchResult, error) {
+	elements, err := batchItems(items)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(elements))
+	for i, item := range elements {
+		results[i] = BatchResult{Index: i, Err: fn(item)}
+	}
+	return results, nil
+}
+
 type StorageAdapterType string
 type StorageProviders string
 type StorageAdapterFactory struct{}
@@ -46,20 +273,90 @@ const (
 	COSMOSDB_PROVIDER StorageProviders = "cosmosdb"
 )
 
+var namedInstancesLock = &sync.Mutex{}
+var namedInstances = map[string]StorageAdapter{}
+
+// GetInstance returns the process-wide instance of adapterType, creating it with config on first use.
+// config may be nil, a map[string]string or the adapter's configuration struct (SQLConfig, DynamoDBConfig
+// or CosmosDBConfig), in which case every missing or invalid field is reported in a *config.ValidationError
 func (s StorageAdapterFactory) GetInstance(adapterType StorageAdapterType, config any) (StorageAdapter, error) {
-	if config == nil {
-		config = make(map[string]string)
+	c, err := adapterConfig(adapterType, config)
+	if err != nil {
+		return nil, err
+	}
+	switch adapterType {
+	case MEMORY:
+		return getMemoryAdapterInstance()
+	case SQL:
+		return getSQLAdapterInstance(c)
+	case DYNAMODB:
+		return getDynamoDBAdapterInstance(c)
+	case COSMOSDB:
+		return getCosmosDBAdapterInstance(c)
+	default:
+		return nil, errors.New("this storage adapter type isn't supported")
+	}
+}
+
+// NewInstance returns a new, independent instance of adapterType configured like GetInstance. Every call
+// opens its own connection, memory adapters each get their own empty database
+func (s StorageAdapterFactory) NewInstance(adapterType StorageAdapterType, config any) (StorageAdapter, error) {
+	c, err := adapterConfig(adapterType, config)
+	if err != nil {
+		return nil, err
 	}
 	switch adapterType {
 	case MEMORY:
-		return GetMemoryAdapterInstance(), nil
+		return NewMemoryAdapter()
 	case SQL:
-		return GetSQLAdapterInstance(config.(map[string]string)), nil
+		return NewSQLAdapter(c)
 	case DYNAMODB:
-		return GetDynamoDBAdapterInstance(config.(map[string]string)), nil
+		return NewDynamoDBAdapter(c)
 	case COSMOSDB:
-		return GetCosmosDBAdapterInstance(config.(map[string]string)), nil
+		return NewCosmosDBAdapter(c)
 	default:
 		return nil, errors.New("this storage adapter type isn't supported")
 	}
 }
+
+// GetNamedInstance returns the instance cached under name, such as "primary" or "analytics", creating it
+// with NewInstance on first use. Calls with a name that is already cached return the cached instance and
+// ignore adapterType and config
+func (s StorageAdapterFactory) GetNamedInstance(name string, adapterType StorageAdapterType, config any) (StorageAdapter, error) {
+	namedInstancesLock.Lock()
+	defer namedInstancesLock.Unlock()
+	if instance, ok := namedInstances[name]; ok {
+		return instance, nil
+	}
+	instance, err := s.NewInstance(adapterType, config)
+	if err != nil {
+		return nil, err
+	}
+	namedInstances[name] = instance
+	return instance, nil
+}
+
+// CloseNamedInstance closes the instance cached under name and removes it from the cache, it does nothing
+// if no instance is cached under name
+func (s StorageAdapterFactory) CloseNamedInstance(name string) error {
+	namedInstancesLock.Lock()
+	instance, ok := namedInstances[name]
+	delete(namedInstances, name)
+	namedInstancesLock.Unlock()
+	if !ok {
+		return nil
+	}
+	if c, ok := instance.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// copyConfig returns a copy of config so adapters can't modify a map shared with other instances
+func copyConfig(config map[string]string) map[string]string {
+	c := make(map[string]string, len(config))
+	for k, v := range config {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type txRow struct {
+	ID   string `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+}
+
+func TestWithTransactionRollsBackBatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch func(ctx context.Context, tx BatchStorageAdapter) error
+	}{
+		{
+			name: "BatchCreate",
+			batch: func(ctx context.Context, tx BatchStorageAdapter) error {
+				results, err := tx.BatchCreate(ctx, []txRow{{ID: "3", Name: "c"}, {ID: "4", Name: "d"}})
+				return errors.Join(err, BatchErrors(results))
+			},
+		},
+		{
+			name: "BatchUpsert",
+			batch: func(ctx context.Context, tx BatchStorageAdapter) error {
+				results, err := tx.BatchUpsert(ctx, []txRow{{ID: "1", Name: "changed"}, {ID: "3", Name: "c"}})
+				return errors.Join(err, BatchErrors(results))
+			},
+		},
+		{
+			name: "BatchDelete",
+			batch: func(ctx context.Context, tx BatchStorageAdapter) error {
+				results, err := tx.BatchDelete(ctx, &txRow{}, []map[string]any{{"id": "1"}, {"id": "2"}})
+				return errors.Join(err, BatchErrors(results))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := newTestAdapter(t, &txRow{})
+			ctx := context.Background()
+			if _, err := adapter.BatchCreate(ctx, []txRow{{ID: "1", Name: "a"}, {ID: "2", Name: "b"}}); err != nil {
+				t.Fatalf("BatchCreate() error: %v", err)
+			}
+
+			failure := errors.New("failure")
+			err := adapter.WithTransaction(ctx, func(tx StorageAdapter) error {
+				batch, ok := tx.(BatchStorageAdapter)
+				if !ok {
+					t.Fatalf("the transaction of %T isn't a BatchStorageAdapter", adapter)
+				}
+				if err := tt.batch(ctx, batch); err != nil {
+					t.Fatalf("%s() error: %v", tt.name, err)
+				}
+				return failure
+			})
+			if !errors.Is(err, failure) {
+				t.Fatalf("WithTransaction() error = %v, want %v", err, failure)
+			}
+
+			var rows []txRow
+			if _, err := adapter.ListContext(ctx, &rows, "id", map[string]any{}, 10, ""); err != nil {
+				t.Fatalf("List() error: %v", err)
+			}
+			if len(rows) != 2 || rows[0] != (txRow{ID: "1", Name: "a"}) || rows[1] != (txRow{ID: "2", Name: "b"}) {
+				t.Errorf("rows after the rolled back transaction = %+v, want the rows created before it", rows)
+			}
+		})
+	}
+}
+
+func TestDynamoDBTransactionBuffersBatches(t *testing.T) {
+	ctx := context.Background()
+	tx := &dynamoDBTransaction{DynamoDBAdapter: &DynamoDBAdapter{config: map[string]string{}}}
+
+	if _, err := tx.BatchCreate(ctx, []txRow{{ID: "1"}, {ID: "2"}}); err != nil {
+		t.Fatalf("BatchCreate() error: %v", err)
+	}
+	if _, err := tx.BatchUpsert(ctx, []txRow{{ID: "3"}}); err != nil {
+		t.Fatalf("BatchUpsert() error: %v", err)
+	}
+	if _, err := tx.BatchDelete(ctx, &txRow{}, []map[string]any{{"id": "4"}}); err != nil {
+		t.Fatalf("BatchDelete() error: %v", err)
+	}
+	if len(tx.items) != 4 {
+		t.Fatalf("buffered %d items, want 4", len(tx.items))
+	}
+
+	// Batches count towards the transaction limit
+	rows := make([]txRow, DYNAMODB_MAX_TRANSACTION_ITEMS)
+	results, err := tx.BatchCreate(ctx, rows)
+	if err != nil {
+		t.Fatalf("BatchCreate() error: %v", err)
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if len(tx.items) != DYNAMODB_MAX_TRANSACTION_ITEMS || failed != 4 {
+		t.Errorf("buffered %d items with %d failures, want %d items and 4 failures", len(tx.items), failed, DYNAMODB_MAX_TRANSACTION_ITEMS)
+	}
+}
@@ -70,6 +70,62 @@ const definitions = `
 			}
 			}
 		},
+		"Conflict": {
+			"description": "The resource was modified by another request",
+			"content": {
+			"application/json": {
+				"schema": {
+				"$ref": "#/components/schemas/Error"
+				},
+				"example": {
+				"status": "Conflict",
+				"error": "the resource was modified by another request, reload it and try again"
+				}
+			}
+			}
+		},
+		"PreconditionFailed": {
+			"description": "A precondition of the request was not met",
+			"content": {
+			"application/json": {
+				"schema": {
+				"$ref": "#/components/schemas/Error"
+				},
+				"example": {
+				"status": "Precondition Failed",
+				"error": "a precondition of the request was not met"
+				}
+			}
+			}
+		},
+		"TooManyRequests": {
+			"description": "Too many requests were made, slow down and try again later",
+			"content": {
+			"application/json": {
+				"schema": {
+				"$ref": "#/components/schemas/Error"
+				},
+				"example": {
+				"status": "Too Many Requests",
+				"error": "too many requests, slow down and try again later"
+				}
+			}
+			}
+		},
+		"ServiceUnavailable": {
+			"description": "The service is temporarily unavailable",
+			"content": {
+			"application/json": {
+				"schema": {
+				"$ref": "#/components/schemas/Error"
+				},
+				"example": {
+				"status": "Service Unavailable",
+				"error": "the storage backend is temporarily unavailable, try again later"
+				}
+			}
+			}
+		},
 		"ServerError": {
 			"description": "There was an unexpected server error",
 			"content": {